@@ -10,40 +10,110 @@ import (
 	"github.com/aws/aws-sdk-go-v2/service/cloudwatchlogs"
 	"github.com/aws/aws-sdk-go-v2/service/ecr"
 	"github.com/aws/aws-sdk-go-v2/service/iam"
+	"github.com/aws/aws-sdk-go-v2/service/kms"
 	"github.com/aws/aws-sdk-go-v2/service/lambda"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/scheduler"
 	"github.com/aws/aws-sdk-go-v2/service/sqs"
 	"github.com/aws/aws-sdk-go-v2/service/sts"
 )
 
 type AWS struct {
 	Config         aws.Config
-	STS            *sts.Client
+	STS            STSAPI
 	ECR            *ecr.Client
-	IAM            *iam.Client
-	SQS            *sqs.Client
-	Lambda         *lambda.Client
+	IAM            IAMAPI
+	SQS            SQSAPI
+	S3             S3API
+	KMS            KMSAPI
+	Scheduler      SchedulerAPI
+	Lambda         LambdaAPI
 	CloudWatch     *cloudwatch.Client
-	CloudWatchLogs *cloudwatchlogs.Client
+	CloudWatchLogs CloudWatchLogsAPI
 }
 
-func New(profile string) *AWS {
-	config := Config(profile)
+// New loads the aws configuration for the profile, the default one when empty, and
+// creates the clients of every service elaston uses
+func New(profile string, opts ...Option) (*AWS, error) {
+	settings := options{}
+	for _, opt := range opts {
+		opt(&settings)
+	}
+
+	config, err := loadConfig(profile, &settings)
+	if err != nil {
+		return nil, err
+	}
+	s3Client := s3.NewFromConfig(config, func(o *s3.Options) {
+		// Emulators serve every bucket from the same host
+		o.UsePathStyle = settings.endpoint(s3.ServiceID) != ""
+	})
 	return &AWS{
 		Config:         config,
 		STS:            sts.NewFromConfig(config),
 		ECR:            ecr.NewFromConfig(config),
 		IAM:            iam.NewFromConfig(config),
 		SQS:            sqs.NewFromConfig(config),
+		S3:             s3Client,
+		KMS:            kms.NewFromConfig(config),
+		Scheduler:      scheduler.NewFromConfig(config),
 		Lambda:         lambda.NewFromConfig(config),
 		CloudWatch:     cloudwatch.NewFromConfig(config),
 		CloudWatchLogs: cloudwatchlogs.NewFromConfig(config),
+	}, nil
+}
+
+// Config loads the aws configuration for the profile, the default one when empty
+func Config(profile string, opts ...Option) (aws.Config, error) {
+	settings := options{}
+	for _, opt := range opts {
+		opt(&settings)
 	}
+	return loadConfig(profile, &settings)
 }
 
-func Config(profile string) aws.Config {
-	cfg, err := config.LoadDefaultConfig(context.Background(), config.WithSharedConfigProfile(profile))
+func loadConfig(profile string, opts *options) (aws.Config, error) {
+	loadOptions := []func(*config.LoadOptions) error{config.WithSharedConfigProfile(profile)}
+	if opts.region != "" {
+		loadOptions = append(loadOptions, config.WithRegion(opts.region))
+	}
+	if opts.credentials != nil {
+		loadOptions = append(loadOptions, config.WithCredentialsProvider(opts.credentials))
+	}
+	if opts.httpClient != nil {
+		loadOptions = append(loadOptions, config.WithHTTPClient(opts.httpClient))
+	}
+	if opts.retryer != nil {
+		loadOptions = append(loadOptions, config.WithRetryer(opts.retryer))
+	}
+	if len(opts.endpoints) > 0 || opts.defaultEndpoint != "" {
+		resolver := aws.EndpointResolverWithOptionsFunc(func(service, region string, _ ...any) (aws.Endpoint, error) {
+			url := opts.endpoint(service)
+			if url == "" {
+				return aws.Endpoint{}, &aws.EndpointNotFoundError{}
+			}
+			return aws.Endpoint{URL: url, SigningRegion: region, HostnameImmutable: true, Source: aws.EndpointSourceCustom}, nil
+		})
+		loadOptions = append(loadOptions, config.WithEndpointResolverWithOptions(resolver))
+	}
+
+	cfg, err := config.LoadDefaultConfig(context.Background(), loadOptions...)
 	if err != nil {
-		panic(fmt.Errorf("failed to load config: %w", err))
+		return aws.Config{}, fmt.Errorf("failed to load config: %w", err)
 	}
-	return cfg
+	return cfg, nil
+}
+
+// services are the ids of the services elaston uses, see WithEnvironmentEndpoints
+var services = []string{
+	sts.ServiceID,
+	ecr.ServiceID,
+	iam.ServiceID,
+	sqs.ServiceID,
+	s3.ServiceID,
+	kms.ServiceID,
+	scheduler.ServiceID,
+	lambda.ServiceID,
+	cloudwatch.ServiceID,
+	cloudwatchlogs.ServiceID,
 }
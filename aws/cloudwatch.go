@@ -2,6 +2,8 @@ package aws
 
 import (
 	"context"
+	"errors"
+	"time"
 
 	"github.com/aws/aws-sdk-go-v2/service/cloudwatchlogs"
 	cwlT "github.com/aws/aws-sdk-go-v2/service/cloudwatchlogs/types"
@@ -16,3 +18,27 @@ func (aws *AWS) ListLogStreams(ctx context.Context, logGroup string) ([]cwlT.Log
 	}
 	return out.LogStreams, nil
 }
+
+// FilterLogEvents returns the events of every stream in the log group since the given
+// time, oldest first. A log group that does not exist yet, as for functions never
+// invoked, has no events
+func (aws *AWS) FilterLogEvents(ctx context.Context, logGroup string, since time.Time) ([]cwlT.FilteredLogEvent, error) {
+	startTime := since.UnixMilli()
+	paginator := cloudwatchlogs.NewFilterLogEventsPaginator(aws.CloudWatchLogs, &cloudwatchlogs.FilterLogEventsInput{
+		LogGroupName: &logGroup,
+		StartTime:    &startTime,
+	})
+	events := []cwlT.FilteredLogEvent{}
+	for paginator.HasMorePages() {
+		page, err := paginator.NextPage(ctx)
+		if err != nil {
+			var notFound *cwlT.ResourceNotFoundException
+			if errors.As(err, &notFound) {
+				return nil, nil
+			}
+			return nil, err
+		}
+		events = append(events, page.Events...)
+	}
+	return events, nil
+}
@@ -0,0 +1,32 @@
+package aws
+
+import (
+	"context"
+
+	"github.com/aws/aws-sdk-go-v2/service/kms"
+	kmsT "github.com/aws/aws-sdk-go-v2/service/kms/types"
+)
+
+// GenerateDataKey returns a new 256 bit data key, both in plaintext and encrypted
+// under the given kms key
+func (aws *AWS) GenerateDataKey(ctx context.Context, keyID string) ([]byte, []byte, error) {
+	out, err := aws.KMS.GenerateDataKey(ctx, &kms.GenerateDataKeyInput{
+		KeyId:   &keyID,
+		KeySpec: kmsT.DataKeySpecAes256,
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+	return out.Plaintext, out.CiphertextBlob, nil
+}
+
+// DecryptDataKey decrypts a data key returned by GenerateDataKey
+func (aws *AWS) DecryptDataKey(ctx context.Context, encryptedKey []byte) ([]byte, error) {
+	out, err := aws.KMS.Decrypt(ctx, &kms.DecryptInput{
+		CiphertextBlob: encryptedKey,
+	})
+	if err != nil {
+		return nil, err
+	}
+	return out.Plaintext, nil
+}
@@ -25,6 +25,20 @@ func (aws *AWS) GetLambdaFunction(ctx context.Context, name string) (*lambda.Get
 	return out, nil
 }
 
+// ListLambdaFunctions returns the configuration of every function in the region
+func (aws *AWS) ListLambdaFunctions(ctx context.Context) ([]lambdaT.FunctionConfiguration, error) {
+	paginator := lambda.NewListFunctionsPaginator(aws.Lambda, &lambda.ListFunctionsInput{})
+	functions := []lambdaT.FunctionConfiguration{}
+	for paginator.HasMorePages() {
+		page, err := paginator.NextPage(ctx)
+		if err != nil {
+			return nil, err
+		}
+		functions = append(functions, page.Functions...)
+	}
+	return functions, nil
+}
+
 func (aws *AWS) InvokeLambdaFunction(ctx context.Context, name string, payload any) (*lambda.InvokeWithResponseStreamEventStream, error) {
 	payloadBytes := []byte{}
 	if payload != nil {
@@ -62,3 +76,13 @@ func (aws *AWS) LambdaFunctionLogsConsoleURL(functionName string) string {
 		region, region, functionNameEncoded,
 	)
 }
+
+// LambdaFunctionARN builds the arn of the function with the given name, which does not
+// need to exist yet
+func (aws *AWS) LambdaFunctionARN(ctx context.Context, name string) (string, error) {
+	account, err := aws.Account(ctx)
+	if err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("arn:aws:lambda:%s:%s:function:%s", aws.Config.Region, account, name), nil
+}
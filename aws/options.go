@@ -0,0 +1,104 @@
+package aws
+
+import (
+	"os"
+	"strings"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/credentials"
+)
+
+type Option func(*options)
+
+type options struct {
+	region          string
+	credentials     aws.CredentialsProvider
+	endpoints       map[string]string
+	defaultEndpoint string
+	httpClient      aws.HTTPClient
+	retryer         func() aws.Retryer
+}
+
+// WithRegion overrides the region from the profile and the environment
+func WithRegion(region string) Option {
+	return func(o *options) {
+		o.region = region
+	}
+}
+
+// WithStaticCredentials uses the given credentials instead of the ones from the
+// profile and the environment. The session token is optional
+func WithStaticCredentials(accessKeyID string, secretAccessKey string, sessionToken string) Option {
+	return func(o *options) {
+		o.credentials = credentials.NewStaticCredentialsProvider(accessKeyID, secretAccessKey, sessionToken)
+	}
+}
+
+// WithCredentials uses the given credentials provider instead of the ones from the
+// profile and the environment
+func WithCredentials(provider aws.CredentialsProvider) Option {
+	return func(o *options) {
+		o.credentials = provider
+	}
+}
+
+// WithEndpoint sends the requests of a service to url instead of the aws endpoint,
+// eg to a local emulator. The service is the ServiceID constant of its sdk package,
+// eg sqs.ServiceID
+func WithEndpoint(service string, url string) Option {
+	return func(o *options) {
+		if o.endpoints == nil {
+			o.endpoints = map[string]string{}
+		}
+		o.endpoints[service] = url
+	}
+}
+
+// WithDefaultEndpoint sends the requests of every service without an endpoint set by
+// WithEndpoint to url, eg an emulator covering all services
+func WithDefaultEndpoint(url string) Option {
+	return func(o *options) {
+		o.defaultEndpoint = url
+	}
+}
+
+// WithEnvironmentEndpoints reads endpoint overrides from the environment:
+// AWS_ENDPOINT_URL for every service and AWS_ENDPOINT_URL_<SERVICE> for a single one,
+// where <SERVICE> is the ServiceID upper cased with spaces replaced by underscores,
+// eg AWS_ENDPOINT_URL_CLOUDWATCH_LOGS
+func WithEnvironmentEndpoints() Option {
+	return func(o *options) {
+		if url, ok := os.LookupEnv("AWS_ENDPOINT_URL"); ok {
+			o.defaultEndpoint = url
+		}
+		for _, service := range services {
+			key := "AWS_ENDPOINT_URL_" + strings.ToUpper(strings.ReplaceAll(service, " ", "_"))
+			if url, ok := os.LookupEnv(key); ok {
+				WithEndpoint(service, url)(o)
+			}
+		}
+	}
+}
+
+// WithHTTPClient sets the http client used for every request, eg one with custom
+// timeouts or transport
+func WithHTTPClient(client aws.HTTPClient) Option {
+	return func(o *options) {
+		o.httpClient = client
+	}
+}
+
+// WithRetryer sets how the sdk retries failed requests
+func WithRetryer(retryer func() aws.Retryer) Option {
+	return func(o *options) {
+		o.retryer = retryer
+	}
+}
+
+// endpoint returns the endpoint override of the service, if any
+func (o *options) endpoint(service string) string {
+	if url, ok := o.endpoints[service]; ok {
+		return url
+	}
+	return o.defaultEndpoint
+}
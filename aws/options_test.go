@@ -0,0 +1,152 @@
+package aws
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/aws/retry"
+	"github.com/aws/aws-sdk-go-v2/service/cloudwatchlogs"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/sqs"
+)
+
+const testAccount = "123456789012"
+
+func TestEndpoints(t *testing.T) {
+	tests := []struct {
+		name        string
+		options     []Option
+		environment map[string]string
+		// endpoints maps services to the endpoint expected for them
+		endpoints map[string]string
+	}{
+		{name: "aws endpoints", endpoints: map[string]string{sqs.ServiceID: "", s3.ServiceID: ""}},
+		{
+			name:      "service endpoint",
+			options:   []Option{WithEndpoint(sqs.ServiceID, "http://sqs")},
+			endpoints: map[string]string{sqs.ServiceID: "http://sqs", s3.ServiceID: ""},
+		},
+		{
+			name:      "default endpoint",
+			options:   []Option{WithDefaultEndpoint("http://all"), WithEndpoint(sqs.ServiceID, "http://sqs")},
+			endpoints: map[string]string{sqs.ServiceID: "http://sqs", s3.ServiceID: "http://all"},
+		},
+		{
+			name:        "environment endpoints",
+			options:     []Option{WithEnvironmentEndpoints()},
+			environment: map[string]string{"AWS_ENDPOINT_URL": "http://all", "AWS_ENDPOINT_URL_CLOUDWATCH_LOGS": "http://logs"},
+			endpoints:   map[string]string{cloudwatchlogs.ServiceID: "http://logs", s3.ServiceID: "http://all"},
+		},
+		{
+			// Options are applied in order, so later ones win
+			name:        "options after the environment",
+			options:     []Option{WithEnvironmentEndpoints(), WithEndpoint(sqs.ServiceID, "http://sqs")},
+			environment: map[string]string{"AWS_ENDPOINT_URL_SQS": "http://environment"},
+			endpoints:   map[string]string{sqs.ServiceID: "http://sqs", s3.ServiceID: ""},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			for key, value := range tt.environment {
+				t.Setenv(key, value)
+			}
+			settings := options{}
+			for _, opt := range tt.options {
+				opt(&settings)
+			}
+			for service, expected := range tt.endpoints {
+				if endpoint := settings.endpoint(service); endpoint != expected {
+					t.Fatalf("expected endpoint %q for %s, got %q", expected, service, endpoint)
+				}
+			}
+		})
+	}
+}
+
+func TestNew(t *testing.T) {
+	tests := []struct {
+		name    string
+		options []Option
+		// failing makes the server answer with internal errors
+		failing  bool
+		requests int64
+	}{
+		{name: "endpoint", requests: 1},
+		{
+			name:     "retried failures",
+			options:  []Option{WithRetryer(func() aws.Retryer { return retry.AddWithMaxBackoffDelay(retry.NewStandard(), 0) })},
+			failing:  true,
+			requests: 3,
+		},
+		{name: "no retries", options: []Option{WithRetryer(func() aws.Retryer { return aws.NopRetryer{} })}, failing: true, requests: 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// The sdk can only add a custom ca bundle to its own http clients
+			t.Setenv("AWS_CA_BUNDLE", "")
+			server, requests := newSTSServer(t, tt.failing)
+			sent := int64(0)
+			options := append([]Option{
+				WithRegion("eu-west-1"),
+				WithStaticCredentials("key", "secret", ""),
+				WithDefaultEndpoint(server.URL),
+				WithHTTPClient(&http.Client{Transport: roundTripFunc(func(req *http.Request) (*http.Response, error) {
+					atomic.AddInt64(&sent, 1)
+					return http.DefaultTransport.RoundTrip(req)
+				})}),
+			}, tt.options...)
+			client, err := New("", options...)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if client.Config.Region != "eu-west-1" {
+				t.Fatalf("expected region eu-west-1, got %s", client.Config.Region)
+			}
+
+			account, err := client.Account(context.Background())
+			if (err != nil) != tt.failing {
+				t.Fatalf("expected an error to be %v, got %v", tt.failing, err)
+			}
+			if !tt.failing && account != testAccount {
+				t.Fatalf("expected account %s, got %s", testAccount, account)
+			}
+			if got := atomic.LoadInt64(requests); got != tt.requests {
+				t.Fatalf("expected %d requests, got %d", tt.requests, got)
+			}
+			if got := atomic.LoadInt64(&sent); got != tt.requests {
+				t.Fatalf("expected the http client to send %d requests, got %d", tt.requests, got)
+			}
+		})
+	}
+}
+
+type roundTripFunc func(*http.Request) (*http.Response, error)
+
+func (f roundTripFunc) RoundTrip(req *http.Request) (*http.Response, error) {
+	return f(req)
+}
+
+// newSTSServer starts a server answering sts GetCallerIdentity with testAccount, or
+// failing every request. It returns the server and its count of requests
+func newSTSServer(t *testing.T, failing bool) (*httptest.Server, *int64) {
+	t.Helper()
+	requests := int64(0)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt64(&requests, 1)
+		if failing {
+			w.WriteHeader(http.StatusInternalServerError)
+			w.Write([]byte(`<ErrorResponse><Error><Type>Receiver</Type><Code>InternalFailure</Code><Message>failed</Message></Error></ErrorResponse>`))
+			return
+		}
+		w.Header().Set("Content-Type", "text/xml")
+		w.Write([]byte(`<GetCallerIdentityResponse xmlns="https://sts.amazonaws.com/doc/2011-06-15/">` +
+			`<GetCallerIdentityResult><Arn>arn:aws:iam::` + testAccount + `:user/test</Arn><UserId>test</UserId><Account>` + testAccount + `</Account></GetCallerIdentityResult>` +
+			`<ResponseMetadata><RequestId>request</RequestId></ResponseMetadata></GetCallerIdentityResponse>`))
+	}))
+	t.Cleanup(server.Close)
+	return server, &requests
+}
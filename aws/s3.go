@@ -0,0 +1,172 @@
+package aws
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"net/http"
+
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	s3T "github.com/aws/aws-sdk-go-v2/service/s3/types"
+	smithyhttp "github.com/aws/smithy-go/transport/http"
+)
+
+type Bucket struct {
+	Name string
+	ARN  string
+}
+
+func (aws *AWS) CreateBucket(ctx context.Context, name string) (*Bucket, error) {
+	input := s3.CreateBucketInput{Bucket: &name}
+	// us-east-1 is the default location and is rejected if explicitly passed
+	if aws.Config.Region != "" && aws.Config.Region != "us-east-1" {
+		input.CreateBucketConfiguration = &s3T.CreateBucketConfiguration{
+			LocationConstraint: s3T.BucketLocationConstraint(aws.Config.Region),
+		}
+	}
+	_, err := aws.S3.CreateBucket(ctx, &input)
+	if err != nil {
+		var alreadyOwned *s3T.BucketAlreadyOwnedByYou
+		if !errors.As(err, &alreadyOwned) {
+			return nil, err
+		}
+	}
+	return &Bucket{Name: name, ARN: "arn:aws:s3:::" + name}, nil
+}
+
+// DeleteBucket deletes all objects in the bucket and then the bucket itself
+func (aws *AWS) DeleteBucket(ctx context.Context, name string) error {
+	paginator := s3.NewListObjectsV2Paginator(aws.S3, &s3.ListObjectsV2Input{Bucket: &name})
+	for paginator.HasMorePages() {
+		page, err := paginator.NextPage(ctx)
+		if err != nil {
+			return err
+		}
+		if len(page.Contents) == 0 {
+			continue
+		}
+		objects := make([]s3T.ObjectIdentifier, len(page.Contents))
+		for i, object := range page.Contents {
+			objects[i] = s3T.ObjectIdentifier{Key: object.Key}
+		}
+		_, err = aws.S3.DeleteObjects(ctx, &s3.DeleteObjectsInput{
+			Bucket: &name,
+			Delete: &s3T.Delete{Objects: objects},
+		})
+		if err != nil {
+			return err
+		}
+	}
+	_, err := aws.S3.DeleteBucket(ctx, &s3.DeleteBucketInput{Bucket: &name})
+	return err
+}
+
+func (aws *AWS) PutS3Object(ctx context.Context, bucket string, key string, data []byte) error {
+	_, err := aws.S3.PutObject(ctx, &s3.PutObjectInput{
+		Bucket: &bucket,
+		Key:    &key,
+		Body:   bytes.NewReader(data),
+	})
+	return err
+}
+
+// GetS3Object returns the object contents, or nil without an error if the object does not exist
+func (aws *AWS) GetS3Object(ctx context.Context, bucket string, key string) ([]byte, error) {
+	out, err := aws.S3.GetObject(ctx, &s3.GetObjectInput{
+		Bucket: &bucket,
+		Key:    &key,
+	})
+	if err != nil {
+		var noKey *s3T.NoSuchKey
+		if errors.As(err, &noKey) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	defer out.Body.Close()
+	return io.ReadAll(out.Body)
+}
+
+func (aws *AWS) DeleteS3Object(ctx context.Context, bucket string, key string) error {
+	_, err := aws.S3.DeleteObject(ctx, &s3.DeleteObjectInput{
+		Bucket: &bucket,
+		Key:    &key,
+	})
+	return err
+}
+
+// ExpireS3Objects sets up the bucket lifecycle so objects under each prefix are
+// deleted after the given number of days. It replaces any existing lifecycle rules
+func (aws *AWS) ExpireS3Objects(ctx context.Context, bucket string, expirations map[string]int32) error {
+	rules := make([]s3T.LifecycleRule, 0, len(expirations))
+	for prefix, days := range expirations {
+		id := "expire-" + prefix
+		rules = append(rules, s3T.LifecycleRule{
+			ID:         &id,
+			Status:     s3T.ExpirationStatusEnabled,
+			Filter:     &s3T.LifecycleRuleFilterMemberPrefix{Value: prefix},
+			Expiration: &s3T.LifecycleExpiration{Days: days},
+		})
+	}
+	_, err := aws.S3.PutBucketLifecycleConfiguration(ctx, &s3.PutBucketLifecycleConfigurationInput{
+		Bucket:                 &bucket,
+		LifecycleConfiguration: &s3T.BucketLifecycleConfiguration{Rules: rules},
+	})
+	return err
+}
+
+// ErrPreconditionFailed is returned by conditional writes when the object changed
+// since it was read
+var ErrPreconditionFailed = errors.New("s3 precondition failed")
+
+// GetS3ObjectVersion is like GetS3Object but also returns the object ETag, to be
+// given to PutS3ObjectIfMatch. Both are empty without an error if the object does
+// not exist
+func (aws *AWS) GetS3ObjectVersion(ctx context.Context, bucket string, key string) ([]byte, string, error) {
+	out, err := aws.S3.GetObject(ctx, &s3.GetObjectInput{
+		Bucket: &bucket,
+		Key:    &key,
+	})
+	if err != nil {
+		var noKey *s3T.NoSuchKey
+		if errors.As(err, &noKey) {
+			return nil, "", nil
+		}
+		return nil, "", err
+	}
+	defer out.Body.Close()
+	data, err := io.ReadAll(out.Body)
+	if err != nil {
+		return nil, "", err
+	}
+	var etag string
+	if out.ETag != nil {
+		etag = *out.ETag
+	}
+	return data, etag, nil
+}
+
+// PutS3ObjectIfMatch writes the object only if its ETag still matches the given one,
+// or only if it does not exist yet when the ETag is empty. It returns
+// ErrPreconditionFailed when the object was changed or created concurrently
+func (aws *AWS) PutS3ObjectIfMatch(ctx context.Context, bucket string, key string, data []byte, etag string) error {
+	condition := smithyhttp.SetHeaderValue("If-None-Match", "*")
+	if etag != "" {
+		condition = smithyhttp.SetHeaderValue("If-Match", etag)
+	}
+	_, err := aws.S3.PutObject(ctx, &s3.PutObjectInput{
+		Bucket: &bucket,
+		Key:    &key,
+		Body:   bytes.NewReader(data),
+	}, s3.WithAPIOptions(condition))
+	var respErr *smithyhttp.ResponseError
+	if errors.As(err, &respErr) {
+		switch respErr.HTTPStatusCode() {
+		// 409 is returned when a conflicting conditional write is still in flight
+		case http.StatusPreconditionFailed, http.StatusConflict:
+			return ErrPreconditionFailed
+		}
+	}
+	return err
+}
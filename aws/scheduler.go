@@ -0,0 +1,105 @@
+package aws
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/aws/aws-sdk-go-v2/service/scheduler"
+	schedulerT "github.com/aws/aws-sdk-go-v2/service/scheduler/types"
+)
+
+// Schedule is an eventbridge scheduler schedule invoking a target with a fixed input
+type Schedule struct {
+	Name string
+	// Expression is an at, rate or cron expression, eg at(2023-06-01T10:00:00),
+	// rate(1 hour) or cron(0 8 * * ? *). Expressions are evaluated in UTC
+	Expression string
+	TargetARN  string
+	// RoleARN is the role the scheduler assumes to invoke the target
+	RoleARN string
+	Input   string
+}
+
+// ScheduleARN returns the arn of the schedule in the group. Use * as the name to
+// match every schedule of the group, eg in iam policies
+func (aws *AWS) ScheduleARN(ctx context.Context, group string, name string) (string, error) {
+	account, err := aws.Account(ctx)
+	if err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("arn:aws:scheduler:%s:%s:schedule/%s/%s", aws.Config.Region, account, group, name), nil
+}
+
+// CreateScheduleGroup creates the schedule group, succeeding if it already exists
+func (aws *AWS) CreateScheduleGroup(ctx context.Context, name string) error {
+	_, err := aws.Scheduler.CreateScheduleGroup(ctx, &scheduler.CreateScheduleGroupInput{
+		Name: &name,
+	})
+	var conflict *schedulerT.ConflictException
+	if errors.As(err, &conflict) {
+		return nil
+	}
+	return err
+}
+
+// DeleteScheduleGroup deletes the schedule group along with all its schedules
+func (aws *AWS) DeleteScheduleGroup(ctx context.Context, name string) error {
+	_, err := aws.Scheduler.DeleteScheduleGroup(ctx, &scheduler.DeleteScheduleGroupInput{
+		Name: &name,
+	})
+	var notFound *schedulerT.ResourceNotFoundException
+	if errors.As(err, &notFound) {
+		return nil
+	}
+	return err
+}
+
+// CreateSchedule creates the schedule in the group, succeeding without changes if a
+// schedule with the same name already exists
+func (aws *AWS) CreateSchedule(ctx context.Context, group string, schedule Schedule) error {
+	_, err := aws.Scheduler.CreateSchedule(ctx, &scheduler.CreateScheduleInput{
+		Name:               &schedule.Name,
+		GroupName:          &group,
+		ScheduleExpression: &schedule.Expression,
+		FlexibleTimeWindow: &schedulerT.FlexibleTimeWindow{Mode: schedulerT.FlexibleTimeWindowModeOff},
+		Target: &schedulerT.Target{
+			Arn:     &schedule.TargetARN,
+			RoleArn: &schedule.RoleARN,
+			Input:   &schedule.Input,
+		},
+	})
+	var conflict *schedulerT.ConflictException
+	if errors.As(err, &conflict) {
+		return nil
+	}
+	return err
+}
+
+// DeleteSchedule deletes the schedule, succeeding if it does not exist
+func (aws *AWS) DeleteSchedule(ctx context.Context, group string, name string) error {
+	_, err := aws.Scheduler.DeleteSchedule(ctx, &scheduler.DeleteScheduleInput{
+		Name:      &name,
+		GroupName: &group,
+	})
+	var notFound *schedulerT.ResourceNotFoundException
+	if errors.As(err, &notFound) {
+		return nil
+	}
+	return err
+}
+
+func (aws *AWS) ListSchedules(ctx context.Context, group string) ([]schedulerT.ScheduleSummary, error) {
+	schedules := []schedulerT.ScheduleSummary{}
+	paginator := scheduler.NewListSchedulesPaginator(aws.Scheduler, &scheduler.ListSchedulesInput{
+		GroupName: &group,
+	})
+	for paginator.HasMorePages() {
+		page, err := paginator.NextPage(ctx)
+		if err != nil {
+			return schedules, err
+		}
+		schedules = append(schedules, page.Schedules...)
+	}
+	return schedules, nil
+}
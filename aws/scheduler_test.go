@@ -0,0 +1,39 @@
+package aws
+
+import (
+	"context"
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/service/sts"
+)
+
+func TestScheduleARN(t *testing.T) {
+	tests := []struct {
+		name     string
+		schedule string
+		arn      string
+	}{
+		{name: "schedule", schedule: "hourly", arn: "arn:aws:scheduler:eu-west-1:" + testAccount + ":schedule/group/hourly"},
+		{name: "every schedule", schedule: "*", arn: "arn:aws:scheduler:eu-west-1:" + testAccount + ":schedule/group/*"},
+	}
+	server, _ := newSTSServer(t, false)
+	client, err := New("",
+		WithRegion("eu-west-1"),
+		WithStaticCredentials("key", "secret", ""),
+		WithEndpoint(sts.ServiceID, server.URL),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			arn, err := client.ScheduleARN(context.Background(), "group", tt.schedule)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if arn != tt.arn {
+				t.Fatalf("expected %s, got %s", tt.arn, arn)
+			}
+		})
+	}
+}
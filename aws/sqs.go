@@ -4,6 +4,8 @@ import (
 	"bytes"
 	"context"
 	"encoding/json"
+	"fmt"
+	"strings"
 
 	"github.com/aws/aws-sdk-go-v2/service/sqs"
 	sqsT "github.com/aws/aws-sdk-go-v2/service/sqs/types"
@@ -16,8 +18,15 @@ type Queue struct {
 }
 
 func (aws *AWS) CreateQueue(ctx context.Context, name string) (*Queue, error) {
+	return aws.CreateQueueWithAttributes(ctx, name, nil)
+}
+
+// CreateQueueWithAttributes creates a queue with the given queue attributes, eg
+// FifoQueue. Fifo queue names must end with .fifo
+func (aws *AWS) CreateQueueWithAttributes(ctx context.Context, name string, attributes map[string]string) (*Queue, error) {
 	_, err := aws.SQS.CreateQueue(ctx, &sqs.CreateQueueInput{
-		QueueName: &name,
+		QueueName:  &name,
+		Attributes: attributes,
 	})
 	if err != nil {
 		return nil, err
@@ -51,9 +60,147 @@ func (aws *AWS) SendSQSJSON(ctx context.Context, queueURL string, message any) (
 }
 
 func (aws *AWS) SendSQS(ctx context.Context, queueURL string, message string) (*sqs.SendMessageOutput, error) {
-	out, err := aws.SQS.SendMessage(ctx, &sqs.SendMessageInput{
-		MessageBody: &message,
-		QueueUrl:    &queueURL,
+	return aws.SendSQSWithAttributes(ctx, queueURL, message, nil)
+}
+
+// SendSQSWithAttributes sends a message with the given string message attributes
+func (aws *AWS) SendSQSWithAttributes(ctx context.Context, queueURL string, message string, attributes map[string]string) (*sqs.SendMessageOutput, error) {
+	return aws.SendSQSWithOptions(ctx, queueURL, message, attributes, SendOptions{})
+}
+
+// SendOptions holds the optional parameters of a sqs message
+type SendOptions struct {
+	// DelaySeconds makes the message visible to consumers only after the delay, up
+	// to 900 seconds. Not supported by fifo queues
+	DelaySeconds int32
+	// GroupID is required by fifo queues, messages in the same group are delivered
+	// in order
+	GroupID string
+	// DeduplicationID is required by fifo queues without content based deduplication
+	DeduplicationID string
+}
+
+// SendSQSWithOptions is like SendSQSWithAttributes, also setting the given options
+func (aws *AWS) SendSQSWithOptions(ctx context.Context, queueURL string, message string, attributes map[string]string, options SendOptions) (*sqs.SendMessageOutput, error) {
+	input := sqs.SendMessageInput{
+		MessageBody:       &message,
+		QueueUrl:          &queueURL,
+		MessageAttributes: MessageAttributes(attributes),
+		DelaySeconds:      options.DelaySeconds,
+	}
+	if options.GroupID != "" {
+		input.MessageGroupId = &options.GroupID
+	}
+	if options.DeduplicationID != "" {
+		input.MessageDeduplicationId = &options.DeduplicationID
+	}
+	return aws.SQS.SendMessage(ctx, &input)
+}
+
+// MessageAttributes converts a map of strings into sqs string message attributes
+func MessageAttributes(attributes map[string]string) map[string]sqsT.MessageAttributeValue {
+	if len(attributes) == 0 {
+		return nil
+	}
+	dataType := "String"
+	result := make(map[string]sqsT.MessageAttributeValue, len(attributes))
+	for name, value := range attributes {
+		value := value
+		result[name] = sqsT.MessageAttributeValue{
+			DataType:    &dataType,
+			StringValue: &value,
+		}
+	}
+	return result
+}
+
+func (aws *AWS) SendSQSBatch(ctx context.Context, queueURL string, entries []sqsT.SendMessageBatchRequestEntry) (*sqs.SendMessageBatchOutput, error) {
+	return aws.SQS.SendMessageBatch(ctx, &sqs.SendMessageBatchInput{
+		QueueUrl: &queueURL,
+		Entries:  entries,
+	})
+}
+
+// ReceiveSQS receives up to max messages, at most 10, with all their attributes,
+// hiding them from other consumers for the visibility timeout. It does not wait for
+// messages to arrive
+func (aws *AWS) ReceiveSQS(ctx context.Context, queueURL string, max int32, visibilityTimeout int32) ([]sqsT.Message, error) {
+	out, err := aws.SQS.ReceiveMessage(ctx, &sqs.ReceiveMessageInput{
+		QueueUrl:              &queueURL,
+		MaxNumberOfMessages:   max,
+		VisibilityTimeout:     visibilityTimeout,
+		AttributeNames:        []sqsT.QueueAttributeName{sqsT.QueueAttributeNameAll},
+		MessageAttributeNames: []string{"All"},
+	})
+	if err != nil {
+		return nil, err
+	}
+	return out.Messages, nil
+}
+
+func (aws *AWS) DeleteSQS(ctx context.Context, queueURL string, receiptHandle string) error {
+	_, err := aws.SQS.DeleteMessage(ctx, &sqs.DeleteMessageInput{
+		QueueUrl:      &queueURL,
+		ReceiptHandle: &receiptHandle,
+	})
+	return err
+}
+
+func (aws *AWS) PurgeQueue(ctx context.Context, queueURL string) error {
+	_, err := aws.SQS.PurgeQueue(ctx, &sqs.PurgeQueueInput{
+		QueueUrl: &queueURL,
+	})
+	return err
+}
+
+// DeadLetterQueueURL returns the url of the queue messages are moved to by the
+// queue redrive policy, or an empty string if the queue has none
+func (aws *AWS) DeadLetterQueueURL(ctx context.Context, queueURL string) (string, error) {
+	attributes, err := aws.SQS.GetQueueAttributes(ctx, &sqs.GetQueueAttributesInput{
+		QueueUrl:       &queueURL,
+		AttributeNames: []sqsT.QueueAttributeName{sqsT.QueueAttributeNameRedrivePolicy},
+	})
+	if err != nil {
+		return "", err
+	}
+	policy, ok := attributes.Attributes[string(sqsT.QueueAttributeNameRedrivePolicy)]
+	if !ok || policy == "" {
+		return "", nil
+	}
+	var redrive struct {
+		DeadLetterTargetARN string `json:"deadLetterTargetArn"`
+	}
+	if err := json.Unmarshal([]byte(policy), &redrive); err != nil {
+		return "", fmt.Errorf("invalid redrive policy of %s: %w", queueURL, err)
+	}
+	// Queue arns end with the queue name: arn:aws:sqs:<region>:<account>:<name>
+	name := redrive.DeadLetterTargetARN[strings.LastIndex(redrive.DeadLetterTargetARN, ":")+1:]
+	url, err := aws.SQS.GetQueueUrl(ctx, &sqs.GetQueueUrlInput{QueueName: &name})
+	if err != nil {
+		return "", err
+	}
+	return *url.QueueUrl, nil
+}
+
+// StringAttributes converts sqs message attributes back into a map of strings,
+// ignoring non string attributes
+func StringAttributes(attributes map[string]sqsT.MessageAttributeValue) map[string]string {
+	result := make(map[string]string, len(attributes))
+	for key, value := range attributes {
+		if value.StringValue != nil {
+			result[key] = *value.StringValue
+		}
+	}
+	return result
+}
+
+// ChangeSQSVisibility changes how long a received message stays hidden from other
+// consumers. Zero makes it visible right away
+func (aws *AWS) ChangeSQSVisibility(ctx context.Context, queueURL string, receiptHandle string, visibilityTimeout int32) error {
+	_, err := aws.SQS.ChangeMessageVisibility(ctx, &sqs.ChangeMessageVisibilityInput{
+		QueueUrl:          &queueURL,
+		ReceiptHandle:     &receiptHandle,
+		VisibilityTimeout: visibilityTimeout,
 	})
-	return out, err
+	return err
 }
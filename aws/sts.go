@@ -15,6 +15,5 @@ func (aws *AWS) Account(ctx context.Context) (string, error) {
 }
 
 func (aws *AWS) Identity(ctx context.Context) (*sts.GetCallerIdentityOutput, error) {
-	stsClient := sts.NewFromConfig(aws.Config)
-	return stsClient.GetCallerIdentity(ctx, &sts.GetCallerIdentityInput{})
+	return aws.STS.GetCallerIdentity(ctx, &sts.GetCallerIdentityInput{})
 }
@@ -0,0 +1,408 @@
+package elaston
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	sqsT "github.com/aws/aws-sdk-go-v2/service/sqs/types"
+
+	"go.opentelemetry.io/otel/attribute"
+
+	"github.com/bcap/elaston/aws"
+)
+
+// SubmitResult is the outcome of submitting one of the inputs given to SubmitMany
+type SubmitResult struct {
+	// Index is the position of the input in the slice given to SubmitMany
+	Index     int
+	MessageID string
+	Err       error
+}
+
+// SubmitManyError is returned by SubmitMany when one or more inputs failed to be submitted
+type SubmitManyError struct {
+	Failed   int
+	FirstErr error
+}
+
+func (e *SubmitManyError) Error() string {
+	return fmt.Sprintf("submit failed for %d items: %v", e.Failed, e.FirstErr)
+}
+
+func (e *SubmitManyError) Unwrap() error {
+	return e.FirstErr
+}
+
+// SubmitMany is like Submit for many inputs at once, packing them into as few sqs
+// requests as possible. Results are returned in the same order as the inputs
+func (e *Elaston) SubmitMany(ctx context.Context, inputs []any, options ...Option) ([]SubmitResult, error) {
+	return SubmitMany[any](ctx, e, inputs, options...)
+}
+
+// SubmitMany is the type-safe version of Elaston.SubmitMany
+func SubmitMany[In any](ctx context.Context, elaston *Elaston, inputs []In, options ...Option) (_ []SubmitResult, err error) {
+	elaston = elaston.with(options)
+
+	ctx, cancel := elaston.withTimeout(ctx)
+	defer cancel()
+
+	ctx, span := elaston.startSubmitSpan(ctx)
+	defer func() { endSpan(span, err) }()
+	span.SetAttributes(attribute.Int("messaging.batch.message_count", len(inputs)))
+
+	outbox := outboxFromContext(ctx)
+	if !elaston.outbox {
+		outbox = nil
+	}
+
+	results := make([]SubmitResult, len(inputs))
+	entries := make([]sqsEntry, 0, len(inputs))
+	indexes := make([]int, 0, len(inputs))
+	for i, in := range inputs {
+		results[i].Index = i
+		var headers map[string]string
+		if outbox != nil {
+			results[i].MessageID = outbox.nextDedupID()
+			headers = map[string]string{dedupIDHeader: results[i].MessageID}
+		}
+		groupID, deduplicationID, err := elaston.fifoParameters(results[i].MessageID)
+		if err != nil {
+			results[i].Err = err
+			continue
+		}
+		// A single explicit deduplication id would drop all but the first input
+		if elaston.deduplicationID != "" && deduplicationID != "" {
+			deduplicationID += "-" + strconv.Itoa(i)
+		}
+		msg, err := elaston.encodeSQS(ctx, in, headers)
+		if err != nil {
+			results[i].Err = err
+			continue
+		}
+		entries = append(entries, sqsEntry{msg: msg, groupID: groupID, deduplicationID: deduplicationID})
+		indexes = append(indexes, i)
+	}
+
+	if outbox != nil {
+		for _, entry := range entries {
+			outbox.add(elaston, entry)
+		}
+	} else if err := elaston.countDescendants(ctx, len(entries)); err != nil {
+		for _, idx := range indexes {
+			results[idx].Err = err
+		}
+	} else {
+		ids, errs := elaston.sendBatch(ctx, elaston.sqsQueueURL, entries, elaston.submitParallelism)
+		unsent := 0
+		for i, idx := range indexes {
+			results[idx].MessageID = ids[i]
+			results[idx].Err = errs[i]
+			if errs[i] != nil {
+				unsent++
+			}
+		}
+		elaston.uncountDescendants(ctx, unsent)
+	}
+
+	submitErr := SubmitManyError{}
+	for _, result := range results {
+		if result.Err == nil {
+			continue
+		}
+		submitErr.Failed++
+		if submitErr.FirstErr == nil {
+			submitErr.FirstErr = result.Err
+		}
+	}
+	if submitErr.Failed > 0 {
+		return results, &submitErr
+	}
+	return results, nil
+}
+
+// sqsEntry is an encoded message along with how sqs should deliver it
+type sqsEntry struct {
+	msg             message
+	delay           time.Duration
+	groupID         string
+	deduplicationID string
+}
+
+func (e sqsEntry) sendOptions() aws.SendOptions {
+	return aws.SendOptions{
+		DelaySeconds:    delaySeconds(e.delay),
+		GroupID:         e.groupID,
+		DeduplicationID: e.deduplicationID,
+	}
+}
+
+// maxBatchEntries is the sqs limit of messages per SendMessageBatch request. The
+// whole request is also bound by maxSQSMessageSize
+const maxBatchEntries = 10
+
+// BatchEntryError is returned for entries sqs refused while sending a batch
+type BatchEntryError struct {
+	Code        string
+	Message     string
+	SenderFault bool
+}
+
+func (e *BatchEntryError) Error() string {
+	return fmt.Sprintf("sqs rejected message: %s: %s", e.Code, e.Message)
+}
+
+// ErrGroupHalted is returned for messages of a fifo queue that were not sent
+// because an earlier message of the same group failed, which would break the order
+var ErrGroupHalted = errors.New("not sent as an earlier message of its fifo message group failed")
+
+// sendBatch sends already encoded messages to the queue, packing them into as few
+// SendMessageBatch requests as the sqs limits allow and running up to parallelism
+// requests at the same time. It returns the message id or the error of each message.
+// Messages that fail with a retryable error are retried individually according to
+// the retry policy, whose Deadline bounds the whole call and whose OnAttempt is
+// called for every failed message of every round.
+//
+// For fifo queues the batches are sent one at a time, in order, each retried before
+// moving to the next one. Once a message fails for good, the following messages of
+// its group fail with ErrGroupHalted instead of being sent out of order
+func (e *Elaston) sendBatch(ctx context.Context, queueURL string, msgs []sqsEntry, parallelism int) ([]string, []error) {
+	ids := make([]string, len(msgs))
+	errs := make([]error, len(msgs))
+
+	if e.retryPolicy.Deadline > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, e.retryPolicy.Deadline)
+		defer cancel()
+	}
+
+	all := make([]int, len(msgs))
+	for i := range msgs {
+		all[i] = i
+	}
+	if !isFIFOQueue(queueURL) {
+		e.sendRounds(ctx, queueURL, msgs, all, parallelism, false, ids, errs)
+		return ids, errs
+	}
+
+	halted := map[string]bool{}
+	pending := all
+	for len(pending) > 0 {
+		eligible := []int{}
+		for _, idx := range pending {
+			if halted[msgs[idx].groupID] {
+				errs[idx] = ErrGroupHalted
+				continue
+			}
+			eligible = append(eligible, idx)
+		}
+		if len(eligible) == 0 {
+			break
+		}
+		batch := packBatches(msgs, eligible)[0]
+		e.sendRounds(ctx, queueURL, msgs, batch, 1, true, ids, errs)
+		for _, idx := range batch {
+			if errs[idx] != nil {
+				halted[msgs[idx].groupID] = true
+			}
+		}
+		pending = eligible[len(batch):]
+	}
+	return ids, errs
+}
+
+// sendRounds sends the messages at the given indexes in batches, retrying the ones
+// that failed with a retryable error in further rounds according to the retry policy.
+// When ordered, a message is not retried once a later message of its group went
+// through, as it would land out of order
+func (e *Elaston) sendRounds(ctx context.Context, queueURL string, msgs []sqsEntry, pending []int, parallelism int, ordered bool, ids []string, errs []error) {
+	if parallelism < 1 {
+		parallelism = 1
+	}
+
+	policy := e.retryPolicy
+	start := time.Now()
+	backoff := policy.InitialBackoff
+	for attempt := 1; len(pending) > 0; attempt++ {
+		failed := []int{}
+		mutex := sync.Mutex{}
+		semaphore := make(chan struct{}, parallelism)
+		wg := sync.WaitGroup{}
+		for _, batch := range packBatches(msgs, pending) {
+			semaphore <- struct{}{}
+			wg.Add(1)
+			go func(batch []int) {
+				defer func() {
+					<-semaphore
+					wg.Done()
+				}()
+				retry := e.sendOneBatch(ctx, queueURL, msgs, batch, ids, errs)
+				mutex.Lock()
+				failed = append(failed, retry...)
+				mutex.Unlock()
+			}(batch)
+		}
+		wg.Wait()
+		sort.Ints(failed)
+		if ordered {
+			failed = retriableInOrder(msgs, pending, failed, errs)
+		}
+
+		retry := len(failed) > 0 && attempt < policy.MaxAttempts && ctx.Err() == nil
+		wait := time.Duration(0)
+		if retry {
+			wait = policy.jittered(backoff)
+		}
+		if policy.OnAttempt != nil {
+			retried := map[int]bool{}
+			for _, idx := range failed {
+				retried[idx] = retry
+			}
+			for _, idx := range pending {
+				if errs[idx] == nil {
+					continue
+				}
+				info := Attempt{
+					Number:  attempt,
+					Err:     errs[idx],
+					Class:   ClassifyError(errs[idx]),
+					Elapsed: time.Since(start),
+				}
+				if retried[idx] {
+					info.Backoff = wait
+				}
+				policy.OnAttempt(info)
+			}
+		}
+		if !retry {
+			break
+		}
+
+		select {
+		case <-time.After(wait):
+		case <-ctx.Done():
+			for _, idx := range failed {
+				errs[idx] = errors.Join(errs[idx], ctx.Err())
+			}
+			return
+		}
+		backoff *= 2
+		if policy.MaxBackoff > 0 && backoff > policy.MaxBackoff {
+			backoff = policy.MaxBackoff
+		}
+		pending = failed
+	}
+}
+
+// retriableInOrder filters out of failed the messages followed by a successfully
+// sent message of the same group
+func retriableInOrder(msgs []sqsEntry, sent []int, failed []int, errs []error) []int {
+	passed := map[string]bool{}
+	retry := map[int]bool{}
+	for i := len(sent) - 1; i >= 0; i-- {
+		idx := sent[i]
+		group := msgs[idx].groupID
+		if errs[idx] == nil {
+			passed[group] = true
+			continue
+		}
+		retry[idx] = !passed[group]
+	}
+	filtered := []int{}
+	for _, idx := range failed {
+		if retry[idx] {
+			filtered = append(filtered, idx)
+		}
+	}
+	return filtered
+}
+
+// sendOneBatch sends a single SendMessageBatch request, filling ids and errs for the
+// messages in the batch. It returns the messages worth retrying
+func (e *Elaston) sendOneBatch(ctx context.Context, queueURL string, msgs []sqsEntry, batch []int, ids []string, errs []error) []int {
+	if e.local != nil {
+		for _, idx := range batch {
+			body, attributes := msgs[idx].msg.sqsMessage()
+			ids[idx], errs[idx] = e.local.send(body, attributes, msgs[idx].sendOptions())
+		}
+		return nil
+	}
+
+	entries := make([]sqsT.SendMessageBatchRequestEntry, len(batch))
+	for i, idx := range batch {
+		entryID := strconv.Itoa(idx)
+		body, attributes := msgs[idx].msg.sqsMessage()
+		options := msgs[idx].sendOptions()
+		entries[i] = sqsT.SendMessageBatchRequestEntry{
+			Id:                &entryID,
+			MessageBody:       &body,
+			MessageAttributes: aws.MessageAttributes(attributes),
+			DelaySeconds:      options.DelaySeconds,
+		}
+		if options.GroupID != "" {
+			entries[i].MessageGroupId = &options.GroupID
+		}
+		if options.DeduplicationID != "" {
+			entries[i].MessageDeduplicationId = &options.DeduplicationID
+		}
+	}
+
+	out, err := e.aws.SendSQSBatch(ctx, queueURL, entries)
+	if err != nil {
+		for _, idx := range batch {
+			errs[idx] = err
+		}
+		if e.retryPolicy.retryable(ClassifyError(err)) {
+			return batch
+		}
+		return nil
+	}
+
+	for _, entry := range out.Successful {
+		idx, _ := strconv.Atoi(*entry.Id)
+		ids[idx] = *entry.MessageId
+		errs[idx] = nil
+	}
+	retry := []int{}
+	for _, entry := range out.Failed {
+		idx, _ := strconv.Atoi(*entry.Id)
+		entryErr := BatchEntryError{SenderFault: entry.SenderFault}
+		if entry.Code != nil {
+			entryErr.Code = *entry.Code
+		}
+		if entry.Message != nil {
+			entryErr.Message = *entry.Message
+		}
+		errs[idx] = &entryErr
+		if e.retryPolicy.retryable(ClassifyError(&entryErr)) {
+			retry = append(retry, idx)
+		}
+	}
+	return retry
+}
+
+// packBatches groups the given messages into batches respecting the sqs limits of
+// messages per batch and total batch size
+func packBatches(msgs []sqsEntry, indexes []int) [][]int {
+	batches := [][]int{}
+	current := []int{}
+	currentSize := 0
+	for _, idx := range indexes {
+		size := msgs[idx].msg.sqsSize()
+		if len(current) == maxBatchEntries || (len(current) > 0 && currentSize+size > maxSQSMessageSize) {
+			batches = append(batches, current)
+			current = []int{}
+			currentSize = 0
+		}
+		current = append(current, idx)
+		currentSize += size
+	}
+	if len(current) > 0 {
+		batches = append(batches, current)
+	}
+	return batches
+}
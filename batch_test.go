@@ -0,0 +1,276 @@
+package elaston
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/service/sqs"
+	sqsT "github.com/aws/aws-sdk-go-v2/service/sqs/types"
+
+	"github.com/bcap/elaston/elastontest"
+)
+
+func TestPackBatches(t *testing.T) {
+	small := sqsEntry{msg: message{body: []byte(`"x"`)}}
+	large := sqsEntry{msg: message{body: []byte(`"` + strings.Repeat("x", 100*1024) + `"`)}}
+	tests := []struct {
+		name  string
+		msgs  []sqsEntry
+		sizes []int
+	}{
+		{name: "empty", sizes: []int{}},
+		{name: "single batch", msgs: repeatEntry(small, 10), sizes: []int{10}},
+		{name: "entries limit", msgs: repeatEntry(small, 25), sizes: []int{10, 10, 5}},
+		{name: "size limit", msgs: repeatEntry(large, 5), sizes: []int{2, 2, 1}},
+		{name: "mixed", msgs: append(append(repeatEntry(large, 1), repeatEntry(small, 3)...), repeatEntry(large, 2)...), sizes: []int{5, 1}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			indexes := make([]int, len(tt.msgs))
+			for i := range indexes {
+				indexes[i] = i
+			}
+			sizes := []int{}
+			next := 0
+			for _, batch := range packBatches(tt.msgs, indexes) {
+				for _, idx := range batch {
+					if idx != next {
+						t.Fatalf("expected index %d, got %d", next, idx)
+					}
+					next++
+				}
+				sizes = append(sizes, len(batch))
+			}
+			if !reflect.DeepEqual(sizes, tt.sizes) {
+				t.Fatalf("expected batches of sizes %v, got %v", tt.sizes, sizes)
+			}
+		})
+	}
+}
+
+// failedEntry is a batch entry the fake sqs refuses
+type failedEntry struct {
+	id          string
+	code        string
+	senderFault bool
+}
+
+func TestSendBatch(t *testing.T) {
+	tests := []struct {
+		name string
+		msgs int
+		// rounds are the entries refused by each SendMessageBatch call, in order
+		rounds [][]failedEntry
+		calls  int
+		failed []int
+	}{
+		{name: "all sent", msgs: 12, calls: 2},
+		{name: "retried entry", msgs: 3, rounds: [][]failedEntry{{{id: "1", code: "InternalError"}}}, calls: 2},
+		{name: "sender fault", msgs: 3, rounds: [][]failedEntry{{{id: "1", code: "InvalidParameterValue", senderFault: true}}}, calls: 1, failed: []int{1}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fakes := elastontest.New()
+			for _, round := range tt.rounds {
+				fakes.SQS.Script("SendMessageBatch", refuseEntries(round))
+			}
+			client := newTestClient(fakes)
+			msgs := make([]sqsEntry, tt.msgs)
+			for i := range msgs {
+				msgs[i] = sqsEntry{msg: message{body: []byte(fmt.Sprint(i))}}
+			}
+
+			ids, errs := client.sendBatch(context.Background(), testQueueURL, msgs, 2)
+			if calls := len(fakes.SQS.CallsTo("SendMessageBatch")); calls != tt.calls {
+				t.Fatalf("expected %d batch calls, got %d", tt.calls, calls)
+			}
+			failed := []int{}
+			for i, err := range errs {
+				if err != nil {
+					var entryErr *BatchEntryError
+					if !errors.As(err, &entryErr) {
+						t.Fatalf("expected a batch entry error, got %v", err)
+					}
+					failed = append(failed, i)
+				} else if ids[i] == "" {
+					t.Fatalf("expected message %d to have an id", i)
+				}
+			}
+			if fmt.Sprint(failed) != fmt.Sprint(tt.failed) {
+				t.Fatalf("expected messages %v to fail, got %v", tt.failed, failed)
+			}
+		})
+	}
+}
+
+func TestSubmitMany(t *testing.T) {
+	fifoQueueURL := testQueueURL + ".fifo"
+	tests := []struct {
+		name     string
+		queueURL string
+		inputs   int
+		// rounds are the entries refused by each SendMessageBatch call, in order
+		rounds   [][]failedEntry
+		calls    int
+		failed   []int
+		halted   []int
+		attempts int
+	}{
+		{
+			name:   "all sent",
+			inputs: 12,
+			calls:  2,
+		},
+		{
+			name:     "retried entry",
+			inputs:   3,
+			rounds:   [][]failedEntry{{{id: "1", code: "InternalError"}}},
+			calls:    2,
+			attempts: 1,
+		},
+		{
+			name:     "sender fault",
+			inputs:   3,
+			rounds:   [][]failedEntry{{{id: "1", code: "InvalidParameterValue", senderFault: true}}},
+			calls:    1,
+			failed:   []int{1},
+			attempts: 1,
+		},
+		{
+			name:     "retries exhausted",
+			inputs:   3,
+			rounds:   [][]failedEntry{{{id: "0", code: "InternalError"}}, {{id: "0", code: "InternalError"}}, {{id: "0", code: "InternalError"}}},
+			calls:    3,
+			failed:   []int{0},
+			attempts: 3,
+		},
+		{
+			name:     "fifo retried in order",
+			queueURL: fifoQueueURL,
+			inputs:   12,
+			rounds:   [][]failedEntry{{{id: "9", code: "InternalError"}}},
+			calls:    3,
+			attempts: 1,
+		},
+		{
+			name:     "fifo group halted",
+			queueURL: fifoQueueURL,
+			inputs:   12,
+			rounds:   [][]failedEntry{{{id: "9", code: "InvalidParameterValue", senderFault: true}}},
+			calls:    1,
+			failed:   []int{9},
+			halted:   []int{10, 11},
+			attempts: 1,
+		},
+		{
+			// Retrying would place the message after the ones already sent
+			name:     "fifo retry dropped",
+			queueURL: fifoQueueURL,
+			inputs:   12,
+			rounds:   [][]failedEntry{{{id: "0", code: "InternalError"}}},
+			calls:    1,
+			failed:   []int{0},
+			halted:   []int{10, 11},
+			attempts: 1,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := context.Background()
+			fakes := elastontest.New()
+			for _, round := range tt.rounds {
+				fakes.SQS.Script("SendMessageBatch", refuseEntries(round))
+			}
+			queueURL := tt.queueURL
+			if queueURL == "" {
+				queueURL = testQueueURL
+			}
+			attempts := []Attempt{}
+			policy := RetryPolicy{MaxAttempts: 3, OnAttempt: func(attempt Attempt) { attempts = append(attempts, attempt) }}
+			client := newTestQueueClient(fakes, queueURL, WithRetryPolicy(policy), WithMessageGroup("group"))
+
+			inputs := make([]int, tt.inputs)
+			for i := range inputs {
+				inputs[i] = i
+			}
+			results, err := SubmitMany(ctx, client, inputs)
+
+			if calls := len(fakes.SQS.CallsTo("SendMessageBatch")); calls != tt.calls {
+				t.Fatalf("expected %d batch calls, got %d", tt.calls, calls)
+			}
+			if len(attempts) != tt.attempts {
+				t.Fatalf("expected %d failed attempts to be reported, got %d", tt.attempts, len(attempts))
+			}
+			failed, halted := []int{}, []int{}
+			for i, result := range results {
+				switch {
+				case result.Index != i:
+					t.Fatalf("expected result %d to have index %d, got %d", i, i, result.Index)
+				case errors.Is(result.Err, ErrGroupHalted):
+					halted = append(halted, i)
+				case result.Err != nil:
+					var entryErr *BatchEntryError
+					if !errors.As(result.Err, &entryErr) {
+						t.Fatalf("expected a batch entry error, got %v", result.Err)
+					}
+					failed = append(failed, i)
+				case result.MessageID == "":
+					t.Fatalf("expected result %d to have a message id", i)
+				}
+			}
+			if len(failed) == 0 && len(tt.failed) == 0 && len(halted) == 0 && len(tt.halted) == 0 {
+				if err != nil {
+					t.Fatalf("unexpected error %v", err)
+				}
+				return
+			}
+			if fmt.Sprint(failed) != fmt.Sprint(tt.failed) || fmt.Sprint(halted) != fmt.Sprint(tt.halted) {
+				t.Fatalf("expected failed %v and halted %v, got %v and %v", tt.failed, tt.halted, failed, halted)
+			}
+			var submitErr *SubmitManyError
+			if !errors.As(err, &submitErr) {
+				t.Fatalf("expected a *SubmitManyError, got %v", err)
+			}
+			if submitErr.Failed != len(tt.failed)+len(tt.halted) {
+				t.Fatalf("expected %d failures, got %d", len(tt.failed)+len(tt.halted), submitErr.Failed)
+			}
+		})
+	}
+}
+
+func repeatEntry(entry sqsEntry, n int) []sqsEntry {
+	entries := make([]sqsEntry, n)
+	for i := range entries {
+		entries[i] = entry
+	}
+	return entries
+}
+
+// refuseEntries answers a SendMessageBatch call accepting every entry but the given ones
+func refuseEntries(refused []failedEntry) elastontest.Responder {
+	return func(input any) (any, error) {
+		out := sqs.SendMessageBatchOutput{}
+		for _, entry := range input.(*sqs.SendMessageBatchInput).Entries {
+			failed := false
+			for _, refused := range refused {
+				if refused.id == *entry.Id {
+					out.Failed = append(out.Failed, sqsT.BatchResultErrorEntry{
+						Id:          entry.Id,
+						Code:        &refused.code,
+						SenderFault: refused.senderFault,
+					})
+					failed = true
+				}
+			}
+			if !failed {
+				messageID := "scripted-" + *entry.Id
+				out.Successful = append(out.Successful, sqsT.SendMessageBatchResultEntry{Id: entry.Id, MessageId: &messageID})
+			}
+		}
+		return &out, nil
+	}
+}
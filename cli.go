@@ -0,0 +1,851 @@
+package elaston
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"os/signal"
+	"path/filepath"
+	"runtime"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/bcap/elaston/aws"
+	"github.com/bcap/elaston/deploy"
+)
+
+var errUsage = errors.New("invalid arguments")
+
+// runTool is the command line interface of every binary calling Run outside lambda,
+// making the binary the management tool of its own deployments
+func runTool() {
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	code := runCLI(ctx, os.Args[1:], os.Stdin, os.Stdout, os.Stderr)
+	stop()
+	os.Exit(code)
+}
+
+type cli struct {
+	profile string
+	region  string
+	name    string
+	timeout time.Duration
+	output  string
+
+	// nameSet tells whether the name was given or is the default
+	nameSet bool
+
+	// Flags of specific commands
+	since  time.Duration
+	follow bool
+	group  string
+	max    int
+	edit   bool
+
+	// Flags of deploy
+	memory          int
+	functionTimeout time.Duration
+	fifo            bool
+	contentDedup    bool
+	maxReceiveCount int
+	batchSize       int
+	batchWindow     time.Duration
+	kmsKey          string
+	scheduleFlags   stringsFlag
+	stdoutTracing   bool
+
+	aws    *aws.AWS
+	stdin  io.Reader
+	stdout io.Writer
+	stderr io.Writer
+}
+
+type cliCommand struct {
+	name    string
+	args    string
+	summary string
+	flags   func(*flag.FlagSet, *cli)
+	run     func(*cli, context.Context, []string) error
+}
+
+var cliCommands = []cliCommand{
+	{
+		name:    "deploy",
+		summary: "deploy this binary as a new deployment",
+		flags: func(fs *flag.FlagSet, c *cli) {
+			fs.IntVar(&c.memory, "memory", 128, "function memory in MiB")
+			fs.DurationVar(&c.functionTimeout, "function-timeout", 0, "how long a single invocation can run, up to 15m. The lambda default of 3s when zero")
+			fs.BoolVar(&c.fifo, "fifo", false, "use a fifo queue, processing jobs of the same message group in order")
+			fs.BoolVar(&c.contentDedup, "content-dedup", false, "deduplicate messages of the fifo queue by their content")
+			fs.IntVar(&c.maxReceiveCount, "max-receive-count", 5, "how many times a job is attempted before moving it to the dead-letter queue")
+			fs.IntVar(&c.batchSize, "batch-size", 1, "maximum number of queued jobs given to a single invocation")
+			fs.DurationVar(&c.batchWindow, "batch-window", 0, "how long to wait gathering queued jobs into a batch")
+			fs.StringVar(&c.kmsKey, "kms-key", "", "kms key to sign and encrypt payloads with")
+			fs.Var(&c.scheduleFlags, "schedule", "recurring job as name[@route]=expression[=json payload], eg 'hourly@report=rate(1 hour)'. Can be repeated")
+			fs.BoolVar(&c.stdoutTracing, "stdout-tracing", false, "export traces to the function logs")
+		},
+		run: (*cli).deploy,
+	},
+	{
+		name:    "invoke",
+		args:    "[payload]",
+		summary: "call the function with a json payload, read from stdin when not given, and print the result",
+		run:     (*cli).invoke,
+	},
+	{
+		name:    "submit",
+		args:    "[payload]",
+		summary: "submit a json payload, read from stdin when not given, to the function queue",
+		flags: func(fs *flag.FlagSet, c *cli) {
+			fs.StringVar(&c.group, "group", "", "message group, required by fifo queues")
+		},
+		run: (*cli).submit,
+	},
+	{
+		name:    "logs",
+		summary: "print the function logs",
+		flags: func(fs *flag.FlagSet, c *cli) {
+			fs.DurationVar(&c.since, "since", 10*time.Minute, "print logs from this long ago")
+			fs.BoolVar(&c.follow, "follow", false, "keep printing new logs until interrupted")
+		},
+		run: (*cli).logs,
+	},
+	{
+		name:    "status",
+		summary: "show the resources of the deployment and how many messages are queued",
+		run:     (*cli).status,
+	},
+	{
+		name:    "list",
+		summary: "list deployments, all of them unless --name is given",
+		run:     (*cli).list,
+	},
+	{
+		name:    "clean",
+		summary: "delete the deployment and all of its resources",
+		run:     (*cli).clean,
+	},
+	{
+		name:    "schedules",
+		args:    "[list | cancel <schedule>]",
+		summary: "list or cancel the schedules of the deployment",
+		run:     (*cli).schedules,
+	},
+	{
+		name:    "dlq",
+		args:    "list | redrive | purge",
+		summary: "inspect, move back or drop the messages in the dead-letter queue",
+		flags: func(fs *flag.FlagSet, c *cli) {
+			fs.IntVar(&c.max, "max", 100, "maximum number of messages to list")
+			fs.BoolVar(&c.edit, "edit", false, "edit the json input of every message in $EDITOR before redriving it. Saving an empty file leaves the message behind")
+		},
+		run: (*cli).dlq,
+	},
+}
+
+// runCLI runs the command in args, returning the process exit code. Commands act on
+// the latest deployment with the given --name, or the deployment with that id
+func runCLI(ctx context.Context, args []string, stdin io.Reader, stdout io.Writer, stderr io.Writer) int {
+	program := filepath.Base(os.Args[0])
+	if len(args) == 0 || args[0] == "help" || args[0] == "-h" || args[0] == "--help" {
+		cliUsage(stderr, program)
+		if len(args) == 0 {
+			return 2
+		}
+		return 0
+	}
+
+	var command *cliCommand
+	for i := range cliCommands {
+		if cliCommands[i].name == args[0] {
+			command = &cliCommands[i]
+		}
+	}
+	if command == nil {
+		fmt.Fprintf(stderr, "unknown command %q\n", args[0])
+		cliUsage(stderr, program)
+		return 2
+	}
+
+	c := cli{stdin: stdin, stdout: stdout, stderr: stderr}
+	fs := flag.NewFlagSet(command.name, flag.ContinueOnError)
+	fs.SetOutput(stderr)
+	fs.StringVar(&c.profile, "profile", "", "aws profile, the default one when empty")
+	fs.StringVar(&c.region, "region", "", "aws region, the one of the profile when empty")
+	fs.StringVar(&c.name, "name", defaultDeploymentName(), "deployment name, or id")
+	fs.DurationVar(&c.timeout, "timeout", 0, "how long to wait for the command, no limit when zero")
+	fs.StringVar(&c.output, "output", "text", "output format, text or json")
+	if command.flags != nil {
+		command.flags(fs, &c)
+	}
+	fs.Usage = func() {
+		fmt.Fprintf(stderr, "usage: %s\n\n%s\n\nflags:\n", strings.TrimSpace(program+" "+command.name+" [flags] "+command.args), command.summary)
+		fs.PrintDefaults()
+	}
+	if err := fs.Parse(args[1:]); err != nil {
+		if errors.Is(err, flag.ErrHelp) {
+			return 0
+		}
+		return 2
+	}
+	fs.Visit(func(f *flag.Flag) {
+		if f.Name == "name" {
+			c.nameSet = true
+		}
+	})
+	if c.output != "text" && c.output != "json" {
+		fmt.Fprintf(stderr, "invalid output %q, must be text or json\n", c.output)
+		return 2
+	}
+
+	awsOptions := []aws.Option{aws.WithEnvironmentEndpoints()}
+	if c.region != "" {
+		awsOptions = append(awsOptions, aws.WithRegion(c.region))
+	}
+	var err error
+	if c.aws, err = aws.New(c.profile, awsOptions...); err != nil {
+		fmt.Fprintf(stderr, "error: %v\n", err)
+		return 1
+	}
+
+	if c.timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, c.timeout)
+		defer cancel()
+	}
+
+	err = command.run(&c, ctx, fs.Args())
+	if errors.Is(err, errUsage) {
+		fs.Usage()
+		return 2
+	}
+	if err != nil {
+		fmt.Fprintf(stderr, "error: %v\n", err)
+		return 1
+	}
+	return 0
+}
+
+func cliUsage(w io.Writer, program string) {
+	fmt.Fprintf(w, "usage: %s <command> [flags] [args]\n\ncommands:\n", program)
+	writer := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+	for _, command := range cliCommands {
+		fmt.Fprintf(writer, "  %s\t%s\n", command.name, command.summary)
+	}
+	writer.Flush()
+	fmt.Fprintf(w, "\nrun %s <command> -h for the flags of a command\n", program)
+}
+
+// defaultDeploymentName is the name of the binary
+func defaultDeploymentName() string {
+	name := filepath.Base(os.Args[0])
+	return strings.TrimSuffix(name, filepath.Ext(name))
+}
+
+func (c *cli) deploy(ctx context.Context, args []string) error {
+	if len(args) > 0 {
+		return errUsage
+	}
+	// https://docs.aws.amazon.com/lambda/latest/dg/lambda-golang.html
+	if runtime.GOOS != "linux" || runtime.GOARCH != "amd64" {
+		return fmt.Errorf("lambda only runs linux/amd64 go binaries but this one is %s/%s, build it with GOOS=linux GOARCH=amd64", runtime.GOOS, runtime.GOARCH)
+	}
+	options, err := c.deployOptions()
+	if err != nil {
+		return err
+	}
+	executable, err := programBytes()
+	if err != nil {
+		return err
+	}
+
+	deployment, err := deploy.Deploy(ctx, c.aws, c.name, executable, int32(c.memory), options...)
+	if err != nil {
+		// Do not leave a half deployed function behind
+		if cleanErr := deployment.Clean(ctx, false); cleanErr != nil {
+			for _, err := range cleanErr.Errors {
+				fmt.Fprintf(c.stderr, "failed to clean up: %v\n", err)
+			}
+		}
+		return err
+	}
+	info := c.deploymentInfo(deployment)
+	return c.print(info, func(w io.Writer) {
+		c.printDeployment(w, info)
+	})
+}
+
+// deployOptions turns the deploy flags into deploy options
+func (c *cli) deployOptions() ([]deploy.Option, error) {
+	if c.contentDedup && !c.fifo {
+		return nil, fmt.Errorf("--content-dedup requires --fifo")
+	}
+	options := []deploy.Option{
+		deploy.WithTimeout(c.functionTimeout),
+		deploy.WithMaxReceiveCount(c.maxReceiveCount),
+		deploy.WithBatchSize(int32(c.batchSize)),
+		deploy.WithBatchWindow(c.batchWindow),
+	}
+	if c.fifo {
+		options = append(options, deploy.WithFIFO(c.contentDedup))
+	}
+	if c.kmsKey != "" {
+		options = append(options, deploy.WithKMSKey(c.kmsKey))
+	}
+	if c.stdoutTracing {
+		options = append(options, deploy.WithStdoutTracing())
+	}
+	for _, value := range c.scheduleFlags {
+		schedule, err := parseSchedule(value)
+		if err != nil {
+			return nil, err
+		}
+		options = append(options, deploy.WithRoutedSchedule(schedule.Name, schedule.Expression, schedule.Route, schedule.Payload))
+	}
+	return options, nil
+}
+
+// parseSchedule parses a --schedule flag, name[@route]=expression[=json payload]
+func parseSchedule(value string) (deploy.Schedule, error) {
+	parts := strings.SplitN(value, "=", 3)
+	name, route, _ := strings.Cut(parts[0], "@")
+	if len(parts) < 2 || name == "" || parts[1] == "" {
+		return deploy.Schedule{}, fmt.Errorf("invalid schedule %q, expected name[@route]=expression[=json payload]", value)
+	}
+	schedule := deploy.Schedule{Name: name, Expression: parts[1], Route: route}
+	if len(parts) == 3 {
+		if err := json.Unmarshal([]byte(parts[2]), &schedule.Payload); err != nil {
+			return deploy.Schedule{}, fmt.Errorf("invalid payload of schedule %s: %w", name, err)
+		}
+	}
+	return schedule, nil
+}
+
+// stringsFlag is a flag that can be given multiple times
+type stringsFlag []string
+
+func (f *stringsFlag) String() string {
+	return strings.Join(*f, ", ")
+}
+
+func (f *stringsFlag) Set(value string) error {
+	*f = append(*f, value)
+	return nil
+}
+
+func (c *cli) invoke(ctx context.Context, args []string) error {
+	payload, err := c.payload(args)
+	if err != nil {
+		return err
+	}
+	client, _, err := c.client(ctx)
+	if err != nil {
+		return err
+	}
+	out, err := client.Call(ctx, payload)
+	if err != nil {
+		return err
+	}
+	if c.output == "json" {
+		return json.NewEncoder(c.stdout).Encode(out)
+	}
+	data, err := json.MarshalIndent(out, "", "  ")
+	if err != nil {
+		return err
+	}
+	_, err = fmt.Fprintln(c.stdout, string(data))
+	return err
+}
+
+func (c *cli) submit(ctx context.Context, args []string) error {
+	payload, err := c.payload(args)
+	if err != nil {
+		return err
+	}
+	client, _, err := c.client(ctx)
+	if err != nil {
+		return err
+	}
+	options := []Option{}
+	if c.group != "" {
+		options = append(options, WithMessageGroup(c.group))
+	}
+	id, err := client.Submit(ctx, payload, options...)
+	if err != nil {
+		return err
+	}
+	return c.print(map[string]string{"messageId": id}, func(w io.Writer) {
+		fmt.Fprintf(w, "submitted message %s\n", id)
+	})
+}
+
+// logEvent is how logs are printed with json output, one per line
+type logEvent struct {
+	Timestamp time.Time `json:"timestamp"`
+	Stream    string    `json:"stream"`
+	Message   string    `json:"message"`
+}
+
+func (c *cli) logs(ctx context.Context, args []string) error {
+	if len(args) > 0 {
+		return errUsage
+	}
+	deployment, err := deploy.Find(ctx, c.aws, c.name)
+	if err != nil {
+		return err
+	}
+	logGroup := "/aws/lambda/" + *deployment.Function.Configuration.FunctionName
+
+	since := time.Now().Add(-c.since)
+	// Events at the last seen timestamp are fetched again when following
+	seen := map[string]bool{}
+	encoder := json.NewEncoder(c.stdout)
+	for {
+		events, err := c.aws.FilterLogEvents(ctx, logGroup, since)
+		if err != nil {
+			if c.follow && ctx.Err() != nil {
+				return nil
+			}
+			return err
+		}
+		for _, event := range events {
+			if seen[*event.EventId] {
+				continue
+			}
+			timestamp := time.UnixMilli(*event.Timestamp).UTC()
+			if timestamp.After(since) {
+				since = timestamp
+				seen = map[string]bool{}
+			}
+			seen[*event.EventId] = true
+
+			out := logEvent{Timestamp: timestamp, Stream: *event.LogStreamName, Message: strings.TrimRight(*event.Message, "\n")}
+			if c.output == "json" {
+				err = encoder.Encode(out)
+			} else {
+				_, err = fmt.Fprintf(c.stdout, "%s %s %s\n", out.Timestamp.Format(time.RFC3339Nano), out.Stream, out.Message)
+			}
+			if err != nil {
+				return err
+			}
+		}
+		if !c.follow {
+			return nil
+		}
+		select {
+		case <-time.After(2 * time.Second):
+		case <-ctx.Done():
+			return nil
+		}
+	}
+}
+
+func (c *cli) status(ctx context.Context, args []string) error {
+	if len(args) > 0 {
+		return errUsage
+	}
+	deployment, err := deploy.Find(ctx, c.aws, c.name)
+	if err != nil {
+		return err
+	}
+	info := c.deploymentInfo(deployment)
+	if deployment.Queue != nil {
+		attributes := deployment.Queue.Attributes
+		info.Messages = &queueStatus{
+			Visible:  atoi(attributes["ApproximateNumberOfMessages"]),
+			InFlight: atoi(attributes["ApproximateNumberOfMessagesNotVisible"]),
+			Delayed:  atoi(attributes["ApproximateNumberOfMessagesDelayed"]),
+		}
+		if deployment.DeadLetterQueue != nil {
+			info.Messages.DeadLetters = atoi(deployment.DeadLetterQueue.Attributes["ApproximateNumberOfMessages"])
+		}
+	}
+	if deployment.ScheduleGroup != "" {
+		schedules, err := deployment.Schedules(ctx)
+		if err != nil {
+			return err
+		}
+		count := len(schedules)
+		info.Schedules = &count
+	}
+	return c.print(info, func(w io.Writer) {
+		c.printDeployment(w, info)
+	})
+}
+
+func (c *cli) list(ctx context.Context, args []string) error {
+	if len(args) > 0 {
+		return errUsage
+	}
+	deployments, err := deploy.List(ctx, c.aws)
+	if err != nil {
+		return err
+	}
+	infos := []deploymentInfo{}
+	for _, deployment := range deployments {
+		if c.nameSet && deployment.Name() != c.name && deployment.ID != c.name {
+			continue
+		}
+		infos = append(infos, c.deploymentInfo(deployment))
+	}
+	return c.print(infos, func(w io.Writer) {
+		writer := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+		fmt.Fprintln(writer, "ID\tNAME\tSTATE\tMEMORY\tTIMEOUT\tLAST MODIFIED")
+		for _, info := range infos {
+			fmt.Fprintf(writer, "%s\t%s\t%s\t%s\t%s\t%s\n", info.ID, info.Name, info.State, orDash(info.Memory, " MiB"), orDash(info.Timeout, " s"), info.LastModified)
+		}
+		writer.Flush()
+	})
+}
+
+func (c *cli) clean(ctx context.Context, args []string) error {
+	if len(args) > 0 {
+		return errUsage
+	}
+	deployment, err := deploy.Find(ctx, c.aws, c.name)
+	if err != nil {
+		return err
+	}
+	if cleanErr := deployment.Clean(ctx, false); cleanErr != nil {
+		for _, err := range cleanErr.Errors {
+			fmt.Fprintf(c.stderr, "%v\n", err)
+		}
+		return fmt.Errorf("failed to clean deployment %s: %d errors", deployment.ID, len(cleanErr.Errors))
+	}
+	return c.print(map[string]string{"deleted": deployment.ID}, func(w io.Writer) {
+		fmt.Fprintf(w, "deleted deployment %s\n", deployment.ID)
+	})
+}
+
+func (c *cli) schedules(ctx context.Context, args []string) error {
+	if len(args) > 2 || (len(args) > 0 && args[0] != "list" && args[0] != "cancel") || (len(args) > 0 && args[0] == "cancel") != (len(args) == 2) {
+		return errUsage
+	}
+	deployment, err := deploy.Find(ctx, c.aws, c.name)
+	if err != nil {
+		return err
+	}
+
+	if len(args) == 2 {
+		if err := deployment.CancelSchedule(ctx, args[1]); err != nil {
+			return err
+		}
+		return c.print(map[string]string{"cancelled": args[1]}, func(w io.Writer) {
+			fmt.Fprintf(w, "cancelled schedule %s\n", args[1])
+		})
+	}
+
+	schedules, err := deployment.Schedules(ctx)
+	if err != nil {
+		return err
+	}
+	type scheduleInfo struct {
+		Name    string     `json:"name"`
+		State   string     `json:"state"`
+		Created *time.Time `json:"created,omitempty"`
+	}
+	infos := make([]scheduleInfo, len(schedules))
+	for i, schedule := range schedules {
+		infos[i] = scheduleInfo{Name: *schedule.Name, State: string(schedule.State), Created: schedule.CreationDate}
+	}
+	return c.print(infos, func(w io.Writer) {
+		writer := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+		fmt.Fprintln(writer, "NAME\tSTATE\tCREATED")
+		for _, info := range infos {
+			created := ""
+			if info.Created != nil {
+				created = info.Created.Format(time.RFC3339)
+			}
+			fmt.Fprintf(writer, "%s\t%s\t%s\n", info.Name, info.State, created)
+		}
+		writer.Flush()
+	})
+}
+
+func (c *cli) dlq(ctx context.Context, args []string) error {
+	if len(args) != 1 || (args[0] != "list" && args[0] != "redrive" && args[0] != "purge") {
+		return errUsage
+	}
+	client, _, err := c.client(ctx)
+	if err != nil {
+		return err
+	}
+
+	switch args[0] {
+	case "list":
+		letters, err := client.DeadLetters(ctx, c.max)
+		if err != nil {
+			return err
+		}
+		type deadLetterInfo struct {
+			MessageID    string          `json:"messageId"`
+			SentAt       time.Time       `json:"sentAt"`
+			ReceiveCount int             `json:"receiveCount"`
+			Payload      json.RawMessage `json:"payload,omitempty"`
+			Error        string          `json:"error,omitempty"`
+		}
+		infos := make([]deadLetterInfo, len(letters))
+		for i, letter := range letters {
+			infos[i] = deadLetterInfo{MessageID: letter.MessageID, SentAt: letter.SentAt, ReceiveCount: letter.ReceiveCount}
+			if letter.Err != nil {
+				infos[i].Error = letter.Err.Error()
+			} else if json.Valid(letter.Payload) {
+				infos[i].Payload = letter.Payload
+			} else {
+				infos[i].Payload, _ = json.Marshal(string(letter.Payload))
+			}
+		}
+		return c.print(infos, func(w io.Writer) {
+			for _, info := range infos {
+				fmt.Fprintf(w, "message %s, sent %s, received %d times\n", info.MessageID, info.SentAt.Format(time.RFC3339), info.ReceiveCount)
+				if info.Error != "" {
+					fmt.Fprintf(w, "  cannot decode: %s\n", info.Error)
+				}
+				fmt.Fprintf(w, "  %s\n", info.Payload)
+			}
+		})
+	case "redrive":
+		var edit func(context.Context, *DeadLetter) error
+		if c.edit {
+			edit = c.editDeadLetter
+		}
+		moved, err := client.Redrive(ctx, 0, edit)
+		if err != nil {
+			return err
+		}
+		return c.print(map[string]int{"redriven": moved}, func(w io.Writer) {
+			fmt.Fprintf(w, "redrove %d messages\n", moved)
+		})
+	case "purge":
+		if err := client.PurgeDeadLetters(ctx); err != nil {
+			return err
+		}
+		return c.print(map[string]bool{"purged": true}, func(w io.Writer) {
+			fmt.Fprintln(w, "purged dead-letter queue")
+		})
+	default:
+		return errUsage
+	}
+}
+
+// editDeadLetter opens the json input of the dead letter in $EDITOR
+func (c *cli) editDeadLetter(ctx context.Context, letter *DeadLetter) error {
+	if letter.Err != nil || letter.Codec.ContentType() != JSON.ContentType() {
+		fmt.Fprintf(c.stderr, "skipping message %s: only decodable json inputs can be edited\n", letter.MessageID)
+		return ErrSkipDeadLetter
+	}
+	file, err := os.CreateTemp("", "elaston-dlq-*.json")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(file.Name())
+	if _, err := file.Write(letter.Payload); err != nil {
+		return err
+	}
+	if err := file.Close(); err != nil {
+		return err
+	}
+
+	editor := os.Getenv("EDITOR")
+	if editor == "" {
+		editor = "vi"
+	}
+	cmd := exec.CommandContext(ctx, editor, file.Name())
+	cmd.Stdin, cmd.Stdout, cmd.Stderr = os.Stdin, os.Stdout, os.Stderr
+	if err := cmd.Run(); err != nil {
+		return err
+	}
+
+	edited, err := os.ReadFile(file.Name())
+	if err != nil {
+		return err
+	}
+	if len(bytes.TrimSpace(edited)) == 0 {
+		return ErrSkipDeadLetter
+	}
+	if bytes.Equal(edited, letter.Payload) {
+		return nil
+	}
+	return letter.SetInput(json.RawMessage(edited))
+}
+
+// client returns a client for the deployment, configured as its runtime is
+func (c *cli) client(ctx context.Context) (*Elaston, deploy.Deployment, error) {
+	deployment, err := deploy.Find(ctx, c.aws, c.name)
+	if err != nil {
+		return nil, deployment, err
+	}
+	if deployment.Queue == nil {
+		return nil, deployment, fmt.Errorf("the queue of deployment %s no longer exists", deployment.ID)
+	}
+
+	environment := deployment.Environment()
+	options, err := environmentOptions(c.aws, func(key string) (string, bool) {
+		// Spans exported to stdout would get mixed with the command output
+		if key == "ELASTON_TRACE_EXPORTER" {
+			return "", false
+		}
+		value, ok := environment[key]
+		return value, ok
+	})
+	if err != nil {
+		return nil, deployment, err
+	}
+	client := New(c.aws, *deployment.Function.Configuration.FunctionName, deployment.Queue.URL, options...)
+	return client, deployment, nil
+}
+
+// payload returns the json payload in args, or in stdin when not given or -
+func (c *cli) payload(args []string) (json.RawMessage, error) {
+	if len(args) > 1 {
+		return nil, errUsage
+	}
+	var payload []byte
+	if len(args) == 1 && args[0] != "-" {
+		payload = []byte(args[0])
+	} else {
+		var err error
+		if payload, err = io.ReadAll(c.stdin); err != nil {
+			return nil, err
+		}
+	}
+	payload = bytes.TrimSpace(payload)
+	if !json.Valid(payload) {
+		return nil, fmt.Errorf("payload is not valid json: %s", payload)
+	}
+	return payload, nil
+}
+
+// print writes the value as json, or calls text with json output disabled
+func (c *cli) print(value any, text func(io.Writer)) error {
+	if c.output != "json" {
+		text(c.stdout)
+		return nil
+	}
+	encoder := json.NewEncoder(c.stdout)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(value)
+}
+
+// deploymentInfo is how deployments are printed
+type deploymentInfo struct {
+	ID              string       `json:"id"`
+	Name            string       `json:"name"`
+	Function        string       `json:"function"`
+	State           string       `json:"state,omitempty"`
+	LastModified    string       `json:"lastModified,omitempty"`
+	Memory          int32        `json:"memory,omitempty"`
+	Timeout         int32        `json:"timeout,omitempty"`
+	Queue           string       `json:"queue,omitempty"`
+	DeadLetterQueue string       `json:"deadLetterQueue,omitempty"`
+	Bucket          string       `json:"bucket,omitempty"`
+	ScheduleGroup   string       `json:"scheduleGroup,omitempty"`
+	Messages        *queueStatus `json:"messages,omitempty"`
+	Schedules       *int         `json:"schedules,omitempty"`
+	Console         string       `json:"console,omitempty"`
+	Logs            string       `json:"logs,omitempty"`
+}
+
+type queueStatus struct {
+	Visible     int `json:"visible"`
+	InFlight    int `json:"inFlight"`
+	Delayed     int `json:"delayed"`
+	DeadLetters int `json:"deadLetters"`
+}
+
+func (c *cli) deploymentInfo(deployment deploy.Deployment) deploymentInfo {
+	info := deploymentInfo{ID: deployment.ID, Name: deployment.Name()}
+	if deployment.Function != nil && deployment.Function.Configuration != nil {
+		config := deployment.Function.Configuration
+		info.Function = *config.FunctionName
+		info.State = string(config.State)
+		if config.LastModified != nil {
+			info.LastModified = *config.LastModified
+		}
+		if config.MemorySize != nil {
+			info.Memory = *config.MemorySize
+		}
+		if config.Timeout != nil {
+			info.Timeout = *config.Timeout
+		}
+		info.Console = c.aws.LambdaFunctionConsoleURL(info.Function)
+		info.Logs = c.aws.LambdaFunctionLogsConsoleURL(info.Function)
+	}
+	if deployment.Queue != nil {
+		info.Queue = deployment.Queue.URL
+	}
+	if deployment.DeadLetterQueue != nil {
+		info.DeadLetterQueue = deployment.DeadLetterQueue.URL
+	}
+	if deployment.Bucket != nil {
+		info.Bucket = deployment.Bucket.Name
+	}
+	info.ScheduleGroup = deployment.ScheduleGroup
+	return info
+}
+
+func (c *cli) printDeployment(w io.Writer, info deploymentInfo) {
+	writer := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+	line := func(key string, value any) {
+		if value != "" {
+			fmt.Fprintf(writer, "%s\t%v\n", key, value)
+		}
+	}
+	line("id", info.ID)
+	line("name", info.Name)
+	line("function", info.Function)
+	line("state", info.State)
+	line("last modified", info.LastModified)
+	if info.Memory > 0 {
+		line("memory", fmt.Sprintf("%d MiB", info.Memory))
+	}
+	if info.Timeout > 0 {
+		line("timeout", fmt.Sprintf("%d s", info.Timeout))
+	}
+	line("queue", info.Queue)
+	line("dead-letter queue", info.DeadLetterQueue)
+	line("bucket", info.Bucket)
+	line("schedule group", info.ScheduleGroup)
+	if info.Messages != nil {
+		line("messages", fmt.Sprintf(
+			"%d visible, %d in flight, %d delayed, %d dead letters",
+			info.Messages.Visible, info.Messages.InFlight, info.Messages.Delayed, info.Messages.DeadLetters,
+		))
+	}
+	if info.Schedules != nil {
+		line("schedules", strconv.Itoa(*info.Schedules))
+	}
+	line("console", info.Console)
+	line("logs", info.Logs)
+	writer.Flush()
+}
+
+// orDash formats a number with its unit, or a dash for unknown zero values
+func orDash(value int32, unit string) string {
+	if value <= 0 {
+		return "-"
+	}
+	return strconv.Itoa(int(value)) + unit
+}
+
+func atoi(value string) int {
+	n, _ := strconv.Atoi(value)
+	return n
+}
+
+// programBytes returns the binary currently running
+func programBytes() ([]byte, error) {
+	executable, err := os.Executable()
+	if err != nil {
+		return nil, err
+	}
+	return os.ReadFile(executable)
+}
@@ -0,0 +1,190 @@
+package elaston
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/service/lambda"
+	lambdaT "github.com/aws/aws-sdk-go-v2/service/lambda/types"
+	"github.com/aws/aws-sdk-go-v2/service/sqs"
+
+	"github.com/bcap/elaston/aws"
+	"github.com/bcap/elaston/deploy"
+	"github.com/bcap/elaston/elastontest"
+)
+
+const testDeploymentID = "231010-101010000-test"
+
+func TestRunCLI(t *testing.T) {
+	tests := []struct {
+		name string
+		args []string
+		code int
+		// stderr is expected in the error output
+		stderr string
+	}{
+		{name: "no command", code: 2, stderr: "usage:"},
+		{name: "help", args: []string{"help"}, code: 0, stderr: "commands:"},
+		{name: "unknown command", args: []string{"nope"}, code: 2, stderr: `unknown command "nope"`},
+		{name: "command help", args: []string{"deploy", "-h"}, code: 0, stderr: "hourly@report=rate(1 hour)"},
+		{name: "unknown flag", args: []string{"status", "--nope"}, code: 2, stderr: "flag provided but not defined: -nope"},
+		{name: "invalid flag value", args: []string{"logs", "--since", "soon"}, code: 2, stderr: "invalid value"},
+		{name: "invalid output", args: []string{"status", "--output", "yaml"}, code: 2, stderr: `invalid output "yaml"`},
+		{name: "unexpected argument", args: []string{"status", "extra"}, code: 2, stderr: "usage:"},
+		{name: "flags after arguments", args: []string{"status", "extra", "--name", "test"}, code: 2, stderr: "usage:"},
+		{name: "too many payloads", args: []string{"invoke", "1", "2"}, code: 2, stderr: "usage:"},
+		{name: "cancel without schedule", args: []string{"schedules", "cancel"}, code: 2, stderr: "usage:"},
+		{name: "unknown dlq action", args: []string{"dlq", "drop"}, code: 2, stderr: "usage:"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// Keep the aws configuration from reaching out of the test
+			t.Setenv("AWS_REGION", "us-east-1")
+			t.Setenv("AWS_CONFIG_FILE", "/dev/null")
+			t.Setenv("AWS_SHARED_CREDENTIALS_FILE", "/dev/null")
+			stdout, stderr := bytes.Buffer{}, bytes.Buffer{}
+			code := runCLI(context.Background(), tt.args, strings.NewReader(""), &stdout, &stderr)
+			if code != tt.code {
+				t.Fatalf("expected exit code %d, got %d\n%s", tt.code, code, stderr.String())
+			}
+			if !strings.Contains(stderr.String(), tt.stderr) {
+				t.Fatalf("expected %q in the error output, got\n%s", tt.stderr, stderr.String())
+			}
+			if stdout.Len() > 0 {
+				t.Fatalf("expected nothing printed, got\n%s", stdout.String())
+			}
+		})
+	}
+}
+
+func TestParseSchedule(t *testing.T) {
+	tests := []struct {
+		name     string
+		value    string
+		schedule deploy.Schedule
+		err      bool
+	}{
+		{name: "schedule", value: "hourly=rate(1 hour)", schedule: deploy.Schedule{Name: "hourly", Expression: "rate(1 hour)"}},
+		{name: "route", value: "hourly@report=rate(1 hour)", schedule: deploy.Schedule{Name: "hourly", Route: "report", Expression: "rate(1 hour)"}},
+		{
+			name:     "payload",
+			value:    `hourly@report=cron(0 8 * * ? *)={"query":"a=b"}`,
+			schedule: deploy.Schedule{Name: "hourly", Route: "report", Expression: "cron(0 8 * * ? *)", Payload: map[string]any{"query": "a=b"}},
+		},
+		{name: "no expression", value: "hourly", err: true},
+		{name: "empty expression", value: "hourly=", err: true},
+		{name: "no name", value: "@report=rate(1 hour)", err: true},
+		{name: "invalid payload", value: "hourly=rate(1 hour)={", err: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			schedule, err := parseSchedule(tt.value)
+			if (err != nil) != tt.err {
+				t.Fatalf("expected an error to be %v, got %v", tt.err, err)
+			}
+			if !reflect.DeepEqual(schedule, tt.schedule) {
+				t.Fatalf("expected %+v, got %+v", tt.schedule, schedule)
+			}
+		})
+	}
+}
+
+func TestCLIOutput(t *testing.T) {
+	tests := []struct {
+		name    string
+		command string
+		args    []string
+		// text is expected in the text output and json in the json output, compacted
+		text string
+		json string
+	}{
+		{name: "list", command: "list", text: testDeploymentID + "  test", json: `"id":"` + testDeploymentID + `","name":"test"`},
+		{name: "status", command: "status", text: "schedules", json: `"messages":{"visible":3,"inFlight":0,"delayed":0,"deadLetters":0},"schedules":1`},
+		{name: "submit", command: "submit", args: []string{`{"a":1}`}, text: "submitted message message-1", json: `{"messageId":"message-1"}`},
+		{name: "invoke", command: "invoke", args: []string{"1"}, text: `"b": 2`, json: `{"b":2}`},
+		{name: "schedules", command: "schedules", text: "hourly", json: `[{"name":"hourly","state":"ENABLED"}]`},
+		{name: "cancel schedule", command: "schedules", args: []string{"cancel", "hourly"}, text: "cancelled schedule hourly", json: `{"cancelled":"hourly"}`},
+	}
+	for _, tt := range tests {
+		for _, output := range []string{"text", "json"} {
+			t.Run(tt.name+" "+output, func(t *testing.T) {
+				stdout := bytes.Buffer{}
+				c := cli{
+					name:   "test",
+					output: output,
+					aws:    testDeployment(t),
+					stdin:  strings.NewReader(""),
+					stdout: &stdout,
+					stderr: &bytes.Buffer{},
+				}
+				var command *cliCommand
+				for i := range cliCommands {
+					if cliCommands[i].name == tt.command {
+						command = &cliCommands[i]
+					}
+				}
+				if err := command.run(&c, context.Background(), tt.args); err != nil {
+					t.Fatal(err)
+				}
+
+				if output == "text" {
+					if !strings.Contains(stdout.String(), tt.text) {
+						t.Fatalf("expected %q in the output, got\n%s", tt.text, stdout.String())
+					}
+					return
+				}
+				compacted := bytes.Buffer{}
+				if err := json.Compact(&compacted, stdout.Bytes()); err != nil {
+					t.Fatalf("invalid json output: %v\n%s", err, stdout.String())
+				}
+				if !strings.Contains(compacted.String(), tt.json) {
+					t.Fatalf("expected %s in the output, got\n%s", tt.json, compacted.String())
+				}
+			})
+		}
+	}
+}
+
+// testDeployment returns fake aws services holding the deployment testDeploymentID,
+// with an hourly schedule and 3 queued messages. Its function answers {"b":2}
+func testDeployment(t *testing.T) *aws.AWS {
+	t.Helper()
+	fakes := elastontest.New()
+	ctx := context.Background()
+	group := "elaston-schedules-" + testDeploymentID
+	if err := fakes.AWS().CreateSchedule(ctx, group, aws.Schedule{Name: "hourly", Expression: "rate(1 hour)", TargetARN: "function"}); err != nil {
+		t.Fatal(err)
+	}
+
+	functionName := "elaston-lambda-" + testDeploymentID
+	queueName := "elaston-queue-" + testDeploymentID
+	function := lambdaT.FunctionConfiguration{
+		FunctionName: &functionName,
+		Environment: &lambdaT.EnvironmentResponse{Variables: map[string]string{
+			"ELASTON_SQS_QUEUE_URL":      "https://sqs.us-east-1.amazonaws.com/" + elastontest.FakeAccount + "/" + queueName,
+			"ELASTON_SCHEDULE_GROUP":     group,
+			"ELASTON_SCHEDULER_ROLE_ARN": "arn:aws:iam::" + elastontest.FakeAccount + ":role/scheduler",
+			"ELASTON_FUNCTION_ARN":       "arn:aws:lambda:us-east-1:" + elastontest.FakeAccount + ":function:" + functionName,
+		}},
+	}
+	fakes.Lambda.Handle("ListFunctions", func(any) (any, error) {
+		return &lambda.ListFunctionsOutput{Functions: []lambdaT.FunctionConfiguration{function}}, nil
+	})
+	fakes.Lambda.Handle("GetFunction", func(any) (any, error) {
+		return &lambda.GetFunctionOutput{Configuration: &function}, nil
+	})
+	fakes.Lambda.Handle("Invoke", func(any) (any, error) {
+		return &lambda.InvokeOutput{StatusCode: 200, Payload: []byte(`{"b":2}`)}, nil
+	})
+	fakes.SQS.Handle("GetQueueAttributes", func(input any) (any, error) {
+		if !strings.HasSuffix(*input.(*sqs.GetQueueAttributesInput).QueueUrl, queueName) {
+			return nil, nil
+		}
+		return &sqs.GetQueueAttributesOutput{Attributes: map[string]string{"ApproximateNumberOfMessages": "3"}}, nil
+	})
+	return fakes.AWS()
+}
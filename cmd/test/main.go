@@ -10,20 +10,24 @@ import (
 )
 
 func main() {
-	elaston.Run(elaston.HandlerFunc(Handler))
+	elaston.Run(elaston.TypedFunc(Handler))
 }
 
-func Handler(ctx context.Context, elaston *elaston.Elaston, rawInput any) (any, error) {
+type Input struct {
+	A      int  `json:"a"`
+	Submit bool `json:"submit"`
+}
+
+func Handler(ctx context.Context, e *elaston.Elaston, input Input) (map[string]any, error) {
 	result := map[string]any{}
 	set := func(name string, value any) {
 		result[name] = value
 		spew.Printf("HANDLER LOG | %s: %#+v\n", name, value)
 	}
 
-	input := rawInput.(map[string]any)
-	if _, ok := input["submit"]; !ok {
-		input["submit"] = true
-		if _, err := elaston.Submit(ctx, input); err != nil {
+	if !input.Submit {
+		input.Submit = true
+		if _, err := elaston.Submit(ctx, e, input); err != nil {
 			return nil, err
 		}
 	}
@@ -0,0 +1,175 @@
+package elaston
+
+import (
+	"bytes"
+	"encoding/gob"
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"sync"
+
+	"github.com/vmihailenco/msgpack/v5"
+	"google.golang.org/protobuf/proto"
+)
+
+// contentTypeHeader records which codec encoded the payload, so the other side can
+// decode it with the same codec. It is omitted for JSON, which is what payloads
+// without it are assumed to be
+const contentTypeHeader = "elaston-content-type"
+
+// Codec encodes and decodes payloads and results
+type Codec interface {
+	ContentType() string
+	Marshal(any) ([]byte, error)
+	Unmarshal([]byte, any) error
+}
+
+var (
+	JSON Codec = jsonCodec{}
+	// Gob uses encoding/gob. It cannot decode into an any, so it requires typed
+	// handlers and the typed Call, Submit and SubmitFuture
+	Gob Codec = gobCodec{}
+	// MessagePack preserves integer types and encodes binary data efficiently
+	MessagePack Codec = msgpackCodec{}
+	// Protobuf only works with proto.Message values, so it requires typed handlers
+	// and the typed Call, Submit and SubmitFuture
+	Protobuf Codec = protobufCodec{}
+)
+
+var codecs = struct {
+	byContentType map[string]Codec
+	mutex         sync.RWMutex
+}{
+	byContentType: map[string]Codec{
+		JSON.ContentType():        JSON,
+		Gob.ContentType():         Gob,
+		MessagePack.ContentType(): MessagePack,
+		Protobuf.ContentType():    Protobuf,
+	},
+}
+
+// RegisterCodec makes a custom codec available to the runtime, so it can decode
+// payloads encoded with it
+func RegisterCodec(codec Codec) {
+	codecs.mutex.Lock()
+	defer codecs.mutex.Unlock()
+	codecs.byContentType[codec.ContentType()] = codec
+}
+
+// codecFor returns the codec for the content type recorded in the message headers
+func codecFor(headers map[string]string) (Codec, error) {
+	contentType, ok := headers[contentTypeHeader]
+	if !ok {
+		return JSON, nil
+	}
+	codecs.mutex.RLock()
+	defer codecs.mutex.RUnlock()
+	codec, ok := codecs.byContentType[contentType]
+	if !ok {
+		return nil, fmt.Errorf("no codec registered for content type %q", contentType)
+	}
+	return codec, nil
+}
+
+// setContentType records the codec in the message headers
+func (m *message) setContentType(codec Codec) {
+	if codec.ContentType() != JSON.ContentType() {
+		m.setHeader(contentTypeHeader, codec.ContentType())
+	}
+}
+
+//
+// JSON
+//
+
+type jsonCodec struct{}
+
+func (jsonCodec) ContentType() string {
+	return "application/json"
+}
+
+func (jsonCodec) Marshal(value any) ([]byte, error) {
+	return json.Marshal(value)
+}
+
+func (jsonCodec) Unmarshal(data []byte, value any) error {
+	return json.Unmarshal(data, value)
+}
+
+//
+// Gob
+//
+
+type gobCodec struct{}
+
+func (gobCodec) ContentType() string {
+	return "application/x-gob"
+}
+
+func (gobCodec) Marshal(value any) ([]byte, error) {
+	buf := bytes.Buffer{}
+	if err := gob.NewEncoder(&buf).Encode(value); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func (gobCodec) Unmarshal(data []byte, value any) error {
+	return gob.NewDecoder(bytes.NewReader(data)).Decode(value)
+}
+
+//
+// MessagePack
+//
+
+type msgpackCodec struct{}
+
+func (msgpackCodec) ContentType() string {
+	return "application/msgpack"
+}
+
+func (msgpackCodec) Marshal(value any) ([]byte, error) {
+	return msgpack.Marshal(value)
+}
+
+func (msgpackCodec) Unmarshal(data []byte, value any) error {
+	return msgpack.Unmarshal(data, value)
+}
+
+//
+// Protobuf
+//
+
+type protobufCodec struct{}
+
+func (protobufCodec) ContentType() string {
+	return "application/x-protobuf"
+}
+
+func (protobufCodec) Marshal(value any) ([]byte, error) {
+	msg, ok := value.(proto.Message)
+	if !ok {
+		return nil, fmt.Errorf("protobuf codec cannot encode %T, it is not a proto.Message", value)
+	}
+	return proto.Marshal(msg)
+}
+
+func (protobufCodec) Unmarshal(data []byte, value any) error {
+	if msg, ok := value.(proto.Message); ok {
+		return proto.Unmarshal(data, msg)
+	}
+	// Typed handlers and calls decode into a pointer to their type, which for
+	// generated messages is a pointer to a pointer
+	target := reflect.ValueOf(value)
+	if target.Kind() == reflect.Pointer && target.Elem().Kind() == reflect.Pointer {
+		allocated := reflect.New(target.Elem().Type().Elem())
+		if msg, ok := allocated.Interface().(proto.Message); ok {
+			if err := proto.Unmarshal(data, msg); err != nil {
+				return err
+			}
+			target.Elem().Set(allocated)
+			return nil
+		}
+	}
+	return fmt.Errorf("protobuf codec cannot decode into %T, it is not a proto.Message", value)
+}
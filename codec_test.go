@@ -0,0 +1,135 @@
+package elaston
+
+import (
+	"context"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/service/lambda"
+	"google.golang.org/protobuf/types/known/wrapperspb"
+
+	"github.com/bcap/elaston/elastontest"
+)
+
+type point struct {
+	X, Y int
+}
+
+func TestCodecs(t *testing.T) {
+	sum := TypedFunc(func(ctx context.Context, e *Elaston, in point) (point, error) {
+		return point{X: in.X + in.Y}, nil
+	})
+	upper := TypedFunc(func(ctx context.Context, e *Elaston, in *wrapperspb.StringValue) (*wrapperspb.StringValue, error) {
+		return wrapperspb.String(strings.ToUpper(in.Value)), nil
+	})
+	tests := []struct {
+		codec   Codec
+		handler Handler
+		call    func(context.Context, *Elaston) (any, error)
+		output  any
+	}{
+		{
+			codec:   JSON,
+			handler: sum,
+			call:    func(ctx context.Context, e *Elaston) (any, error) { return Call[point, point](ctx, e, point{1, 2}) },
+			output:  point{X: 3},
+		},
+		{
+			codec:   Gob,
+			handler: sum,
+			call:    func(ctx context.Context, e *Elaston) (any, error) { return Call[point, point](ctx, e, point{1, 2}) },
+			output:  point{X: 3},
+		},
+		{
+			codec:   MessagePack,
+			handler: sum,
+			call:    func(ctx context.Context, e *Elaston) (any, error) { return Call[point, point](ctx, e, point{1, 2}) },
+			output:  point{X: 3},
+		},
+		{
+			codec:   Protobuf,
+			handler: upper,
+			call: func(ctx context.Context, e *Elaston) (any, error) {
+				out, err := Call[*wrapperspb.StringValue, *wrapperspb.StringValue](ctx, e, wrapperspb.String("text"))
+				if err != nil {
+					return nil, err
+				}
+				return out.GetValue(), nil
+			},
+			output: "TEXT",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.codec.ContentType(), func(t *testing.T) {
+			ctx := context.Background()
+			local := newTestLocal(t, tt.handler)
+			out, err := tt.call(ctx, local.Client(WithCodec(tt.codec)))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(out, tt.output) {
+				t.Fatalf("expected output %v, got %v", tt.output, out)
+			}
+
+			// The content type travels along so the runtime picks the same codec
+			fakes := elastontest.New()
+			tt.call(ctx, newTestClient(fakes, WithCodec(tt.codec)))
+			input := fakes.Lambda.CallsTo("Invoke")[0].Input.(*lambda.InvokeInput)
+			codec, err := codecFor(messageFromInvokePayload(input.Payload).headers)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if codec != tt.codec {
+				t.Fatalf("expected the payload to be sent as %s, got %s", tt.codec.ContentType(), codec.ContentType())
+			}
+		})
+	}
+}
+
+// upperCodec is a custom codec, encoding strings in upper case
+type upperCodec struct{}
+
+func (upperCodec) ContentType() string {
+	return "text/upper"
+}
+
+func (upperCodec) Marshal(value any) ([]byte, error) {
+	return []byte(strings.ToUpper(value.(string))), nil
+}
+
+func (upperCodec) Unmarshal(data []byte, value any) error {
+	reflect.ValueOf(value).Elem().Set(reflect.ValueOf(string(data)))
+	return nil
+}
+
+func TestCodecFor(t *testing.T) {
+	RegisterCodec(upperCodec{})
+	tests := []struct {
+		name    string
+		headers map[string]string
+		codec   Codec
+	}{
+		{name: "no content type", headers: nil, codec: JSON},
+		{name: "builtin", headers: map[string]string{contentTypeHeader: MessagePack.ContentType()}, codec: MessagePack},
+		{name: "registered", headers: map[string]string{contentTypeHeader: "text/upper"}, codec: upperCodec{}},
+		{name: "unknown", headers: map[string]string{contentTypeHeader: "text/unknown"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			codec, err := codecFor(tt.headers)
+			if tt.codec == nil {
+				if err == nil {
+					t.Fatalf("expected an error for an unknown content type, got %v", codec)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if codec != tt.codec {
+				t.Fatalf("expected %s, got %s", tt.codec.ContentType(), codec.ContentType())
+			}
+		})
+	}
+}
@@ -0,0 +1,210 @@
+package elaston
+
+import (
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"sync"
+
+	"github.com/klauspost/compress/zstd"
+)
+
+const (
+	// contentEncodingHeader records the algorithm the body was compressed with
+	contentEncodingHeader = "elaston-content-encoding"
+	// acceptEncodingHeader tells the runtime which algorithm the caller accepts for
+	// compressing the response
+	acceptEncodingHeader = "elaston-accept-encoding"
+
+	DefaultCompressionThreshold = 8 * 1024
+)
+
+// Compressor is a compression algorithm that can be used for payloads and results
+type Compressor interface {
+	Name() string
+	Compress([]byte) ([]byte, error)
+	Decompress([]byte) ([]byte, error)
+}
+
+// Compression configures payload compression
+type Compression struct {
+	Compressor Compressor
+	// Threshold is the encoded payload size from which payloads get compressed.
+	// Defaults to DefaultCompressionThreshold
+	Threshold int
+	// OnCompress, when set, is called every time a payload or result is compressed,
+	// eg to collect compression ratio metrics
+	OnCompress func(CompressionStats)
+}
+
+type CompressionStats struct {
+	Algorithm      string
+	OriginalSize   int
+	CompressedSize int
+}
+
+func (s CompressionStats) Ratio() float64 {
+	if s.CompressedSize == 0 {
+		return 0
+	}
+	return float64(s.OriginalSize) / float64(s.CompressedSize)
+}
+
+var (
+	Gzip Compressor = gzipCompressor{}
+	Zstd Compressor = &zstdCompressor{}
+)
+
+var compressors = map[string]Compressor{
+	Gzip.Name(): Gzip,
+	Zstd.Name(): Zstd,
+}
+
+// compress compresses the message body when it goes over the configured threshold
+func (c *Compression) compress(msg message) (message, error) {
+	if c == nil || c.Compressor == nil {
+		return msg, nil
+	}
+	threshold := c.Threshold
+	if threshold <= 0 {
+		threshold = DefaultCompressionThreshold
+	}
+	if len(msg.body) < threshold {
+		return msg, nil
+	}
+
+	compressed, err := c.Compressor.Compress(msg.body)
+	if err != nil {
+		return msg, err
+	}
+	if c.OnCompress != nil {
+		c.OnCompress(CompressionStats{
+			Algorithm:      c.Compressor.Name(),
+			OriginalSize:   len(msg.body),
+			CompressedSize: len(compressed),
+		})
+	}
+	// Not worth it, eg the payload was already compressed
+	if len(compressed) >= len(msg.body) {
+		return msg, nil
+	}
+
+	result := message{body: compressed}
+	for key, value := range msg.headers {
+		result.setHeader(key, value)
+	}
+	result.setHeader(contentEncodingHeader, c.Compressor.Name())
+	return result, nil
+}
+
+// decompress reverts compress, using the algorithm recorded in the message headers
+func decompress(msg message) (message, error) {
+	algorithm, ok := msg.headers[contentEncodingHeader]
+	if !ok {
+		return msg, nil
+	}
+	compressor, ok := compressors[algorithm]
+	if !ok {
+		return msg, fmt.Errorf("unsupported content encoding %q", algorithm)
+	}
+	body, err := compressor.Decompress(msg.body)
+	if err != nil {
+		return msg, fmt.Errorf("failed to decompress %s payload: %w", algorithm, err)
+	}
+
+	result := message{body: body}
+	for key, value := range msg.headers {
+		if key != contentEncodingHeader {
+			result.setHeader(key, value)
+		}
+	}
+	return result, nil
+}
+
+// responseCompression returns the compression the runtime should use for the
+// response, based on what the caller accepts
+func (e *Elaston) responseCompression(requestHeaders map[string]string) *Compression {
+	compressor, ok := compressors[requestHeaders[acceptEncodingHeader]]
+	if !ok {
+		return nil
+	}
+	compression := Compression{Compressor: compressor}
+	if e.compression != nil {
+		compression.Threshold = e.compression.Threshold
+		compression.OnCompress = e.compression.OnCompress
+	}
+	return &compression
+}
+
+//
+// Gzip
+//
+
+type gzipCompressor struct{}
+
+func (gzipCompressor) Name() string {
+	return "gzip"
+}
+
+func (gzipCompressor) Compress(data []byte) ([]byte, error) {
+	buf := bytes.Buffer{}
+	writer := gzip.NewWriter(&buf)
+	if _, err := writer.Write(data); err != nil {
+		return nil, err
+	}
+	if err := writer.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func (gzipCompressor) Decompress(data []byte) ([]byte, error) {
+	reader, err := gzip.NewReader(bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+	defer reader.Close()
+	return io.ReadAll(reader)
+}
+
+//
+// Zstd
+//
+
+// zstdCompressor lazily creates a shared encoder and decoder, which are safe for
+// concurrent use through EncodeAll and DecodeAll
+type zstdCompressor struct {
+	encoder *zstd.Encoder
+	decoder *zstd.Decoder
+	once    sync.Once
+	initErr error
+}
+
+func (z *zstdCompressor) init() error {
+	z.once.Do(func() {
+		if z.encoder, z.initErr = zstd.NewWriter(nil); z.initErr != nil {
+			return
+		}
+		z.decoder, z.initErr = zstd.NewReader(nil)
+	})
+	return z.initErr
+}
+
+func (z *zstdCompressor) Name() string {
+	return "zstd"
+}
+
+func (z *zstdCompressor) Compress(data []byte) ([]byte, error) {
+	if err := z.init(); err != nil {
+		return nil, err
+	}
+	return z.encoder.EncodeAll(data, nil), nil
+}
+
+func (z *zstdCompressor) Decompress(data []byte) ([]byte, error) {
+	if err := z.init(); err != nil {
+		return nil, err
+	}
+	return z.decoder.DecodeAll(data, nil)
+}
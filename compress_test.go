@@ -0,0 +1,141 @@
+package elaston
+
+import (
+	"context"
+	"crypto/rand"
+	"strings"
+	"testing"
+)
+
+func TestCompression(t *testing.T) {
+	random := make([]byte, 16*1024)
+	if _, err := rand.Read(random); err != nil {
+		t.Fatal(err)
+	}
+	repeated := []byte(strings.Repeat("elaston", 2048))
+	tests := []struct {
+		name       string
+		compressor Compressor
+		threshold  int
+		body       []byte
+		compressed bool
+		stats      int
+	}{
+		{name: "gzip", compressor: Gzip, body: repeated, compressed: true, stats: 1},
+		{name: "zstd", compressor: Zstd, body: repeated, compressed: true, stats: 1},
+		{name: "under the default threshold", compressor: Gzip, body: repeated[:1024]},
+		{name: "custom threshold", compressor: Zstd, threshold: 512, body: repeated[:1024], compressed: true, stats: 1},
+		{name: "incompressible", compressor: Gzip, body: random, stats: 1},
+		{name: "disabled", body: repeated},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			stats := []CompressionStats{}
+			compression := &Compression{
+				Compressor: tt.compressor,
+				Threshold:  tt.threshold,
+				OnCompress: func(s CompressionStats) { stats = append(stats, s) },
+			}
+			original := message{headers: map[string]string{"team": "data"}, body: tt.body}
+
+			msg, err := compression.compress(original)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if compressed := msg.headers[contentEncodingHeader] != ""; compressed != tt.compressed {
+				t.Fatalf("expected compressed to be %v, got headers %v", tt.compressed, msg.headers)
+			}
+			if tt.compressed && len(msg.body) >= len(tt.body) {
+				t.Fatalf("expected the body to shrink from %d bytes, got %d", len(tt.body), len(msg.body))
+			}
+			if len(stats) != tt.stats {
+				t.Fatalf("expected %d compression stats, got %d", tt.stats, len(stats))
+			}
+			if tt.stats > 0 && (stats[0].OriginalSize != len(tt.body) || stats[0].Ratio() <= 0) {
+				t.Fatalf("unexpected compression stats %+v", stats[0])
+			}
+
+			decompressed, err := decompress(msg)
+			if err != nil {
+				t.Fatal(err)
+			}
+			assertSameMessage(t, original, decompressed)
+		})
+	}
+}
+
+func TestDecompressUnsupported(t *testing.T) {
+	msg := message{headers: map[string]string{contentEncodingHeader: "lz4"}, body: []byte("data")}
+	if _, err := decompress(msg); err == nil {
+		t.Fatal("expected an error for an unsupported encoding")
+	}
+	msg.headers[contentEncodingHeader] = Gzip.Name()
+	if _, err := decompress(msg); err == nil {
+		t.Fatal("expected an error for a corrupt body")
+	}
+}
+
+func TestCompressionNegotiation(t *testing.T) {
+	tests := []struct {
+		name      string
+		client    *Compression
+		runtime   *Compression
+		accepted  Compressor
+		threshold int
+	}{
+		{name: "not accepted"},
+		{name: "accepted", client: &Compression{Compressor: Zstd}, accepted: Zstd},
+		{
+			name:      "runtime threshold",
+			client:    &Compression{Compressor: Gzip},
+			runtime:   &Compression{Compressor: Zstd, Threshold: 100},
+			accepted:  Gzip,
+			threshold: 100,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// The runtime answers with the algorithm the caller accepts, whatever its own is
+			runtime := New(nil, "function", testQueueURL)
+			if tt.runtime != nil {
+				runtime = New(nil, "function", testQueueURL, WithCompression(*tt.runtime))
+			}
+			headers := map[string]string{}
+			if tt.client != nil {
+				headers[acceptEncodingHeader] = tt.client.Compressor.Name()
+			}
+			compression := runtime.responseCompression(headers)
+			if tt.accepted == nil {
+				if compression != nil {
+					t.Fatalf("expected no response compression, got %v", compression.Compressor.Name())
+				}
+				return
+			}
+			if compression.Compressor != tt.accepted || compression.Threshold != tt.threshold {
+				t.Fatalf("unexpected response compression %+v", compression)
+			}
+
+			// Large inputs and outputs make it through both ways
+			local := newTestLocal(t, HandlerFunc(func(ctx context.Context, e *Elaston, in any) (any, error) {
+				return in, nil
+			}))
+			input := strings.Repeat("elaston", 4096)
+			out, err := local.Client(WithCompression(*tt.client)).Call(context.Background(), input)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if out != input {
+				t.Fatalf("expected the input back, got %d bytes", len(out.(string)))
+			}
+		})
+	}
+}
+
+func TestCompressionStatsRatio(t *testing.T) {
+	if ratio := (CompressionStats{OriginalSize: 100, CompressedSize: 25}).Ratio(); ratio != 4 {
+		t.Fatalf("expected a ratio of 4, got %v", ratio)
+	}
+	if ratio := (CompressionStats{OriginalSize: 100}).Ratio(); ratio != 0 {
+		t.Fatalf("expected a ratio of 0 without a compressed size, got %v", ratio)
+	}
+}
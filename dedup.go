@@ -0,0 +1,78 @@
+package elaston
+
+import (
+	"context"
+	"sync"
+
+	"github.com/bcap/elaston/aws"
+)
+
+// DedupStore remembers the dedup ids of the messages already processed, so the
+// runtime can discard the duplicates a retried job may submit
+type DedupStore interface {
+	Seen(ctx context.Context, id string) (bool, error)
+	Mark(ctx context.Context, id string) error
+}
+
+// WithDedupStore sets where the runtime records the dedup ids of processed messages.
+// Without a store duplicates are processed again
+func WithDedupStore(store DedupStore) Option {
+	return func(e *elaston) {
+		e.dedup = store
+	}
+}
+
+//
+// S3
+//
+
+// S3DedupStore keeps one empty marker object per processed message. Expiring the
+// prefix with a lifecycle rule bounds how long duplicates are detected
+type S3DedupStore struct {
+	aws    *aws.AWS
+	bucket string
+	prefix string
+}
+
+func NewS3DedupStore(aws *aws.AWS, bucket string, prefix string) *S3DedupStore {
+	return &S3DedupStore{aws: aws, bucket: bucket, prefix: prefix}
+}
+
+func (s *S3DedupStore) Seen(ctx context.Context, id string) (bool, error) {
+	data, err := s.aws.GetS3Object(ctx, s.bucket, s.prefix+id)
+	if err != nil {
+		return false, err
+	}
+	return data != nil, nil
+}
+
+func (s *S3DedupStore) Mark(ctx context.Context, id string) error {
+	return s.aws.PutS3Object(ctx, s.bucket, s.prefix+id, []byte{})
+}
+
+//
+// Memory
+//
+
+type MemoryDedupStore struct {
+	seen  map[string]struct{}
+	mutex sync.Mutex
+}
+
+func NewMemoryDedupStore() *MemoryDedupStore {
+	return &MemoryDedupStore{seen: map[string]struct{}{}}
+}
+
+func (s *MemoryDedupStore) Seen(ctx context.Context, id string) (bool, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	_, ok := s.seen[id]
+	return ok, nil
+}
+
+func (s *MemoryDedupStore) Mark(ctx context.Context, id string) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.seen[id] = struct{}{}
+	return nil
+}
@@ -7,6 +7,8 @@ import (
 	"github.com/aws/aws-sdk-go-v2/service/iam"
 	"github.com/aws/aws-sdk-go-v2/service/lambda"
 	"github.com/aws/aws-sdk-go-v2/service/sqs"
+
+	"github.com/bcap/elaston/aws"
 )
 
 type CleanupError struct {
@@ -22,12 +24,16 @@ func (d *Deployment) Clean(ctx context.Context, keepFunction bool) *CleanupError
 	log.Printf("Cleaning up deployment %s", d.ID)
 
 	errors := []error{}
+	errors = append(errors, d.deleteScheduleGroup(ctx)...)
 	errors = append(errors, d.deleteLambdaEventSourceMappings(ctx)...)
 	if !keepFunction {
 		errors = append(errors, d.deleteLambdaFunction(ctx)...)
 	}
-	errors = append(errors, d.deleteSQSQueue(ctx)...)
-	errors = append(errors, d.deleteIAMRole(ctx)...)
+	errors = append(errors, d.deleteSQSQueue(ctx, d.Queue)...)
+	errors = append(errors, d.deleteSQSQueue(ctx, d.DeadLetterQueue)...)
+	errors = append(errors, d.deleteS3Bucket(ctx)...)
+	errors = append(errors, d.deleteIAMRole(ctx, d.Role)...)
+	errors = append(errors, d.deleteIAMRole(ctx, d.SchedulerRole)...)
 
 	if len(errors) == 0 {
 		return nil
@@ -38,6 +44,17 @@ func (d *Deployment) Clean(ctx context.Context, keepFunction bool) *CleanupError
 	}
 }
 
+func (d *Deployment) deleteScheduleGroup(ctx context.Context) []error {
+	if d.ScheduleGroup == "" {
+		return nil
+	}
+
+	if err := d.aws.DeleteScheduleGroup(ctx, d.ScheduleGroup); err != nil {
+		return []error{err}
+	}
+	return nil
+}
+
 func (d *Deployment) deleteLambdaEventSourceMappings(ctx context.Context) []error {
 	if d.Function == nil {
 		return nil
@@ -76,13 +93,13 @@ func (d *Deployment) deleteLambdaFunction(ctx context.Context) []error {
 	return nil
 }
 
-func (d *Deployment) deleteSQSQueue(ctx context.Context) []error {
-	if d.Queue == nil {
+func (d *Deployment) deleteSQSQueue(ctx context.Context, queue *aws.Queue) []error {
+	if queue == nil {
 		return nil
 	}
 
 	_, err := d.aws.SQS.DeleteQueue(ctx, &sqs.DeleteQueueInput{
-		QueueUrl: &d.Queue.URL,
+		QueueUrl: &queue.URL,
 	})
 	if err != nil {
 		return []error{err}
@@ -90,8 +107,19 @@ func (d *Deployment) deleteSQSQueue(ctx context.Context) []error {
 	return nil
 }
 
-func (d *Deployment) deleteIAMRole(ctx context.Context) []error {
-	if d.Role == nil {
+func (d *Deployment) deleteS3Bucket(ctx context.Context) []error {
+	if d.Bucket == nil {
+		return nil
+	}
+
+	if err := d.aws.DeleteBucket(ctx, d.Bucket.Name); err != nil {
+		return []error{err}
+	}
+	return nil
+}
+
+func (d *Deployment) deleteIAMRole(ctx context.Context, role *aws.Role) []error {
+	if role == nil {
 		return nil
 	}
 
@@ -99,22 +127,22 @@ func (d *Deployment) deleteIAMRole(ctx context.Context) []error {
 	var err error
 
 	_, err = d.aws.IAM.DetachRolePolicy(ctx, &iam.DetachRolePolicyInput{
-		PolicyArn: d.Role.Policy.Arn,
-		RoleName:  d.Role.Role.RoleName,
+		PolicyArn: role.Policy.Arn,
+		RoleName:  role.Role.RoleName,
 	})
 	if err != nil {
 		errors = append(errors, err)
 	}
 
 	_, err = d.aws.IAM.DeletePolicy(ctx, &iam.DeletePolicyInput{
-		PolicyArn: d.Role.Policy.Arn,
+		PolicyArn: role.Policy.Arn,
 	})
 	if err != nil {
 		errors = append(errors, err)
 	}
 
 	_, err = d.aws.IAM.DeleteRole(ctx, &iam.DeleteRoleInput{
-		RoleName: d.Role.Role.RoleName,
+		RoleName: role.Role.RoleName,
 	})
 	if err != nil {
 		errors = append(errors, err)
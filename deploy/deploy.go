@@ -4,9 +4,11 @@ import (
 	"archive/zip"
 	"bytes"
 	"context"
+	"encoding/json"
 	"errors"
 	"fmt"
 	"log"
+	"strconv"
 	"strings"
 	"time"
 
@@ -17,52 +19,144 @@ import (
 	"github.com/bcap/elaston/aws"
 )
 
+// ErrSignedSchedules is returned when deploying schedules along with a kms key. The
+// payload of a schedule is fixed at deploy time, so it cannot be signed with data keys
+// like the jobs sent by clients
+var ErrSignedSchedules = errors.New("schedules cannot be deployed along with a kms key")
+
 type Deployment struct {
-	ID       string
-	Function *lambda.GetFunctionOutput
-	Role     *aws.Role
-	Queue    *aws.Queue
+	ID              string
+	Function        *lambda.GetFunctionOutput
+	Role            *aws.Role
+	Queue           *aws.Queue
+	DeadLetterQueue *aws.Queue
+	Bucket          *aws.Bucket
+	SchedulerRole   *aws.Role
+	ScheduleGroup   string
 
 	aws *aws.AWS
 }
 
-func Deploy(ctx context.Context, aws *aws.AWS, name string, executable []byte, memory int32) (Deployment, error) {
+func Deploy(ctx context.Context, aws *aws.AWS, name string, executable []byte, memory int32, opts ...Option) (Deployment, error) {
+	options := defaultOptions()
+	for _, opt := range opts {
+		opt(&options)
+	}
+
+	if err := options.validate(); err != nil {
+		return Deployment{}, err
+	}
+
 	deployment := Deployment{
 		ID:  deploymentID() + "-" + name,
 		aws: aws,
 	}
 
-	queueName := "elaston-queue-" + deployment.ID
+	suffix := ""
+	if options.fifo {
+		suffix = ".fifo"
+	}
+
+	dlqName := deployment.deadLetterQueueName() + suffix
+	log.Printf("Deploying sqs dead-letter queue %s", dlqName)
+	dlq, err := deployQueue(ctx, aws, dlqName, nil, options)
+	deployment.DeadLetterQueue = dlq
+	if err != nil {
+		return deployment, err
+	}
+
+	queueName := deployment.queueName() + suffix
 	log.Printf("Deploying sqs queue %s", queueName)
-	queue, err := deployQueue(ctx, aws, queueName)
+	queue, err := deployQueue(ctx, aws, queueName, dlq, options)
 	deployment.Queue = queue
 	if err != nil {
 		return deployment, err
 	}
 
-	roleName := "elaston-lambda-role-" + deployment.ID
+	bucketName := deployment.bucketName()
+	log.Printf("Deploying s3 bucket %s", bucketName)
+	bucket, err := deployBucket(ctx, aws, bucketName)
+	deployment.Bucket = bucket
+	if err != nil {
+		return deployment, err
+	}
+
+	functionName := deployment.functionName()
+	functionARN, err := aws.LambdaFunctionARN(ctx, functionName)
+	if err != nil {
+		return deployment, err
+	}
+
+	schedulerRoleName := deployment.schedulerRoleName()
+	log.Printf("Deploying iam role and policy %s", schedulerRoleName)
+	schedulerRole, err := deploySchedulerRole(ctx, aws, schedulerRoleName, functionARN)
+	deployment.SchedulerRole = schedulerRole
+	if err != nil {
+		return deployment, err
+	}
+
+	scheduleGroup := deployment.scheduleGroupName()
+	schedulesARN, err := aws.ScheduleARN(ctx, scheduleGroup, "*")
+	if err != nil {
+		return deployment, err
+	}
+
+	roleName := deployment.roleName()
 	log.Printf("Deploying iam role and policy %s", roleName)
-	role, err := deployRole(ctx, aws, roleName)
+	role, err := deployRole(ctx, aws, roleName, roleResources{
+		functionARN:      functionARN,
+		queueARNs:        []string{queue.Attributes["QueueArn"], dlq.Attributes["QueueArn"]},
+		bucketARN:        bucket.ARN,
+		schedulesARN:     schedulesARN,
+		schedulerRoleARN: *schedulerRole.Role.Arn,
+	})
 	deployment.Role = role
 	if err != nil {
 		return deployment, err
 	}
 
-	functionName := "elaston-lambda-" + deployment.ID
+	log.Printf("Deploying schedule group %s", scheduleGroup)
+	if err := aws.CreateScheduleGroup(ctx, scheduleGroup); err != nil {
+		return deployment, err
+	}
+	deployment.ScheduleGroup = scheduleGroup
+
 	log.Printf("Deploying lambda function %s", functionName)
-	lambdaFn, err := deployLambdaFunction(ctx, aws, functionName, executable, memory, *role.Role.Arn, queue)
+	environment := functionEnvironment(&deployment, functionARN, options)
+	lambdaFn, err := deployLambdaFunction(ctx, aws, functionName, executable, memory, *role.Role.Arn, queue.Attributes["QueueArn"], environment, options)
 	deployment.Function = lambdaFn
 	if err != nil {
 		return deployment, err
 	}
 
+	for _, schedule := range options.schedules {
+		log.Printf("Deploying schedule %s (%s)", schedule.Name, schedule.Expression)
+		spec, err := awsSchedule(schedule, functionARN, *schedulerRole.Role.Arn)
+		if err != nil {
+			return deployment, err
+		}
+		if err := aws.CreateSchedule(ctx, scheduleGroup, spec); err != nil {
+			return deployment, err
+		}
+	}
+
 	log.Printf("Lambda function on AWS Console: %s", aws.LambdaFunctionConsoleURL(functionName))
 	log.Printf("Lambda function logs on AWS Console: %s", aws.LambdaFunctionLogsConsoleURL(functionName))
 
 	return deployment, nil
 }
 
-func deployRole(ctx context.Context, aws *aws.AWS, name string) (*aws.Role, error) {
+// roleResources are the resources of the deployment the function role is allowed to
+// use
+type roleResources struct {
+	functionARN      string
+	queueARNs        []string
+	bucketARN        string
+	schedulesARN     string
+	schedulerRoleARN string
+}
+
+func deployRole(ctx context.Context, aws *aws.AWS, name string, resources roleResources) (*aws.Role, error) {
 	role, err := aws.GetRole(ctx, name)
 	if err != nil {
 		return nil, err
@@ -86,37 +180,196 @@ func deployRole(ctx context.Context, aws *aws.AWS, name string) (*aws.Role, erro
 		}
 	`)
 
-	permissionPolicyDoc := strings.TrimSpace(fmt.Sprintf(`
+	permissionPolicyDoc, err := rolePolicy(resources)
+	if err != nil {
+		return nil, err
+	}
+
+	return aws.CreateRole(ctx, name, "role deployed by elaston", assumeRolePolicyDoc, permissionPolicyDoc)
+}
+
+// rolePolicy allows the function to invoke itself, use its queues, bucket and schedule
+// group, and pass the scheduler role to eventbridge scheduler only. Kms keys can be
+// given as aliases, so they are not scoped
+func rolePolicy(resources roleResources) (string, error) {
+	queueARNs, err := json.Marshal(resources.queueARNs)
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimSpace(fmt.Sprintf(`
 		{
 			"Version": "2012-10-17",
 			"Statement": [
+				{
+					"Effect": "Allow",
+					"Action": "lambda:InvokeFunction",
+					"Resource": ["%[1]s", "%[1]s:*"]
+				},
 				{
 					"Effect": "Allow",
 					"Action": [
-						"lambda:InvokeFunction",
 						"sqs:SendMessage",
 						"sqs:ReceiveMessage",
 						"sqs:DeleteMessage",
 						"sqs:GetQueueAttributes",
-						"sqs:GetQueueUrl",
+						"sqs:GetQueueUrl"
+					],
+					"Resource": %[2]s
+				},
+				{
+					"Effect": "Allow",
+					"Action": "s3:ListBucket",
+					"Resource": "%[3]s"
+				},
+				{
+					"Effect": "Allow",
+					"Action": [
+						"s3:PutObject",
+						"s3:GetObject",
+						"s3:DeleteObject"
+					],
+					"Resource": "%[3]s/*"
+				},
+				{
+					"Effect": "Allow",
+					"Action": [
+						"scheduler:CreateSchedule",
+						"scheduler:DeleteSchedule"
+					],
+					"Resource": "%[4]s"
+				},
+				{
+					"Effect": "Allow",
+					"Action": "iam:PassRole",
+					"Resource": "%[5]s",
+					"Condition": {
+						"StringEquals": {
+							"iam:PassedToService": "scheduler.amazonaws.com"
+						}
+					}
+				},
+				{
+					"Effect": "Allow",
+					"Action": [
+						"kms:GenerateDataKey",
+						"kms:Decrypt",
 						"logs:CreateLogGroup",
 						"logs:CreateLogStream",
 						"logs:PutLogEvents"
 					],
+					"Resource": "*"
+				}
+			]
+		}
+	`, resources.functionARN, queueARNs, resources.bucketARN, resources.schedulesARN, resources.schedulerRoleARN)), nil
+}
+
+// deploySchedulerRole deploys the role eventbridge scheduler assumes to invoke the function
+func deploySchedulerRole(ctx context.Context, aws *aws.AWS, name string, functionARN string) (*aws.Role, error) {
+	role, err := aws.GetRole(ctx, name)
+	if err != nil {
+		return nil, err
+	}
+	if role != nil {
+		return role, nil
+	}
+
+	assumeRolePolicyDoc := strings.TrimSpace(`
+		{
+			"Version": "2012-10-17",
+			"Statement": [
+				{
+					"Effect": "Allow",
+					"Principal": {
+						"Service": "scheduler.amazonaws.com"
+					},
+					"Action": "sts:AssumeRole"
+				}
+			]
+		}
+	`)
+
+	permissionPolicyDoc := strings.TrimSpace(fmt.Sprintf(`
+		{
+			"Version": "2012-10-17",
+			"Statement": [
+				{
+					"Effect": "Allow",
+					"Action": [
+						"lambda:InvokeFunction"
+					],
 					"Resource": "%s"
 				}
 			]
 		}
-	`, "*"))
+	`, functionARN))
 
-	return aws.CreateRole(ctx, name, "role deployed by elaston", assumeRolePolicyDoc, permissionPolicyDoc)
+	return aws.CreateRole(ctx, name, "scheduler role deployed by elaston", assumeRolePolicyDoc, permissionPolicyDoc)
+}
+
+// routeHeader and envelopeKey follow the elaston invocation format, so the runtime
+// delivers scheduled payloads to their route
+const (
+	routeHeader = "elaston-route"
+	envelopeKey = "__elaston"
+)
+
+// awsSchedule turns the schedule into one invoking the function through the role
+func awsSchedule(schedule Schedule, functionARN string, roleARN string) (aws.Schedule, error) {
+	payload, err := json.Marshal(schedule.Payload)
+	if err != nil {
+		return aws.Schedule{}, fmt.Errorf("failed to encode payload of schedule %s: %w", schedule.Name, err)
+	}
+	if schedule.Route != "" {
+		payload, err = json.Marshal(map[string]any{
+			envelopeKey: map[string]string{routeHeader: schedule.Route},
+			"payload":   json.RawMessage(payload),
+		})
+		if err != nil {
+			return aws.Schedule{}, fmt.Errorf("failed to encode payload of schedule %s: %w", schedule.Name, err)
+		}
+	}
+	return aws.Schedule{
+		Name:       schedule.Name,
+		Expression: schedule.Expression,
+		TargetARN:  functionARN,
+		RoleARN:    roleARN,
+		Input:      string(payload),
+	}, nil
 }
 
-func deployQueue(ctx context.Context, aws *aws.AWS, name string) (*aws.Queue, error) {
-	return aws.CreateQueue(ctx, name)
+// deployQueue deploys a queue moving messages to the dead-letter queue, if given,
+// once they are received too many times
+func deployQueue(ctx context.Context, aws *aws.AWS, name string, deadLetterQueue *aws.Queue, options options) (*aws.Queue, error) {
+	attributes := map[string]string{}
+	if options.fifo {
+		attributes["FifoQueue"] = "true"
+		attributes["ContentBasedDeduplication"] = strconv.FormatBool(options.contentDedup)
+	}
+	if options.timeout > 0 {
+		attributes["VisibilityTimeout"] = strconv.Itoa(int(6 * options.timeout / time.Second))
+	}
+	if deadLetterQueue != nil {
+		attributes["RedrivePolicy"] = fmt.Sprintf(
+			`{"deadLetterTargetArn":"%s","maxReceiveCount":%d}`,
+			deadLetterQueue.Attributes["QueueArn"], options.maxReceiveCount,
+		)
+	}
+	return aws.CreateQueueWithAttributes(ctx, name, attributes)
 }
 
-func deployLambdaFunction(ctx context.Context, aws *aws.AWS, name string, executable []byte, memory int32, roleARN string, queue *aws.Queue) (*lambda.GetFunctionOutput, error) {
+func deployBucket(ctx context.Context, aws *aws.AWS, name string) (*aws.Bucket, error) {
+	bucket, err := aws.CreateBucket(ctx, name)
+	if err != nil {
+		return nil, err
+	}
+	// Offloaded payloads are deleted once consumed, but the ones from failed or
+	// abandoned jobs would be left behind forever
+	expirations := map[string]int32{"payloads/": 7, "results/": 7, "lineage/": 7, "dedup/": 7}
+	return bucket, aws.ExpireS3Objects(ctx, name, expirations)
+}
+
+func deployLambdaFunction(ctx context.Context, aws *aws.AWS, name string, executable []byte, memory int32, roleARN string, queueARN string, environment lambdaT.Environment, options options) (*lambda.GetFunctionOutput, error) {
 	function, err := aws.GetLambdaFunction(ctx, name)
 	if err != nil {
 		return nil, err
@@ -133,8 +386,6 @@ func deployLambdaFunction(ctx context.Context, aws *aws.AWS, name string, execut
 	// Unforunately lambda functions only support x86_64 for golang
 	arch := []lambdaT.Architecture{"x86_64"}
 
-	queueARN := queue.Attributes["QueueArn"]
-
 	if function == nil {
 		maxWait := 10 * time.Second
 		start := time.Now()
@@ -148,13 +399,8 @@ func deployLambdaFunction(ctx context.Context, aws *aws.AWS, name string, execut
 				Runtime:       "go1.x",
 				Handler:       &handler,
 				Architectures: arch,
-				Environment: &lambdaT.Environment{
-					Variables: map[string]string{
-						"ELASTON_RUNNING_ON_LAMBDA": "",
-						"ELASTON_SQS_QUEUE_ARN":     queueARN,
-						"ELASTON_SQS_QUEUE_URL":     queue.URL,
-					},
-				},
+				Environment:   &environment,
+				Timeout:       functionTimeout(options),
 			})
 			if err == nil {
 				break
@@ -183,6 +429,8 @@ func deployLambdaFunction(ctx context.Context, aws *aws.AWS, name string, execut
 			MemorySize:   &memory,
 			Role:         &roleARN,
 			Handler:      &handler,
+			Environment:  &environment,
+			Timeout:      functionTimeout(options),
 		})
 		if err != nil {
 			return nil, err
@@ -204,13 +452,58 @@ func deployLambdaFunction(ctx context.Context, aws *aws.AWS, name string, execut
 		return lambdaFn, err
 	}
 
-	if _, err := deployQueueTrigger(ctx, aws, name, queueARN); err != nil {
+	if _, err := deployQueueTrigger(ctx, aws, name, queueARN, options); err != nil {
 		return lambdaFn, err
 	}
 
 	return lambdaFn, nil
 }
 
+// functionTimeout returns the function timeout in seconds, nil to leave the default
+func functionTimeout(options options) *int32 {
+	if options.timeout <= 0 {
+		return nil
+	}
+	seconds := int32(options.timeout / time.Second)
+	return &seconds
+}
+
+// functionEnvironment returns the environment variables configuring the runtime
+func functionEnvironment(deployment *Deployment, functionARN string, options options) lambdaT.Environment {
+	environment := lambdaT.Environment{
+		Variables: map[string]string{
+			"ELASTON_RUNNING_ON_LAMBDA":  "",
+			"ELASTON_SQS_QUEUE_ARN":      deployment.Queue.Attributes["QueueArn"],
+			"ELASTON_SQS_QUEUE_URL":      deployment.Queue.URL,
+			"ELASTON_S3_BUCKET":          deployment.Bucket.Name,
+			"ELASTON_FUNCTION_ARN":       functionARN,
+			"ELASTON_SCHEDULE_GROUP":     deployment.ScheduleGroup,
+			"ELASTON_SCHEDULER_ROLE_ARN": *deployment.SchedulerRole.Role.Arn,
+		},
+	}
+	if options.maxDepth > 0 {
+		environment.Variables["ELASTON_MAX_DEPTH"] = strconv.Itoa(options.maxDepth)
+	} else if options.maxDepth < 0 {
+		environment.Variables["ELASTON_MAX_DEPTH"] = "0"
+	}
+	if options.maxDescendants > 0 {
+		environment.Variables["ELASTON_MAX_DESCENDANTS"] = strconv.FormatInt(options.maxDescendants, 10)
+	}
+	if options.stdoutTracing {
+		environment.Variables["ELASTON_TRACE_EXPORTER"] = "stdout"
+	}
+	if options.kmsKeyID != "" {
+		environment.Variables["ELASTON_KMS_KEY_ID"] = options.kmsKeyID
+	}
+	if options.maxReceiveCount > 0 {
+		environment.Variables["ELASTON_MAX_RECEIVE_COUNT"] = strconv.Itoa(options.maxReceiveCount)
+	}
+	if options.batchParallelism > 0 {
+		environment.Variables["ELASTON_BATCH_PARALLELISM"] = strconv.Itoa(options.batchParallelism)
+	}
+	return environment
+}
+
 func waitLambdaDeployment(ctx context.Context, aws *aws.AWS, name string) (*lambda.GetFunctionOutput, error) {
 	for {
 		lambdaFn, err := aws.GetLambdaFunction(ctx, name)
@@ -238,13 +531,20 @@ func waitLambdaDeployment(ctx context.Context, aws *aws.AWS, name string) (*lamb
 	}
 }
 
-func deployQueueTrigger(ctx context.Context, aws *aws.AWS, name string, queueARN string) (*lambda.CreateEventSourceMappingOutput, error) {
-	var batchSize int32 = 1
-	return aws.Lambda.CreateEventSourceMapping(ctx, &lambda.CreateEventSourceMappingInput{
+func deployQueueTrigger(ctx context.Context, aws *aws.AWS, name string, queueARN string, options options) (*lambda.CreateEventSourceMappingOutput, error) {
+	input := lambda.CreateEventSourceMappingInput{
 		FunctionName:   &name,
-		BatchSize:      &batchSize,
+		BatchSize:      &options.batchSize,
 		EventSourceArn: &queueARN,
-	})
+		// The runtime reports which messages failed so only those are retried
+		FunctionResponseTypes: []lambdaT.FunctionResponseType{lambdaT.FunctionResponseTypeReportBatchItemFailures},
+	}
+	// Fifo queues reject any batching window
+	if !options.fifo {
+		batchWindow := int32(options.batchWindow / time.Second)
+		input.MaximumBatchingWindowInSeconds = &batchWindow
+	}
+	return aws.Lambda.CreateEventSourceMapping(ctx, &input)
 }
 
 func zipExecutable(name string, data []byte) ([]byte, error) {
@@ -268,6 +568,15 @@ func zipExecutable(name string, data []byte) ([]byte, error) {
 	return buf.Bytes(), nil
 }
 
+// Names of the resources of the deployment
+func (d *Deployment) functionName() string        { return "elaston-lambda-" + d.ID }
+func (d *Deployment) queueName() string           { return "elaston-queue-" + d.ID }
+func (d *Deployment) deadLetterQueueName() string { return "elaston-dlq-" + d.ID }
+func (d *Deployment) bucketName() string          { return "elaston-bucket-" + strings.ToLower(d.ID) }
+func (d *Deployment) roleName() string            { return "elaston-lambda-role-" + d.ID }
+func (d *Deployment) schedulerRoleName() string   { return "elaston-scheduler-role-" + d.ID }
+func (d *Deployment) scheduleGroupName() string   { return "elaston-schedules-" + d.ID }
+
 func deploymentID() string {
 	formatter, err := strftime.New("%y%m%d-%H%M%S%L", strftime.WithMilliseconds('L'))
 	if err != nil {
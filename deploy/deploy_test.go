@@ -0,0 +1,142 @@
+package deploy
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"reflect"
+	"testing"
+
+	"github.com/bcap/elaston/elastontest"
+)
+
+func TestAWSSchedule(t *testing.T) {
+	tests := []struct {
+		name     string
+		schedule Schedule
+		input    string
+	}{
+		{name: "default route", schedule: Schedule{Name: "hourly", Payload: map[string]int{"n": 1}}, input: `{"n":1}`},
+		{name: "no payload", schedule: Schedule{Name: "hourly"}, input: `null`},
+		{
+			name:     "route",
+			schedule: Schedule{Name: "hourly", Route: "report", Payload: "daily"},
+			input:    `{"__elaston":{"elaston-route":"report"},"payload":"daily"}`,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.schedule.Expression = "rate(1 hour)"
+			schedule, err := awsSchedule(tt.schedule, "function", "role")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if schedule.Input != tt.input {
+				t.Fatalf("expected input %s, got %s", tt.input, schedule.Input)
+			}
+			if schedule.TargetARN != "function" || schedule.RoleARN != "role" || schedule.Expression != "rate(1 hour)" {
+				t.Fatalf("unexpected schedule %+v", schedule)
+			}
+		})
+	}
+}
+
+func TestRolePolicy(t *testing.T) {
+	resources := roleResources{
+		functionARN:      "arn:aws:lambda:us-east-1:000000000000:function:fn",
+		queueARNs:        []string{"arn:aws:sqs:us-east-1:000000000000:queue", "arn:aws:sqs:us-east-1:000000000000:dlq"},
+		bucketARN:        "arn:aws:s3:::bucket",
+		schedulesARN:     "arn:aws:scheduler:us-east-1:000000000000:schedule/group/*",
+		schedulerRoleARN: "arn:aws:iam::000000000000:role/scheduler",
+	}
+	doc, err := rolePolicy(resources)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var policy struct {
+		Statement []struct {
+			Action    any
+			Resource  any
+			Condition map[string]map[string]string
+		}
+	}
+	if err := json.Unmarshal([]byte(doc), &policy); err != nil {
+		t.Fatalf("invalid policy document: %v\n%s", err, doc)
+	}
+
+	// Every action is only allowed on the resources of the deployment
+	resourcesOf := map[string]any{}
+	conditions := map[string]map[string]map[string]string{}
+	for _, statement := range policy.Statement {
+		actions, ok := statement.Action.([]any)
+		if !ok {
+			actions = []any{statement.Action}
+		}
+		for _, action := range actions {
+			resourcesOf[action.(string)] = statement.Resource
+			conditions[action.(string)] = statement.Condition
+		}
+	}
+	expected := map[string]any{
+		"lambda:InvokeFunction":    []any{resources.functionARN, resources.functionARN + ":*"},
+		"sqs:SendMessage":          []any{resources.queueARNs[0], resources.queueARNs[1]},
+		"s3:ListBucket":            resources.bucketARN,
+		"s3:GetObject":             resources.bucketARN + "/*",
+		"scheduler:CreateSchedule": resources.schedulesARN,
+		"iam:PassRole":             resources.schedulerRoleARN,
+	}
+	for action, resource := range expected {
+		if !reflect.DeepEqual(resourcesOf[action], resource) {
+			t.Fatalf("expected %s to be allowed on %v, got %v", action, resource, resourcesOf[action])
+		}
+	}
+	if service := conditions["iam:PassRole"]["StringEquals"]["iam:PassedToService"]; service != "scheduler.amazonaws.com" {
+		t.Fatalf("expected the scheduler role to only be passed to eventbridge scheduler, got %q", service)
+	}
+}
+
+func TestValidateOptions(t *testing.T) {
+	tests := []struct {
+		name            string
+		options         []Option
+		maxReceiveCount int
+		err             bool
+	}{
+		{name: "defaults", maxReceiveCount: 5},
+		{name: "max receive count", options: []Option{WithMaxReceiveCount(1000)}, maxReceiveCount: 1000},
+		{name: "zero max receive count", options: []Option{WithMaxReceiveCount(0)}, maxReceiveCount: 5},
+		{name: "negative max receive count", options: []Option{WithMaxReceiveCount(-1)}, err: true},
+		{name: "max receive count over the sqs limit", options: []Option{WithMaxReceiveCount(1001)}, err: true},
+		{name: "unsigned schedules", options: []Option{WithSchedule("hourly", "rate(1 hour)", nil)}, maxReceiveCount: 5},
+		{name: "signed schedules", options: []Option{WithKMSKey("key"), WithSchedule("hourly", "rate(1 hour)", nil)}, err: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			options := defaultOptions()
+			for _, opt := range tt.options {
+				opt(&options)
+			}
+			err := options.validate()
+			if (err != nil) != tt.err {
+				t.Fatalf("expected an error to be %v, got %v", tt.err, err)
+			}
+			if !tt.err && options.maxReceiveCount != tt.maxReceiveCount {
+				t.Fatalf("expected a max receive count of %d, got %d", tt.maxReceiveCount, options.maxReceiveCount)
+			}
+		})
+	}
+}
+
+func TestDeployRejectsSignedSchedules(t *testing.T) {
+	fakes := elastontest.New()
+	_, err := Deploy(
+		context.Background(), fakes.AWS(), "test", nil, 128,
+		WithKMSKey("key"), WithSchedule("hourly", "rate(1 hour)", nil),
+	)
+	if !errors.Is(err, ErrSignedSchedules) {
+		t.Fatalf("expected %v, got %v", ErrSignedSchedules, err)
+	}
+	if calls := len(fakes.SQS.Calls()) + len(fakes.IAM.Calls()) + len(fakes.Lambda.Calls()); calls != 0 {
+		t.Fatalf("expected nothing to be deployed, got %d calls", calls)
+	}
+}
@@ -0,0 +1,139 @@
+package deploy
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"path"
+	"sort"
+	"strings"
+
+	"github.com/aws/aws-sdk-go-v2/service/lambda"
+	sqsT "github.com/aws/aws-sdk-go-v2/service/sqs/types"
+
+	"github.com/bcap/elaston/aws"
+)
+
+var ErrNotFound = errors.New("deployment not found")
+
+// Name returns the name given to Deploy
+func (d *Deployment) Name() string {
+	// The id is made of the deploy date, time and the name
+	parts := strings.SplitN(d.ID, "-", 3)
+	if len(parts) < 3 {
+		return d.ID
+	}
+	return parts[2]
+}
+
+// Environment returns the environment variables configuring the function runtime
+func (d *Deployment) Environment() map[string]string {
+	if d.Function == nil || d.Function.Configuration == nil || d.Function.Configuration.Environment == nil {
+		return map[string]string{}
+	}
+	return d.Function.Configuration.Environment.Variables
+}
+
+// List returns every deployment in the region, oldest first. Only the ID and
+// Function of each are filled, see Find for loading the other resources
+func List(ctx context.Context, aws *aws.AWS) ([]Deployment, error) {
+	functions, err := aws.ListLambdaFunctions(ctx)
+	if err != nil {
+		return nil, err
+	}
+	deployments := []Deployment{}
+	for i := range functions {
+		name := *functions[i].FunctionName
+		id, ok := strings.CutPrefix(name, "elaston-lambda-")
+		if !ok {
+			continue
+		}
+		deployments = append(deployments, Deployment{
+			ID:       id,
+			Function: &lambda.GetFunctionOutput{Configuration: &functions[i]},
+			aws:      aws,
+		})
+	}
+	// Ids start with the deploy time
+	sort.Slice(deployments, func(i, j int) bool { return deployments[i].ID < deployments[j].ID })
+	return deployments, nil
+}
+
+// Find returns the latest deployment with the given name, or the one with the given
+// id, loading all of its resources. Resources that no longer exist are left nil
+func Find(ctx context.Context, aws *aws.AWS, name string) (Deployment, error) {
+	deployments, err := List(ctx, aws)
+	if err != nil {
+		return Deployment{}, err
+	}
+	var found *Deployment
+	for i := range deployments {
+		if deployments[i].ID == name || deployments[i].Name() == name {
+			found = &deployments[i]
+		}
+	}
+	if found == nil {
+		return Deployment{}, fmt.Errorf("%w: %s", ErrNotFound, name)
+	}
+	return load(ctx, aws, found.ID)
+}
+
+// load rebuilds the deployment with the given id from the function and its
+// environment
+func load(ctx context.Context, aws *aws.AWS, id string) (Deployment, error) {
+	deployment := Deployment{ID: id, aws: aws}
+
+	function, err := aws.GetLambdaFunction(ctx, deployment.functionName())
+	if err != nil {
+		return deployment, err
+	}
+	if function == nil {
+		return deployment, fmt.Errorf("%w: %s", ErrNotFound, id)
+	}
+	deployment.Function = function
+	environment := deployment.Environment()
+
+	if url := environment["ELASTON_SQS_QUEUE_URL"]; url != "" {
+		queueName := path.Base(url)
+		if deployment.Queue, err = findQueue(ctx, aws, queueName); err != nil {
+			return deployment, err
+		}
+		suffix := ""
+		if strings.HasSuffix(queueName, ".fifo") {
+			suffix = ".fifo"
+		}
+		if deployment.DeadLetterQueue, err = findQueue(ctx, aws, deployment.deadLetterQueueName()+suffix); err != nil {
+			return deployment, err
+		}
+	}
+
+	if bucket := environment["ELASTON_S3_BUCKET"]; bucket != "" {
+		deployment.Bucket = existingBucket(bucket)
+	}
+
+	if deployment.Role, err = aws.GetRole(ctx, deployment.roleName()); err != nil {
+		return deployment, err
+	}
+	if deployment.SchedulerRole, err = aws.GetRole(ctx, deployment.schedulerRoleName()); err != nil {
+		return deployment, err
+	}
+	deployment.ScheduleGroup = environment["ELASTON_SCHEDULE_GROUP"]
+
+	return deployment, nil
+}
+
+func findQueue(ctx context.Context, aws *aws.AWS, name string) (*aws.Queue, error) {
+	queue, err := aws.GetQueue(ctx, name)
+	if err != nil {
+		var notFound *sqsT.QueueDoesNotExist
+		if errors.As(err, &notFound) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	return queue, nil
+}
+
+func existingBucket(name string) *aws.Bucket {
+	return &aws.Bucket{Name: name, ARN: "arn:aws:s3:::" + name}
+}
@@ -0,0 +1,164 @@
+package deploy
+
+import (
+	"fmt"
+	"time"
+)
+
+type Option func(*options)
+
+type options struct {
+	batchSize        int32
+	batchWindow      time.Duration
+	batchParallelism int
+	kmsKeyID         string
+	stdoutTracing    bool
+	maxDepth         int
+	maxDescendants   int64
+	schedules        []Schedule
+	fifo             bool
+	contentDedup     bool
+	maxReceiveCount  int
+	timeout          time.Duration
+}
+
+// defaultMaxReceiveCount gives jobs a few attempts before they are dead-lettered
+const defaultMaxReceiveCount = 5
+
+func defaultOptions() options {
+	return options{
+		batchSize:       1,
+		maxReceiveCount: defaultMaxReceiveCount,
+	}
+}
+
+// validate checks the options can be deployed together, filling in defaults
+func (o *options) validate() error {
+	// sqs redrive policies only accept counts between 1 and 1000
+	if o.maxReceiveCount == 0 {
+		o.maxReceiveCount = defaultMaxReceiveCount
+	}
+	if o.maxReceiveCount < 1 || o.maxReceiveCount > 1000 {
+		return fmt.Errorf("invalid max receive count %d, it must be between 1 and 1000", o.maxReceiveCount)
+	}
+	// The runtime rejects anything unsigned, and schedule payloads cannot be signed
+	if o.kmsKeyID != "" && len(o.schedules) > 0 {
+		return ErrSignedSchedules
+	}
+	return nil
+}
+
+// WithBatchSize sets the maximum number of sqs messages delivered to a single lambda
+// invocation. Standard queues require a batching window for sizes above 10
+func WithBatchSize(size int32) Option {
+	return func(o *options) {
+		o.batchSize = size
+	}
+}
+
+// WithBatchWindow sets how long lambda waits gathering sqs messages before invoking
+// the function with a batch. It is rounded down to whole seconds
+func WithBatchWindow(window time.Duration) Option {
+	return func(o *options) {
+		o.batchWindow = window
+	}
+}
+
+// WithBatchParallelism sets how many messages of a batch the runtime processes at
+// the same time
+func WithBatchParallelism(parallelism int) Option {
+	return func(o *options) {
+		o.batchParallelism = parallelism
+	}
+}
+
+// WithKMSKey makes the runtime sign and encrypt payloads with data keys from the given
+// kms key, rejecting anything that is not signed. Clients need to be configured
+// with elaston.WithSecurity and an elaston.KMSKeyProvider for the same key
+func WithKMSKey(keyID string) Option {
+	return func(o *options) {
+		o.kmsKeyID = keyID
+	}
+}
+
+// WithStdoutTracing makes the runtime export OpenTelemetry spans to stdout, ending
+// up in the function cloudwatch logs
+func WithStdoutTracing() Option {
+	return func(o *options) {
+		o.stdoutTracing = true
+	}
+}
+
+// WithMaxDepth limits how long chains of jobs spawned by handlers can get. Set it to a
+// negative value to disable the check
+func WithMaxDepth(depth int) Option {
+	return func(o *options) {
+		o.maxDepth = depth
+	}
+}
+
+// WithMaxDescendants limits how many jobs can be spawned, directly or indirectly,
+// from a single root job
+func WithMaxDescendants(descendants int64) Option {
+	return func(o *options) {
+		o.maxDescendants = descendants
+	}
+}
+
+// WithFIFO makes the deployment use a fifo queue, where jobs in the same message
+// group are processed in order. With contentBasedDeduplication sqs deduplicates
+// messages by a hash of their body, otherwise every submission needs a
+// deduplication id, see elaston.WithDeduplicationID. Fifo queues do not support
+// batching windows nor batches of more than 10 messages
+func WithFIFO(contentBasedDeduplication bool) Option {
+	return func(o *options) {
+		o.fifo = true
+		o.contentDedup = contentBasedDeduplication
+	}
+}
+
+// WithMaxReceiveCount sets how many times a message is received, and so how many
+// times its job is attempted, before it is moved to the dead-letter queue. Between 1
+// and 1000, defaults to 5
+func WithMaxReceiveCount(count int) Option {
+	return func(o *options) {
+		o.maxReceiveCount = count
+	}
+}
+
+// WithTimeout sets for how long a single invocation of the function can run, up to 15
+// minutes. Defaults to the lambda default of 3 seconds. The queue visibility timeout
+// is set to 6 times it, as recommended for sqs triggers
+func WithTimeout(timeout time.Duration) Option {
+	return func(o *options) {
+		o.timeout = timeout
+	}
+}
+
+// Schedule is a recurring job invoking the function with a fixed payload
+type Schedule struct {
+	Name string
+	// Expression is a rate or cron expression as supported by eventbridge scheduler,
+	// eg rate(1 hour) or cron(0 8 * * ? *), evaluated in UTC. One-time at
+	// expressions are also accepted
+	Expression string
+	// Route is the route of an elaston.Router the payload is delivered to. Empty for
+	// the default route
+	Route string
+	// Payload is encoded as json and given to the handler as input
+	Payload any
+}
+
+// WithSchedule makes the deployment provision a schedule invoking the function with
+// the payload. Can be given multiple times. Schedules cannot be combined with
+// WithKMSKey, as their payload is fixed at deploy time and cannot be signed
+func WithSchedule(name string, expression string, payload any) Option {
+	return WithRoutedSchedule(name, expression, "", payload)
+}
+
+// WithRoutedSchedule is like WithSchedule, delivering the payload to the given route
+func WithRoutedSchedule(name string, expression string, route string, payload any) Option {
+	return func(o *options) {
+		o.schedules = append(o.schedules, Schedule{Name: name, Expression: expression, Route: route, Payload: payload})
+	}
+}
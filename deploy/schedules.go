@@ -0,0 +1,30 @@
+package deploy
+
+import (
+	"context"
+
+	schedulerT "github.com/aws/aws-sdk-go-v2/service/scheduler/types"
+
+	"github.com/bcap/elaston/aws"
+)
+
+// ListSchedules returns the schedules in a deployment schedule group, both the
+// recurring ones provisioned by Deploy and the one-time ones created by
+// elaston.SubmitAfter for long delays
+func ListSchedules(ctx context.Context, aws *aws.AWS, group string) ([]schedulerT.ScheduleSummary, error) {
+	return aws.ListSchedules(ctx, group)
+}
+
+// CancelSchedule deletes a schedule from a deployment schedule group. Cancelling a
+// one-time schedule drops the delayed job it would deliver
+func CancelSchedule(ctx context.Context, aws *aws.AWS, group string, name string) error {
+	return aws.DeleteSchedule(ctx, group, name)
+}
+
+func (d *Deployment) Schedules(ctx context.Context) ([]schedulerT.ScheduleSummary, error) {
+	return ListSchedules(ctx, d.aws, d.ScheduleGroup)
+}
+
+func (d *Deployment) CancelSchedule(ctx context.Context, name string) error {
+	return CancelSchedule(ctx, d.aws, d.ScheduleGroup, name)
+}
@@ -0,0 +1,354 @@
+package elaston
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-lambda-go/events"
+	sqsT "github.com/aws/aws-sdk-go-v2/service/sqs/types"
+
+	"github.com/bcap/elaston/aws"
+)
+
+// deadLetterVisibility is how long messages being inspected or redriven are hidden
+// from other consumers of the dead-letter queue. It is extended for as long as they
+// are held, see heldMessages
+const deadLetterVisibility = 60
+
+var ErrNoDeadLetterQueue = errors.New("queue has no dead-letter queue")
+
+// ErrSkipDeadLetter can be returned by a Redrive edit function to leave the message
+// in the dead-letter queue
+var ErrSkipDeadLetter = errors.New("dead letter skipped")
+
+// DeadLetter is a job that failed too many times and was moved to the dead-letter queue
+type DeadLetter struct {
+	MessageID string
+	// ReceiveCount is how many times the message was received, counting the receives
+	// from the dead-letter queue itself
+	ReceiveCount int
+	SentAt       time.Time
+	// Headers are the elaston headers the job was submitted with
+	Headers map[string]string
+	// Payload is the job input encoded with Codec. If the message could not be decoded,
+	// eg because it is encrypted with a key that is not available, Payload is the raw
+	// message body and Err tells why
+	Payload []byte
+	Codec   Codec
+	Err     error
+
+	receiptHandle string
+	body          string
+	attributes    map[string]string
+	groupID       string
+}
+
+// Decode decodes the job input into out
+func (d *DeadLetter) Decode(out any) error {
+	if d.Err != nil {
+		return d.Err
+	}
+	return decode(d.Codec, d.Payload, out)
+}
+
+// SetInput replaces the job input. Redrive encodes it with the client compression
+// and security in place of the original input
+func (d *DeadLetter) SetInput(in any) error {
+	if d.Codec == nil {
+		d.Codec = JSON
+	}
+	payload, err := d.Codec.Marshal(in)
+	if err != nil {
+		return err
+	}
+	d.Payload = payload
+	d.Err = nil
+	return nil
+}
+
+// DeadLetters returns up to max messages from the dead-letter queue of the client
+// queue, without removing them
+func (e *Elaston) DeadLetters(ctx context.Context, max int) ([]DeadLetter, error) {
+	dlqURL, err := e.deadLetterQueueURL(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	letters := []DeadLetter{}
+	// Received messages stay hidden while inspecting so they are not returned twice,
+	// becoming visible again at the end
+	held := e.holdMessages(ctx, dlqURL)
+	defer held.release()
+	for len(letters) < max {
+		count := max - len(letters)
+		if count > maxBatchEntries {
+			count = maxBatchEntries
+		}
+		msgs, err := e.aws.ReceiveSQS(ctx, dlqURL, int32(count), deadLetterVisibility)
+		if err != nil {
+			return letters, err
+		}
+		if len(msgs) == 0 {
+			break
+		}
+		for _, msg := range msgs {
+			held.add(*msg.MessageId, *msg.ReceiptHandle)
+			letters = append(letters, e.deadLetter(ctx, msg))
+		}
+	}
+	return letters, nil
+}
+
+// Redrive moves up to max messages, or all with a zero max, from the dead-letter
+// queue back to the client queue, returning how many were moved. The optional edit
+// function can inspect each message, change its input with SetInput or return
+// ErrSkipDeadLetter to leave it in the dead-letter queue. Any other error stops
+// the redrive.
+//
+// Redriven jobs get a fresh start: the deadline they were submitted with is dropped
+// and they are signed again. Messages that cannot be decoded, eg for lack of access
+// to their encryption key, are moved as they are
+func (e *Elaston) Redrive(ctx context.Context, max int, edit func(context.Context, *DeadLetter) error) (int, error) {
+	dlqURL, err := e.deadLetterQueueURL(ctx)
+	if err != nil {
+		return 0, err
+	}
+
+	// Every received message stays hidden until the end, even while the edit function
+	// takes its time, so skipped messages are not received again
+	held := e.holdMessages(ctx, dlqURL)
+	defer held.release()
+
+	moved := 0
+	for max <= 0 || moved < max {
+		count := maxBatchEntries
+		if max > 0 && max-moved < count {
+			count = max - moved
+		}
+		msgs, err := e.aws.ReceiveSQS(ctx, dlqURL, int32(count), deadLetterVisibility)
+		if err != nil {
+			return moved, err
+		}
+		if len(msgs) == 0 {
+			break
+		}
+		for _, msg := range msgs {
+			held.add(*msg.MessageId, *msg.ReceiptHandle)
+		}
+		for _, msg := range msgs {
+			letter := e.deadLetter(ctx, msg)
+			if edit != nil {
+				if err := edit(ctx, &letter); errors.Is(err, ErrSkipDeadLetter) {
+					continue
+				} else if err != nil {
+					return moved, err
+				}
+			}
+			if err := e.redrive(ctx, letter); err != nil {
+				return moved, err
+			}
+			if err := e.aws.DeleteSQS(ctx, dlqURL, letter.receiptHandle); err != nil {
+				return moved, err
+			}
+			held.remove(letter.receiptHandle)
+			moved++
+		}
+	}
+	return moved, nil
+}
+
+// PurgeDeadLetters deletes every message in the dead-letter queue of the client queue
+func (e *Elaston) PurgeDeadLetters(ctx context.Context) error {
+	dlqURL, err := e.deadLetterQueueURL(ctx)
+	if err != nil {
+		return err
+	}
+	return e.aws.PurgeQueue(ctx, dlqURL)
+}
+
+func (e *Elaston) deadLetterQueueURL(ctx context.Context) (string, error) {
+	if e.local != nil {
+		return "", fmt.Errorf("dead-letter operations are %w, see Local.DeadLetters", errLocalUnsupported)
+	}
+	dlqURL, err := e.aws.DeadLetterQueueURL(ctx, e.sqsQueueURL)
+	if err != nil {
+		return "", err
+	}
+	if dlqURL == "" {
+		return "", ErrNoDeadLetterQueue
+	}
+	return dlqURL, nil
+}
+
+// deadLetter decodes a message received from the dead-letter queue
+func (e *Elaston) deadLetter(ctx context.Context, msg sqsT.Message) DeadLetter {
+	letter := DeadLetter{
+		MessageID:     *msg.MessageId,
+		receiptHandle: *msg.ReceiptHandle,
+		body:          *msg.Body,
+		attributes:    aws.StringAttributes(msg.MessageAttributes),
+		groupID:       msg.Attributes["MessageGroupId"],
+		Payload:       []byte(*msg.Body),
+	}
+	letter.ReceiveCount, _ = strconv.Atoi(msg.Attributes["ApproximateReceiveCount"])
+	if sentAt, err := strconv.ParseInt(msg.Attributes["SentTimestamp"], 10, 64); err == nil {
+		letter.SentAt = time.UnixMilli(sentAt)
+	}
+
+	sqsMessage := events.SQSMessage{
+		MessageId:         letter.MessageID,
+		Body:              letter.body,
+		MessageAttributes: map[string]events.SQSMessageAttribute{},
+	}
+	for key, value := range letter.attributes {
+		value := value
+		sqsMessage.MessageAttributes[key] = events.SQSMessageAttribute{StringValue: &value, DataType: "String"}
+	}
+	decoded, err := messageFromSQS(&sqsMessage)
+	if err != nil {
+		letter.Err = err
+		return letter
+	}
+	letter.Headers = decoded.headers
+
+	// The offloaded payload is left in place, as it is still needed when moving the
+	// original message back
+	if decoded, _, err = e.resolve(ctx, decoded); err == nil {
+		if decoded, err = e.security.open(ctx, decoded); err == nil {
+			decoded, err = decompress(decoded)
+		}
+	}
+	if err == nil {
+		letter.Codec, err = codecFor(decoded.headers)
+	}
+	if err != nil {
+		letter.Err = err
+		return letter
+	}
+	letter.Payload = decoded.body
+	return letter
+}
+
+// redrive sends the dead letter back to the client queue, encoded again with its
+// possibly edited input and without its deadline. Undecodable letters are sent as
+// they were
+func (e *Elaston) redrive(ctx context.Context, letter DeadLetter) error {
+	body, attributes := letter.body, letter.attributes
+	if letter.Err == nil {
+		headers := map[string]string{}
+		for key, value := range letter.Headers {
+			headers[key] = value
+		}
+		for _, key := range []string{contentTypeHeader, contentEncodingHeader, keyIDHeader, signatureHeader, encryptionHeader, offloadHeader, deadlineHeader} {
+			delete(headers, key)
+		}
+		msg := message{headers: headers, body: letter.Payload}
+		msg.setContentType(letter.Codec)
+		var err error
+		if msg, err = e.compression.compress(msg); err != nil {
+			return err
+		}
+		if msg, err = e.security.seal(ctx, msg); err != nil {
+			return err
+		}
+		if msg, err = e.offload(ctx, msg, msg.sqsSize(), maxSQSMessageSize); err != nil {
+			return err
+		}
+		body, attributes = msg.sqsMessage()
+	}
+
+	options := aws.SendOptions{}
+	if letter.groupID != "" {
+		options.GroupID = letter.groupID
+		options.DeduplicationID = "redrive-" + letter.MessageID
+	}
+	return e.retryPolicy.do(ctx, func(ctx context.Context) error {
+		_, err := e.aws.SendSQSWithOptions(ctx, e.sqsQueueURL, body, attributes, options)
+		return err
+	})
+}
+
+// heldMessages keeps received messages hidden from other consumers for as long as
+// they are held, extending their visibility timeout in the background
+type heldMessages struct {
+	elaston  *Elaston
+	queueURL string
+	// handles maps receipt handles to message ids
+	handles map[string]string
+	mutex   sync.Mutex
+	stop    chan struct{}
+	done    chan struct{}
+}
+
+func (e *Elaston) holdMessages(ctx context.Context, queueURL string) *heldMessages {
+	held := &heldMessages{
+		elaston:  e,
+		queueURL: queueURL,
+		handles:  map[string]string{},
+		stop:     make(chan struct{}),
+		done:     make(chan struct{}),
+	}
+	go held.extend(ctx)
+	return held
+}
+
+func (h *heldMessages) add(messageID string, receiptHandle string) {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+	h.handles[receiptHandle] = messageID
+}
+
+// remove stops holding a message, eg once it was deleted
+func (h *heldMessages) remove(receiptHandle string) {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+	delete(h.handles, receiptHandle)
+}
+
+func (h *heldMessages) snapshot() map[string]string {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+	handles := make(map[string]string, len(h.handles))
+	for handle, id := range h.handles {
+		handles[handle] = id
+	}
+	return handles
+}
+
+func (h *heldMessages) extend(ctx context.Context) {
+	defer close(h.done)
+	ticker := time.NewTicker(deadLetterVisibility * time.Second / 3)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-h.stop:
+			return
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		for handle, id := range h.snapshot() {
+			if err := h.elaston.aws.ChangeSQSVisibility(ctx, h.queueURL, handle, deadLetterVisibility); err != nil {
+				h.elaston.logf("failed to extend visibility of dead letter %s: %v", id, err)
+			}
+		}
+	}
+}
+
+// release makes every message still held visible again
+func (h *heldMessages) release() {
+	close(h.stop)
+	<-h.done
+	// Released even if the context is done, as the messages would otherwise stay
+	// hidden for the whole visibility timeout
+	ctx := context.Background()
+	for handle, id := range h.snapshot() {
+		if err := h.elaston.aws.ChangeSQSVisibility(ctx, h.queueURL, handle, 0); err != nil {
+			h.elaston.logf("failed to release dead letter %s: %v", id, err)
+		}
+	}
+}
@@ -0,0 +1,199 @@
+package elaston
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"testing"
+
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/aws/aws-sdk-go-v2/service/sqs"
+	sqsT "github.com/aws/aws-sdk-go-v2/service/sqs/types"
+
+	"github.com/bcap/elaston/elastontest"
+)
+
+const testDeadLetterQueueURL = "https://sqs.us-east-1.amazonaws.com/000000000000/dlq"
+
+func TestRedrive(t *testing.T) {
+	errStop := errors.New("stop")
+	tests := []struct {
+		name  string
+		edit  func(context.Context, *DeadLetter) error
+		moved int
+		err   error
+		// sent are the inputs sent back to the queue, in order
+		sent []int
+		// released is how many messages were made visible again in the dead-letter queue
+		released int
+	}{
+		{name: "as they were", moved: 3, sent: []int{1, 2, 3}},
+		{
+			name: "edited",
+			edit: func(ctx context.Context, letter *DeadLetter) error {
+				var in int
+				if err := letter.Decode(&in); err != nil {
+					return err
+				}
+				return letter.SetInput(in * 10)
+			},
+			moved: 3,
+			sent:  []int{10, 20, 30},
+		},
+		{
+			name: "skipped",
+			edit: func(ctx context.Context, letter *DeadLetter) error {
+				var in int
+				if err := letter.Decode(&in); err != nil || in == 2 {
+					return ErrSkipDeadLetter
+				}
+				return nil
+			},
+			moved:    2,
+			sent:     []int{1, 3},
+			released: 1,
+		},
+		{
+			name: "stopped",
+			edit: func(ctx context.Context, letter *DeadLetter) error {
+				var in int
+				if err := letter.Decode(&in); err != nil || in == 2 {
+					return errStop
+				}
+				return nil
+			},
+			moved:    1,
+			err:      errStop,
+			sent:     []int{1},
+			released: 2,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := context.Background()
+			fakes := elastontest.New()
+			client := newTestClient(fakes)
+			deadLetters(t, fakes, client, 1, 2, 3)
+
+			moved, err := client.Redrive(ctx, 0, tt.edit)
+			if !errors.Is(err, tt.err) {
+				t.Fatalf("expected error %v, got %v", tt.err, err)
+			}
+			if moved != tt.moved {
+				t.Fatalf("expected %d messages moved, got %d", tt.moved, moved)
+			}
+
+			sent := []int{}
+			for _, msg := range sentMessages(t, fakes) {
+				decoded, err := messageFromSQS(msg)
+				if err != nil {
+					t.Fatal(err)
+				}
+				var in int
+				if err := decode(JSON, decoded.body, &in); err != nil {
+					t.Fatal(err)
+				}
+				sent = append(sent, in)
+			}
+			if !reflect.DeepEqual(sent, tt.sent) {
+				t.Fatalf("expected inputs %v sent back, got %v", tt.sent, sent)
+			}
+			for _, call := range fakes.SQS.CallsTo("SendMessage") {
+				if url := *call.Input.(*sqs.SendMessageInput).QueueUrl; url != testQueueURL {
+					t.Fatalf("expected dead letters to be sent to %s, got %s", testQueueURL, url)
+				}
+			}
+			if deleted := len(fakes.SQS.CallsTo("DeleteMessage")); deleted != tt.moved {
+				t.Fatalf("expected %d messages deleted from the dead-letter queue, got %d", tt.moved, deleted)
+			}
+			released := 0
+			for _, call := range fakes.SQS.CallsTo("ChangeMessageVisibility") {
+				if call.Input.(*sqs.ChangeMessageVisibilityInput).VisibilityTimeout == 0 {
+					released++
+				}
+			}
+			if released != tt.released {
+				t.Fatalf("expected %d messages released, got %d", tt.released, released)
+			}
+		})
+	}
+}
+
+func TestDeadLetters(t *testing.T) {
+	fakes := elastontest.New()
+	client := newTestClient(fakes)
+	deadLetters(t, fakes, client, 1, 2)
+
+	letters, err := client.DeadLetters(context.Background(), 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(letters) != 2 {
+		t.Fatalf("expected 2 dead letters, got %d", len(letters))
+	}
+	for i, letter := range letters {
+		var in int
+		if err := letter.Decode(&in); err != nil {
+			t.Fatal(err)
+		}
+		if in != i+1 || letter.ReceiveCount != 1 || letter.MessageID == "" {
+			t.Fatalf("unexpected dead letter %+v with input %d", letter, in)
+		}
+	}
+	// Inspecting leaves the messages in the dead-letter queue
+	if deleted := len(fakes.SQS.CallsTo("DeleteMessage")); deleted != 0 {
+		t.Fatalf("expected nothing deleted, got %d messages", deleted)
+	}
+	if released := len(fakes.SQS.CallsTo("ChangeMessageVisibility")); released != 2 {
+		t.Fatalf("expected both messages released, got %d", released)
+	}
+}
+
+func TestDeadLettersWithoutQueue(t *testing.T) {
+	client := newTestClient(elastontest.New())
+	if _, err := client.DeadLetters(context.Background(), 10); !errors.Is(err, ErrNoDeadLetterQueue) {
+		t.Fatalf("expected %v, got %v", ErrNoDeadLetterQueue, err)
+	}
+}
+
+// deadLetters makes the fake queue of the client have a dead-letter queue holding the
+// jobs submitted with the given inputs, received once
+func deadLetters(t *testing.T, fakes *elastontest.Fakes, client *Elaston, inputs ...int) {
+	t.Helper()
+	for _, in := range inputs {
+		if _, err := client.Submit(context.Background(), in); err != nil {
+			t.Fatal(err)
+		}
+	}
+	msgs := []sqsT.Message{}
+	for _, msg := range sentMessages(t, fakes) {
+		msgs = append(msgs, deadLetterMessage(msg))
+	}
+	fakes.SQS.Reset()
+
+	fakes.SQS.Respond("GetQueueAttributes", &sqs.GetQueueAttributesOutput{Attributes: map[string]string{
+		string(sqsT.QueueAttributeNameRedrivePolicy): `{"deadLetterTargetArn":"arn:aws:sqs:us-east-1:000000000000:dlq","maxReceiveCount":5}`,
+	}})
+	dlqURL := testDeadLetterQueueURL
+	fakes.SQS.Respond("GetQueueUrl", &sqs.GetQueueUrlOutput{QueueUrl: &dlqURL})
+	fakes.SQS.Respond("ReceiveMessage", &sqs.ReceiveMessageOutput{Messages: msgs})
+}
+
+func deadLetterMessage(msg *events.SQSMessage) sqsT.Message {
+	receiptHandle := "receipt-" + msg.MessageId
+	attributes := map[string]sqsT.MessageAttributeValue{}
+	for key, value := range msg.MessageAttributes {
+		value := value
+		attributes[key] = sqsT.MessageAttributeValue{DataType: &value.DataType, StringValue: value.StringValue}
+	}
+	return sqsT.Message{
+		MessageId:         &msg.MessageId,
+		ReceiptHandle:     &receiptHandle,
+		Body:              &msg.Body,
+		MessageAttributes: attributes,
+		Attributes: map[string]string{
+			"ApproximateReceiveCount": "1",
+			"SentTimestamp":           "1700000000000",
+		},
+	}
+}
@@ -2,15 +2,21 @@ package elaston
 
 import (
 	"context"
-	"encoding/json"
-	"unsafe"
+	"errors"
+	"strings"
+	"time"
 
 	"github.com/aws/aws-sdk-go-v2/service/lambda"
+	lambdaT "github.com/aws/aws-sdk-go-v2/service/lambda/types"
 	"github.com/aws/aws-sdk-go-v2/service/sqs"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
 
 	"github.com/bcap/elaston/aws"
 )
 
+var ErrNoMessageGroup = errors.New("fifo queues require a message group, see WithMessageGroup")
+
 type Handler interface {
 	Handle(context.Context, *Elaston, any) (any, error)
 }
@@ -25,20 +31,52 @@ type elaston struct {
 	aws          *aws.AWS
 	functionName string
 	sqsQueueURL  string
+	results      ResultStore
+
+	invocationType lambdaT.InvocationType
+	qualifier      string
+	timeout        time.Duration
+	retryPolicy    RetryPolicy
+	codec          Codec
+	logger         Logger
+	attributes     map[string]string
+	compression    *Compression
+	security       *Security
+	tracerProvider trace.TracerProvider
+	limits         Limits
+
+	offloadBucket string
+
+	route string
+
+	batchParallelism  int
+	submitParallelism int
+
+	outbox bool
+	dedup  DedupStore
+
+	scheduling *Scheduling
+
+	messageGroupID  string
+	deduplicationID string
+
+	// maxReceiveCount is how many times the queue delivers a message before moving it
+	// to the dead-letter queue, zero when unknown
+	maxReceiveCount int
+
+	// local is set for clients of a Local backend, replacing lambda and sqs
+	local *Local
 }
 
 type Elaston struct {
 	elaston
 }
 
-type Option = func(*elaston)
-
 func New(aws *aws.AWS, functionName string, sqsQueueURL string, options ...Option) *Elaston {
-	elaston := elaston{
-		functionName: functionName,
-		sqsQueueURL:  sqsQueueURL,
-		aws:          aws,
-	}
+	elaston := defaultOptions()
+	elaston.functionName = functionName
+	elaston.sqsQueueURL = sqsQueueURL
+	elaston.aws = aws
 
 	for _, opt := range options {
 		opt(&elaston)
@@ -49,44 +87,197 @@ func New(aws *aws.AWS, functionName string, sqsQueueURL string, options ...Optio
 	}
 }
 
-func (e *Elaston) Call(ctx context.Context, in any) (any, error) {
-	var out any
-	payload, err := json.Marshal(in)
+func (e *Elaston) Call(ctx context.Context, in any, options ...Option) (any, error) {
+	return Call[any, any](ctx, e, in, options...)
+}
+
+// invoke calls the function synchronously, returning the response payload and the
+// codec it is encoded with
+func (e *Elaston) invoke(ctx context.Context, in any) (_ []byte, _ Codec, err error) {
+	ctx, cancel := e.withTimeout(ctx)
+	defer cancel()
+
+	ctx, span := e.startCallSpan(ctx)
+	defer func() { endSpan(span, err) }()
+
+	if err := e.guard(ctx); err != nil {
+		return nil, nil, err
+	}
+
+	payload, err := e.codec.Marshal(in)
 	if err != nil {
-		return out, err
+		return nil, nil, err
 	}
 
-	invocation, err := e.aws.Lambda.Invoke(ctx, &lambda.InvokeInput{
-		FunctionName: &e.functionName,
-		Payload:      payload,
+	msg := message{headers: e.headers(propagationHeaders(ctx, true)), body: payload}
+	msg.setContentType(e.codec)
+	if e.compression != nil && e.compression.Compressor != nil {
+		msg.setHeader(acceptEncodingHeader, e.compression.Compressor.Name())
+	}
+	if msg, err = e.compression.compress(msg); err != nil {
+		return nil, nil, err
+	}
+	if msg, err = e.security.seal(ctx, msg); err != nil {
+		return nil, nil, err
+	}
+	if msg, err = e.offload(ctx, msg, msg.invokeSize(), maxInvokePayloadSize); err != nil {
+		return nil, nil, err
+	}
+	if payload, err = msg.invokePayload(); err != nil {
+		return nil, nil, err
+	}
+
+	input := lambda.InvokeInput{
+		FunctionName:   &e.functionName,
+		InvocationType: e.invocationType,
+		Payload:        payload,
+	}
+	if e.qualifier != "" {
+		input.Qualifier = &e.qualifier
+	}
+
+	if err := e.countDescendants(ctx, 1); err != nil {
+		return nil, nil, err
+	}
+	var invocation *lambda.InvokeOutput
+	err = e.retryPolicy.doCall(ctx, func(ctx context.Context) error {
+		var err error
+		if e.local != nil {
+			invocation, err = e.local.invoke(ctx, &input)
+		} else {
+			// Retries are left to the retry policy, as the sdk would also retry
+			// invocations that may have run already
+			invocation, err = e.aws.Lambda.Invoke(ctx, &input, func(o *lambda.Options) {
+				o.RetryMaxAttempts = 1
+			})
+		}
+		if err == nil && invocation.FunctionError != nil {
+			err = newRemoteError(*invocation.FunctionError, invocation.Payload)
+		}
+		return err
 	})
 	if err != nil {
-		return out, err
+		// The job only counts if the function may have run
+		if class := classifyCallError(err); class != ErrorClassFunction && class != ErrorClassAmbiguous {
+			e.uncountDescendants(ctx, 1)
+		}
+		return nil, nil, err
+	}
+
+	response, pointer, err := e.resolve(ctx, messageFromInvokePayload(invocation.Payload))
+	if err != nil {
+		return nil, nil, err
 	}
+	e.release(ctx, pointer)
 
-	if err := json.Unmarshal(invocation.Payload, &out); err != nil {
-		return out, err
+	if response, err = e.security.open(ctx, response); err != nil {
+		return nil, nil, err
 	}
+	if response, err = decompress(response); err != nil {
+		return nil, nil, err
+	}
+
+	codec, err := codecFor(response.headers)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return response.body, codec, nil
+}
 
-	return out, nil
+func (e *Elaston) Submit(ctx context.Context, in any, options ...Option) (string, error) {
+	return e.with(options).send(ctx, in, nil, 0)
 }
 
-func (e *Elaston) Submit(ctx context.Context, in any) (string, error) {
-	payload, err := json.Marshal(in)
+// send submits the message to the queue, only delivering it after the delay. Inside
+// a handler the message is buffered in the job outbox instead, returning its dedup id
+// as it has no message id yet
+func (e *Elaston) send(ctx context.Context, in any, headers map[string]string, delay time.Duration) (_ string, err error) {
+	ctx, cancel := e.withTimeout(ctx)
+	defer cancel()
+
+	ctx, span := e.startSubmitSpan(ctx)
+	defer func() { endSpan(span, err) }()
+
+	// sqs rejects DelaySeconds on fifo messages, which are only delayed queue-wide
+	if delay > 0 && isFIFOQueue(e.sqsQueueURL) {
+		return "", ErrFIFODelay
+	}
+
+	outbox := outboxFromContext(ctx)
+	if !e.outbox {
+		outbox = nil
+	}
+	var dedupID string
+	if outbox != nil {
+		dedupID = outbox.nextDedupID()
+		headers = e.headers(headers, map[string]string{dedupIDHeader: dedupID})
+	}
+
+	// Delays sqs cannot handle become one-time schedules. Named after the dedup id
+	// when there is one, so retried jobs do not schedule the same message twice
+	var schedule string
+	if e.needsSchedule(delay) {
+		if e.scheduling == nil {
+			return "", ErrNoScheduling
+		}
+		id := dedupID
+		if id == "" {
+			if id, err = newID(); err != nil {
+				return "", err
+			}
+		}
+		schedule = scheduleName(id)
+		headers = e.headers(headers, map[string]string{scheduleHeader: schedule})
+	}
+
+	groupID, deduplicationID, err := e.fifoParameters(dedupID)
 	if err != nil {
 		return "", err
 	}
-	// Trick used by strings.Builder.String to convert bytes to string without copying data
-	payloadString := unsafe.String(unsafe.SliceData(payload), len(payload))
 
-	sendOut, err := e.aws.SQS.SendMessage(ctx, &sqs.SendMessageInput{
-		MessageBody: &payloadString,
-		QueueUrl:    &e.sqsQueueURL,
-	})
+	msg, err := e.encodeSQS(ctx, in, headers)
+	if err != nil {
+		return "", err
+	}
+	entry := sqsEntry{msg: msg, delay: delay, groupID: groupID, deduplicationID: deduplicationID}
+
+	if outbox != nil {
+		outbox.add(e, entry)
+		span.SetAttributes(attribute.String("elaston.dedup_id", dedupID))
+		return dedupID, nil
+	}
+
+	if err := e.countDescendants(ctx, 1); err != nil {
+		return "", err
+	}
+
+	if schedule != "" {
+		if err := e.schedule(ctx, msg, delay); err != nil {
+			e.uncountDescendants(ctx, 1)
+			return "", err
+		}
+		span.SetAttributes(attribute.String("elaston.schedule", schedule))
+		return schedule, nil
+	}
 
+	body, attributes := msg.sqsMessage()
+	var sendOut *sqs.SendMessageOutput
+	err = e.retryPolicy.do(ctx, func(ctx context.Context) error {
+		var err error
+		if e.local != nil {
+			messageID, err := e.local.send(body, attributes, entry.sendOptions())
+			sendOut = &sqs.SendMessageOutput{MessageId: &messageID}
+			return err
+		}
+		sendOut, err = e.aws.SendSQSWithOptions(ctx, e.sqsQueueURL, body, attributes, entry.sendOptions())
+		return err
+	})
 	if err != nil {
+		e.uncountDescendants(ctx, 1)
 		return "", err
 	}
+	span.SetAttributes(attribute.String("messaging.message.id", *sendOut.MessageId))
 
 	if sendOut.SequenceNumber != nil && *sendOut.SequenceNumber != "" {
 		return *sendOut.MessageId + "," + *sendOut.SequenceNumber, nil
@@ -94,3 +285,67 @@ func (e *Elaston) Submit(ctx context.Context, in any) (string, error) {
 
 	return *sendOut.MessageId, nil
 }
+
+// fifoParameters returns the message group and deduplication ids of a message sent
+// to the queue, both empty for standard queues. Without an explicit deduplication id
+// the given dedup id is used, which is empty outside handlers, leaving it to content
+// based deduplication
+func (e *Elaston) fifoParameters(dedupID string) (string, string, error) {
+	if !isFIFOQueue(e.sqsQueueURL) {
+		return "", "", nil
+	}
+	if e.messageGroupID == "" {
+		return "", "", ErrNoMessageGroup
+	}
+	if e.deduplicationID != "" {
+		return e.messageGroupID, e.deduplicationID, nil
+	}
+	return e.messageGroupID, dedupID, nil
+}
+
+func isFIFOQueue(queueURL string) bool {
+	return strings.HasSuffix(queueURL, ".fifo")
+}
+
+// encodeSQS turns the input into a message ready to be sent to the queue
+func (e *Elaston) encodeSQS(ctx context.Context, in any, headers map[string]string) (message, error) {
+	if err := e.guard(ctx); err != nil {
+		return message{}, err
+	}
+
+	payload, err := e.codec.Marshal(in)
+	if err != nil {
+		return message{}, err
+	}
+
+	msg := message{headers: e.headers(e.attributes, propagationHeaders(ctx, false), headers), body: payload}
+	msg.setContentType(e.codec)
+	if msg, err = e.compression.compress(msg); err != nil {
+		return message{}, err
+	}
+	if msg, err = e.security.seal(ctx, msg); err != nil {
+		return message{}, err
+	}
+	return e.offload(ctx, msg, msg.sqsSize(), maxSQSMessageSize)
+}
+
+// headers returns the headers every message sent by this client carries, merged with extra
+func (e *Elaston) headers(extra ...map[string]string) map[string]string {
+	headers := map[string]string{}
+	for _, values := range extra {
+		for key, value := range values {
+			headers[key] = value
+		}
+	}
+	if e.route != "" {
+		headers[routeHeader] = e.route
+	}
+	return headers
+}
+
+func (e *Elaston) withTimeout(ctx context.Context) (context.Context, context.CancelFunc) {
+	if e.timeout <= 0 {
+		return ctx, func() {}
+	}
+	return context.WithTimeout(ctx, e.timeout)
+}
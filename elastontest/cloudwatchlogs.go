@@ -0,0 +1,25 @@
+package elastontest
+
+import (
+	"context"
+
+	"github.com/aws/aws-sdk-go-v2/service/cloudwatchlogs"
+
+	"github.com/bcap/elaston/aws"
+)
+
+// CloudWatchLogs is a fake cloudwatch logs client. By default every operation
+// returns an empty output
+type CloudWatchLogs struct {
+	Recorder
+}
+
+var _ aws.CloudWatchLogsAPI = (*CloudWatchLogs)(nil)
+
+func (f *CloudWatchLogs) DescribeLogStreams(ctx context.Context, input *cloudwatchlogs.DescribeLogStreamsInput, _ ...func(*cloudwatchlogs.Options)) (*cloudwatchlogs.DescribeLogStreamsOutput, error) {
+	return call(&f.Recorder, "DescribeLogStreams", input, func() *cloudwatchlogs.DescribeLogStreamsOutput { return &cloudwatchlogs.DescribeLogStreamsOutput{} })
+}
+
+func (f *CloudWatchLogs) FilterLogEvents(ctx context.Context, input *cloudwatchlogs.FilterLogEventsInput, _ ...func(*cloudwatchlogs.Options)) (*cloudwatchlogs.FilterLogEventsOutput, error) {
+	return call(&f.Recorder, "FilterLogEvents", input, func() *cloudwatchlogs.FilterLogEventsOutput { return &cloudwatchlogs.FilterLogEventsOutput{} })
+}
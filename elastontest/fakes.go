@@ -0,0 +1,55 @@
+package elastontest
+
+import (
+	awsSDK "github.com/aws/aws-sdk-go-v2/aws"
+
+	"github.com/bcap/elaston/aws"
+)
+
+// FakeAccount is the account id of the fake caller identity
+const FakeAccount = "000000000000"
+
+// Fakes holds a fake for each service elaston talks to through interfaces
+type Fakes struct {
+	Lambda         *Lambda
+	SQS            *SQS
+	IAM            *IAM
+	STS            *STS
+	S3             *S3
+	KMS            *KMS
+	Scheduler      *Scheduler
+	CloudWatchLogs *CloudWatchLogs
+}
+
+func New() *Fakes {
+	return &Fakes{
+		Lambda:         &Lambda{},
+		SQS:            &SQS{},
+		IAM:            &IAM{},
+		STS:            &STS{},
+		S3:             &S3{},
+		KMS:            &KMS{},
+		Scheduler:      &Scheduler{},
+		CloudWatchLogs: &CloudWatchLogs{},
+	}
+}
+
+// AWS returns an aws.AWS backed by the fakes, for us-east-1. Services without a fake,
+// like ecr, are left unset
+func (f *Fakes) AWS() *aws.AWS {
+	return &aws.AWS{
+		Config:         awsSDK.Config{Region: "us-east-1"},
+		Lambda:         f.Lambda,
+		SQS:            f.SQS,
+		IAM:            f.IAM,
+		STS:            f.STS,
+		S3:             f.S3,
+		KMS:            f.KMS,
+		Scheduler:      f.Scheduler,
+		CloudWatchLogs: f.CloudWatchLogs,
+	}
+}
+
+func ptr[T any](value T) *T {
+	return &value
+}
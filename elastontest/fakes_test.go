@@ -0,0 +1,87 @@
+package elastontest
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"reflect"
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/service/kms"
+	kmsT "github.com/aws/aws-sdk-go-v2/service/kms/types"
+	"github.com/aws/aws-sdk-go-v2/service/scheduler"
+	schedulerT "github.com/aws/aws-sdk-go-v2/service/scheduler/types"
+
+	"github.com/bcap/elaston/aws"
+)
+
+func TestKMS(t *testing.T) {
+	ctx := context.Background()
+	fakes := New()
+	client := fakes.AWS()
+
+	plaintext, encrypted, err := client.GenerateDataKey(ctx, "key")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(plaintext) != 32 {
+		t.Fatalf("expected a 256 bit key, got %d bytes", len(plaintext))
+	}
+	decrypted, err := client.DecryptDataKey(ctx, encrypted)
+	if err != nil || !bytes.Equal(decrypted, plaintext) {
+		t.Fatalf("expected the data key back, got %v, %v", decrypted, err)
+	}
+
+	var invalid *kmsT.InvalidCiphertextException
+	if _, err := client.DecryptDataKey(ctx, []byte("garbage")); !errors.As(err, &invalid) {
+		t.Fatalf("expected an invalid ciphertext error, got %v", err)
+	}
+	out, err := fakes.KMS.GenerateDataKey(ctx, &kms.GenerateDataKeyInput{KeyId: ptr("key"), KeySpec: kmsT.DataKeySpecAes128})
+	if err != nil || len(out.Plaintext) != 16 {
+		t.Fatalf("expected a 128 bit key, got %v, %v", out, err)
+	}
+}
+
+func TestScheduler(t *testing.T) {
+	ctx := context.Background()
+	fakes := New()
+	client := fakes.AWS()
+
+	schedule := aws.Schedule{Name: "hourly", Expression: "rate(1 hour)", TargetARN: "function", RoleARN: "role", Input: "{}"}
+	for i := 0; i < 2; i++ {
+		// Creating an existing schedule is a no-op for the client
+		if err := client.CreateSchedule(ctx, "group", schedule); err != nil {
+			t.Fatal(err)
+		}
+	}
+	var conflict *schedulerT.ConflictException
+	if _, err := fakes.Scheduler.CreateSchedule(ctx, &scheduler.CreateScheduleInput{Name: ptr("hourly"), GroupName: ptr("group")}); !errors.As(err, &conflict) {
+		t.Fatalf("expected a conflict, got %v", err)
+	}
+	created := fakes.Scheduler.Schedule("group", "hourly")
+	if created == nil || *created.ScheduleExpression != schedule.Expression || *created.Target.Arn != schedule.TargetARN {
+		t.Fatalf("unexpected schedule %+v", created)
+	}
+	if err := client.CreateSchedule(ctx, "", aws.Schedule{Name: "daily", Expression: "rate(1 day)"}); err != nil {
+		t.Fatal(err)
+	}
+	if names := fakes.Scheduler.Schedules("default"); !reflect.DeepEqual(names, []string{"daily"}) {
+		t.Fatalf("expected schedules without a group in the default group, got %v", names)
+	}
+
+	summaries, err := client.ListSchedules(ctx, "group")
+	if err != nil || len(summaries) != 1 || *summaries[0].Target.Arn != "function" {
+		t.Fatalf("unexpected schedules %+v, %v", summaries, err)
+	}
+
+	if err := client.DeleteSchedule(ctx, "group", "hourly"); err != nil {
+		t.Fatal(err)
+	}
+	var notFound *schedulerT.ResourceNotFoundException
+	if _, err := fakes.Scheduler.DeleteSchedule(ctx, &scheduler.DeleteScheduleInput{Name: ptr("hourly"), GroupName: ptr("group")}); !errors.As(err, &notFound) {
+		t.Fatalf("expected the schedule to be gone, got %v", err)
+	}
+	if err := client.DeleteSchedule(ctx, "group", "hourly"); err != nil {
+		t.Fatalf("expected deleting a missing schedule to succeed, got %v", err)
+	}
+}
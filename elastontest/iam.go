@@ -0,0 +1,52 @@
+package elastontest
+
+import (
+	"context"
+
+	"github.com/aws/aws-sdk-go-v2/service/iam"
+
+	"github.com/bcap/elaston/aws"
+)
+
+// IAM is a fake iam client. By default every operation returns an empty output
+type IAM struct {
+	Recorder
+}
+
+var _ aws.IAMAPI = (*IAM)(nil)
+
+func (f *IAM) GetRole(ctx context.Context, input *iam.GetRoleInput, _ ...func(*iam.Options)) (*iam.GetRoleOutput, error) {
+	return call(&f.Recorder, "GetRole", input, func() *iam.GetRoleOutput { return &iam.GetRoleOutput{} })
+}
+
+func (f *IAM) CreateRole(ctx context.Context, input *iam.CreateRoleInput, _ ...func(*iam.Options)) (*iam.CreateRoleOutput, error) {
+	return call(&f.Recorder, "CreateRole", input, func() *iam.CreateRoleOutput { return &iam.CreateRoleOutput{} })
+}
+
+func (f *IAM) DeleteRole(ctx context.Context, input *iam.DeleteRoleInput, _ ...func(*iam.Options)) (*iam.DeleteRoleOutput, error) {
+	return call(&f.Recorder, "DeleteRole", input, func() *iam.DeleteRoleOutput { return &iam.DeleteRoleOutput{} })
+}
+
+func (f *IAM) GetPolicy(ctx context.Context, input *iam.GetPolicyInput, _ ...func(*iam.Options)) (*iam.GetPolicyOutput, error) {
+	return call(&f.Recorder, "GetPolicy", input, func() *iam.GetPolicyOutput { return &iam.GetPolicyOutput{} })
+}
+
+func (f *IAM) CreatePolicy(ctx context.Context, input *iam.CreatePolicyInput, _ ...func(*iam.Options)) (*iam.CreatePolicyOutput, error) {
+	return call(&f.Recorder, "CreatePolicy", input, func() *iam.CreatePolicyOutput { return &iam.CreatePolicyOutput{} })
+}
+
+func (f *IAM) DeletePolicy(ctx context.Context, input *iam.DeletePolicyInput, _ ...func(*iam.Options)) (*iam.DeletePolicyOutput, error) {
+	return call(&f.Recorder, "DeletePolicy", input, func() *iam.DeletePolicyOutput { return &iam.DeletePolicyOutput{} })
+}
+
+func (f *IAM) AttachRolePolicy(ctx context.Context, input *iam.AttachRolePolicyInput, _ ...func(*iam.Options)) (*iam.AttachRolePolicyOutput, error) {
+	return call(&f.Recorder, "AttachRolePolicy", input, func() *iam.AttachRolePolicyOutput { return &iam.AttachRolePolicyOutput{} })
+}
+
+func (f *IAM) DetachRolePolicy(ctx context.Context, input *iam.DetachRolePolicyInput, _ ...func(*iam.Options)) (*iam.DetachRolePolicyOutput, error) {
+	return call(&f.Recorder, "DetachRolePolicy", input, func() *iam.DetachRolePolicyOutput { return &iam.DetachRolePolicyOutput{} })
+}
+
+func (f *IAM) ListAttachedRolePolicies(ctx context.Context, input *iam.ListAttachedRolePoliciesInput, _ ...func(*iam.Options)) (*iam.ListAttachedRolePoliciesOutput, error) {
+	return call(&f.Recorder, "ListAttachedRolePolicies", input, func() *iam.ListAttachedRolePoliciesOutput { return &iam.ListAttachedRolePoliciesOutput{} })
+}
@@ -0,0 +1,59 @@
+package elastontest
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+
+	"github.com/aws/aws-sdk-go-v2/service/kms"
+	kmsT "github.com/aws/aws-sdk-go-v2/service/kms/types"
+
+	"github.com/bcap/elaston/aws"
+)
+
+// fakeCiphertextPrefix marks the data keys "encrypted" by the fake kms, which only
+// prepends it and the key id to the plaintext key
+const fakeCiphertextPrefix = "elastontest:"
+
+// KMS is a fake kms client. By default GenerateDataKey returns random keys whose
+// ciphertext Decrypt turns back into the plaintext. Nothing is actually encrypted,
+// so the ciphertexts must not leave tests
+type KMS struct {
+	Recorder
+}
+
+var _ aws.KMSAPI = (*KMS)(nil)
+
+func (f *KMS) GenerateDataKey(ctx context.Context, input *kms.GenerateDataKeyInput, _ ...func(*kms.Options)) (*kms.GenerateDataKeyOutput, error) {
+	return callOrFail(&f.Recorder, "GenerateDataKey", input, func() (*kms.GenerateDataKeyOutput, error) {
+		size := 32
+		if input.NumberOfBytes != nil {
+			size = int(*input.NumberOfBytes)
+		} else if input.KeySpec == kmsT.DataKeySpecAes128 {
+			size = 16
+		}
+		plaintext := make([]byte, size)
+		if _, err := rand.Read(plaintext); err != nil {
+			return nil, err
+		}
+		keyID := ""
+		if input.KeyId != nil {
+			keyID = *input.KeyId
+		}
+		ciphertext := append([]byte(fakeCiphertextPrefix+keyID+":"), plaintext...)
+		return &kms.GenerateDataKeyOutput{KeyId: &keyID, Plaintext: plaintext, CiphertextBlob: ciphertext}, nil
+	})
+}
+
+func (f *KMS) Decrypt(ctx context.Context, input *kms.DecryptInput, _ ...func(*kms.Options)) (*kms.DecryptOutput, error) {
+	return callOrFail(&f.Recorder, "Decrypt", input, func() (*kms.DecryptOutput, error) {
+		rest, ok := bytes.CutPrefix(input.CiphertextBlob, []byte(fakeCiphertextPrefix))
+		separator := bytes.IndexByte(rest, ':')
+		if !ok || separator < 0 {
+			return nil, &kmsT.InvalidCiphertextException{Message: ptr("injected by elastontest")}
+		}
+		keyID := string(rest[:separator])
+		plaintext := append([]byte{}, rest[separator+1:]...)
+		return &kms.DecryptOutput{KeyId: &keyID, Plaintext: plaintext}, nil
+	})
+}
@@ -0,0 +1,61 @@
+package elastontest
+
+import (
+	"context"
+
+	"github.com/aws/aws-sdk-go-v2/service/lambda"
+
+	"github.com/bcap/elaston/aws"
+)
+
+// Lambda is a fake lambda client. By default Invoke returns a null payload and the
+// other operations return empty outputs
+type Lambda struct {
+	Recorder
+}
+
+var _ aws.LambdaAPI = (*Lambda)(nil)
+
+func (f *Lambda) Invoke(ctx context.Context, input *lambda.InvokeInput, _ ...func(*lambda.Options)) (*lambda.InvokeOutput, error) {
+	return call(&f.Recorder, "Invoke", input, func() *lambda.InvokeOutput { return &lambda.InvokeOutput{StatusCode: 200, Payload: []byte("null")} })
+}
+
+func (f *Lambda) InvokeWithResponseStream(ctx context.Context, input *lambda.InvokeWithResponseStreamInput, _ ...func(*lambda.Options)) (*lambda.InvokeWithResponseStreamOutput, error) {
+	return call(&f.Recorder, "InvokeWithResponseStream", input, func() *lambda.InvokeWithResponseStreamOutput { return &lambda.InvokeWithResponseStreamOutput{} })
+}
+
+func (f *Lambda) GetFunction(ctx context.Context, input *lambda.GetFunctionInput, _ ...func(*lambda.Options)) (*lambda.GetFunctionOutput, error) {
+	return call(&f.Recorder, "GetFunction", input, func() *lambda.GetFunctionOutput { return &lambda.GetFunctionOutput{} })
+}
+
+func (f *Lambda) CreateFunction(ctx context.Context, input *lambda.CreateFunctionInput, _ ...func(*lambda.Options)) (*lambda.CreateFunctionOutput, error) {
+	return call(&f.Recorder, "CreateFunction", input, func() *lambda.CreateFunctionOutput { return &lambda.CreateFunctionOutput{} })
+}
+
+func (f *Lambda) UpdateFunctionConfiguration(ctx context.Context, input *lambda.UpdateFunctionConfigurationInput, _ ...func(*lambda.Options)) (*lambda.UpdateFunctionConfigurationOutput, error) {
+	return call(&f.Recorder, "UpdateFunctionConfiguration", input, func() *lambda.UpdateFunctionConfigurationOutput { return &lambda.UpdateFunctionConfigurationOutput{} })
+}
+
+func (f *Lambda) UpdateFunctionCode(ctx context.Context, input *lambda.UpdateFunctionCodeInput, _ ...func(*lambda.Options)) (*lambda.UpdateFunctionCodeOutput, error) {
+	return call(&f.Recorder, "UpdateFunctionCode", input, func() *lambda.UpdateFunctionCodeOutput { return &lambda.UpdateFunctionCodeOutput{} })
+}
+
+func (f *Lambda) DeleteFunction(ctx context.Context, input *lambda.DeleteFunctionInput, _ ...func(*lambda.Options)) (*lambda.DeleteFunctionOutput, error) {
+	return call(&f.Recorder, "DeleteFunction", input, func() *lambda.DeleteFunctionOutput { return &lambda.DeleteFunctionOutput{} })
+}
+
+func (f *Lambda) ListFunctions(ctx context.Context, input *lambda.ListFunctionsInput, _ ...func(*lambda.Options)) (*lambda.ListFunctionsOutput, error) {
+	return call(&f.Recorder, "ListFunctions", input, func() *lambda.ListFunctionsOutput { return &lambda.ListFunctionsOutput{} })
+}
+
+func (f *Lambda) CreateEventSourceMapping(ctx context.Context, input *lambda.CreateEventSourceMappingInput, _ ...func(*lambda.Options)) (*lambda.CreateEventSourceMappingOutput, error) {
+	return call(&f.Recorder, "CreateEventSourceMapping", input, func() *lambda.CreateEventSourceMappingOutput { return &lambda.CreateEventSourceMappingOutput{} })
+}
+
+func (f *Lambda) ListEventSourceMappings(ctx context.Context, input *lambda.ListEventSourceMappingsInput, _ ...func(*lambda.Options)) (*lambda.ListEventSourceMappingsOutput, error) {
+	return call(&f.Recorder, "ListEventSourceMappings", input, func() *lambda.ListEventSourceMappingsOutput { return &lambda.ListEventSourceMappingsOutput{} })
+}
+
+func (f *Lambda) DeleteEventSourceMapping(ctx context.Context, input *lambda.DeleteEventSourceMappingInput, _ ...func(*lambda.Options)) (*lambda.DeleteEventSourceMappingOutput, error) {
+	return call(&f.Recorder, "DeleteEventSourceMapping", input, func() *lambda.DeleteEventSourceMappingOutput { return &lambda.DeleteEventSourceMappingOutput{} })
+}
@@ -0,0 +1,163 @@
+// Package elastontest provides fakes of the aws services used by elaston, for unit
+// testing code built on top of it without an aws account.
+//
+// Every fake records the calls it receives and answers them with scripted responses
+// or injected failures, falling back to a default response when nothing is scripted:
+//
+//	fakes := elastontest.New()
+//	fakes.SQS.FailTimes("SendMessage", 2, elastontest.APIError("ThrottlingException"))
+//	client := elaston.New(fakes.AWS(), "function", "https://sqs.fake/queue")
+//	client.Submit(ctx, input)
+//	calls := fakes.SQS.CallsTo("SendMessage") // 3 calls, the last one succeeded
+package elastontest
+
+import (
+	"fmt"
+	"sync"
+
+	"github.com/aws/smithy-go"
+)
+
+// Call is a call received by a fake
+type Call struct {
+	Operation string
+	// Input is the input given to the operation, eg a *sqs.SendMessageInput
+	Input any
+	// Output and Err are what the fake answered with
+	Output any
+	Err    error
+}
+
+// Responder answers a call to an operation given its input
+type Responder func(input any) (any, error)
+
+// Recorder records the calls received by a fake and scripts its responses. Scripted
+// responses are consumed in order, one per call to the operation. Once they run out
+// the handler set with Handle answers, or the fake default response. It is safe for
+// concurrent use
+type Recorder struct {
+	calls    []Call
+	scripts  map[string][]Responder
+	handlers map[string]Responder
+	mutex    sync.Mutex
+}
+
+// Calls returns every call received so far, in order
+func (r *Recorder) Calls() []Call {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	return append([]Call{}, r.calls...)
+}
+
+// CallsTo returns the calls received so far to the given operation, in order
+func (r *Recorder) CallsTo(operation string) []Call {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	calls := []Call{}
+	for _, call := range r.calls {
+		if call.Operation == operation {
+			calls = append(calls, call)
+		}
+	}
+	return calls
+}
+
+// Reset forgets the calls received and every scripted response and handler
+func (r *Recorder) Reset() {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	r.calls = nil
+	r.scripts = nil
+	r.handlers = nil
+}
+
+// Script makes the next call to the operation be answered by the responder
+func (r *Recorder) Script(operation string, responder Responder) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	if r.scripts == nil {
+		r.scripts = map[string][]Responder{}
+	}
+	r.scripts[operation] = append(r.scripts[operation], responder)
+}
+
+// Respond makes the next call to the operation return the output, which must be of
+// the operation output type, eg a *sqs.SendMessageOutput
+func (r *Recorder) Respond(operation string, output any) {
+	r.Script(operation, func(any) (any, error) { return output, nil })
+}
+
+// Fail makes the next call to the operation fail with the error
+func (r *Recorder) Fail(operation string, err error) {
+	r.FailTimes(operation, 1, err)
+}
+
+// FailTimes makes the next n calls to the operation fail with the error
+func (r *Recorder) FailTimes(operation string, n int, err error) {
+	for i := 0; i < n; i++ {
+		r.Script(operation, func(any) (any, error) { return nil, err })
+	}
+}
+
+// Handle makes the calls to the operation that are not scripted be answered by the
+// responder instead of the default response
+func (r *Recorder) Handle(operation string, responder Responder) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	if r.handlers == nil {
+		r.handlers = map[string]Responder{}
+	}
+	r.handlers[operation] = responder
+}
+
+// APIError returns an error like the ones returned by aws services, which elaston
+// classifies by its code, eg ThrottlingException
+func APIError(code string) error {
+	return &smithy.GenericAPIError{Code: code, Message: "injected by elastontest", Fault: smithy.FaultServer}
+}
+
+// call records the call and answers it with the next scripted response, the
+// operation handler or the default response, in that order
+func call[Out any](r *Recorder, operation string, input any, defaultOutput func() *Out) (*Out, error) {
+	return callOrFail(r, operation, input, func() (*Out, error) { return defaultOutput(), nil })
+}
+
+// callOrFail is call for fakes whose default response can be an error, eg a missing
+// s3 object
+func callOrFail[Out any](r *Recorder, operation string, input any, defaultResponse func() (*Out, error)) (*Out, error) {
+	r.mutex.Lock()
+	var responder Responder
+	if scripts := r.scripts[operation]; len(scripts) > 0 {
+		responder = scripts[0]
+		r.scripts[operation] = scripts[1:]
+	} else {
+		responder = r.handlers[operation]
+	}
+	r.mutex.Unlock()
+
+	var output *Out
+	var err error
+	if responder == nil {
+		output, err = defaultResponse()
+	} else {
+		var response any
+		response, err = responder(input)
+		if response != nil {
+			var ok bool
+			if output, ok = response.(*Out); !ok {
+				panic(fmt.Sprintf("elastontest: response to %s must be a %T, got %T", operation, output, response))
+			}
+		}
+		if output == nil && err == nil {
+			output = new(Out)
+		}
+	}
+
+	r.mutex.Lock()
+	r.calls = append(r.calls, Call{Operation: operation, Input: input, Output: output, Err: err})
+	r.mutex.Unlock()
+	if err != nil {
+		return nil, err
+	}
+	return output, nil
+}
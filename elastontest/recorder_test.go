@@ -0,0 +1,86 @@
+package elastontest
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/service/sqs"
+)
+
+func TestRecorder(t *testing.T) {
+	ctx := context.Background()
+	fake := &SQS{}
+	scripted := "scripted"
+	handled := "handled"
+	fake.Respond("SendMessage", &sqs.SendMessageOutput{MessageId: &scripted})
+	fake.FailTimes("SendMessage", 2, APIError("ThrottlingException"))
+	fake.Handle("SendMessage", func(input any) (any, error) {
+		return &sqs.SendMessageOutput{MessageId: &handled}, nil
+	})
+
+	// Scripted responses go first and in order, then the handler answers
+	expected := []struct {
+		id  string
+		err bool
+	}{{id: scripted}, {err: true}, {err: true}, {id: handled}, {id: handled}}
+	for i, exp := range expected {
+		out, err := fake.SendMessage(ctx, &sqs.SendMessageInput{})
+		if (err != nil) != exp.err {
+			t.Fatalf("call %d: expected an error to be %v, got %v", i, exp.err, err)
+		}
+		if !exp.err && *out.MessageId != exp.id {
+			t.Fatalf("call %d: expected message id %s, got %s", i, exp.id, *out.MessageId)
+		}
+	}
+	if _, err := fake.DeleteMessage(ctx, &sqs.DeleteMessageInput{}); err != nil {
+		t.Fatal(err)
+	}
+
+	calls := fake.Calls()
+	if len(calls) != len(expected)+1 || len(fake.CallsTo("SendMessage")) != len(expected) {
+		t.Fatalf("expected %d calls, got %d", len(expected)+1, len(calls))
+	}
+	if calls[1].Err == nil || calls[0].Output.(*sqs.SendMessageOutput).MessageId != &scripted {
+		t.Fatalf("expected the calls to record their responses, got %+v", calls[:2])
+	}
+
+	// Reset forgets the calls and the handler, going back to the default response
+	fake.Reset()
+	out, err := fake.SendMessage(ctx, &sqs.SendMessageInput{})
+	if err != nil || *out.MessageId == handled {
+		t.Fatalf("expected the default response after a reset, got %v, %v", out, err)
+	}
+	if calls := fake.Calls(); len(calls) != 1 {
+		t.Fatalf("expected only the call after the reset, got %d", len(calls))
+	}
+}
+
+func TestRecorderEmptyResponse(t *testing.T) {
+	// Responders returning neither output nor error get an empty output
+	fake := &SQS{}
+	fake.Script("DeleteMessage", func(any) (any, error) { return nil, nil })
+	out, err := fake.DeleteMessage(context.Background(), &sqs.DeleteMessageInput{})
+	if err != nil || out == nil {
+		t.Fatalf("expected an empty output, got %v, %v", out, err)
+	}
+}
+
+func TestRecorderWrongResponseType(t *testing.T) {
+	fake := &SQS{}
+	fake.Respond("SendMessage", &sqs.DeleteMessageOutput{})
+	defer func() {
+		if recover() == nil {
+			t.Fatal("expected a panic for a response of the wrong type")
+		}
+	}()
+	fake.SendMessage(context.Background(), &sqs.SendMessageInput{})
+}
+
+func TestAPIError(t *testing.T) {
+	err := APIError("ThrottlingException")
+	var apiErr interface{ ErrorCode() string }
+	if !errors.As(err, &apiErr) || apiErr.ErrorCode() != "ThrottlingException" {
+		t.Fatalf("expected an api error with its code, got %v", err)
+	}
+}
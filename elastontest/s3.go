@@ -0,0 +1,225 @@
+package elastontest
+
+import (
+	"bytes"
+	"context"
+	"crypto/md5"
+	"encoding/hex"
+	"io"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	s3T "github.com/aws/aws-sdk-go-v2/service/s3/types"
+	"github.com/aws/smithy-go"
+	"github.com/aws/smithy-go/middleware"
+	smithyhttp "github.com/aws/smithy-go/transport/http"
+
+	"github.com/bcap/elaston/aws"
+)
+
+// S3 is a fake s3 client keeping objects in memory. By default it behaves like s3:
+// objects can be read back once written, missing objects fail with NoSuchKey and
+// conditional writes fail with a 412 status when their condition does not hold.
+// Buckets do not need to be created before use
+type S3 struct {
+	Recorder
+	buckets map[string]map[string]s3Object
+	mutex   sync.Mutex
+}
+
+type s3Object struct {
+	data []byte
+	etag string
+}
+
+var _ aws.S3API = (*S3)(nil)
+
+// Object returns the contents of an object and whether it exists
+func (f *S3) Object(bucket string, key string) ([]byte, bool) {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	object, ok := f.buckets[bucket][key]
+	return object.data, ok
+}
+
+// Keys returns the keys of the objects in the bucket under the prefix, sorted
+func (f *S3) Keys(bucket string, prefix string) []string {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	return f.keys(bucket, prefix)
+}
+
+// SetObject writes an object without going through the recorder
+func (f *S3) SetObject(bucket string, key string, data []byte) {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	f.put(bucket, key, data)
+}
+
+func (f *S3) CreateBucket(ctx context.Context, input *s3.CreateBucketInput, _ ...func(*s3.Options)) (*s3.CreateBucketOutput, error) {
+	return call(&f.Recorder, "CreateBucket", input, func() *s3.CreateBucketOutput {
+		f.mutex.Lock()
+		defer f.mutex.Unlock()
+		f.bucket(*input.Bucket)
+		return &s3.CreateBucketOutput{}
+	})
+}
+
+func (f *S3) DeleteBucket(ctx context.Context, input *s3.DeleteBucketInput, _ ...func(*s3.Options)) (*s3.DeleteBucketOutput, error) {
+	return call(&f.Recorder, "DeleteBucket", input, func() *s3.DeleteBucketOutput {
+		f.mutex.Lock()
+		defer f.mutex.Unlock()
+		delete(f.buckets, *input.Bucket)
+		return &s3.DeleteBucketOutput{}
+	})
+}
+
+func (f *S3) PutBucketLifecycleConfiguration(ctx context.Context, input *s3.PutBucketLifecycleConfigurationInput, _ ...func(*s3.Options)) (*s3.PutBucketLifecycleConfigurationOutput, error) {
+	return call(&f.Recorder, "PutBucketLifecycleConfiguration", input, func() *s3.PutBucketLifecycleConfigurationOutput { return &s3.PutBucketLifecycleConfigurationOutput{} })
+}
+
+func (f *S3) PutObject(ctx context.Context, input *s3.PutObjectInput, optFns ...func(*s3.Options)) (*s3.PutObjectOutput, error) {
+	return callOrFail(&f.Recorder, "PutObject", input, func() (*s3.PutObjectOutput, error) {
+		var data []byte
+		if input.Body != nil {
+			var err error
+			if data, err = io.ReadAll(input.Body); err != nil {
+				return nil, err
+			}
+		}
+		header := requestHeader(optFns)
+
+		f.mutex.Lock()
+		defer f.mutex.Unlock()
+		existing, exists := f.bucket(*input.Bucket)[*input.Key]
+		if header.Get("If-None-Match") == "*" && exists {
+			return nil, statusError(http.StatusPreconditionFailed, "PreconditionFailed")
+		}
+		if etag := header.Get("If-Match"); etag != "" && (!exists || existing.etag != etag) {
+			return nil, statusError(http.StatusPreconditionFailed, "PreconditionFailed")
+		}
+		etag := f.put(*input.Bucket, *input.Key, data)
+		return &s3.PutObjectOutput{ETag: &etag}, nil
+	})
+}
+
+func (f *S3) GetObject(ctx context.Context, input *s3.GetObjectInput, _ ...func(*s3.Options)) (*s3.GetObjectOutput, error) {
+	return callOrFail(&f.Recorder, "GetObject", input, func() (*s3.GetObjectOutput, error) {
+		f.mutex.Lock()
+		defer f.mutex.Unlock()
+		object, ok := f.buckets[*input.Bucket][*input.Key]
+		if !ok {
+			return nil, &s3T.NoSuchKey{Message: ptr("injected by elastontest")}
+		}
+		return &s3.GetObjectOutput{
+			Body:          io.NopCloser(bytes.NewReader(object.data)),
+			ContentLength: int64(len(object.data)),
+			ETag:          ptr(object.etag),
+		}, nil
+	})
+}
+
+func (f *S3) DeleteObject(ctx context.Context, input *s3.DeleteObjectInput, _ ...func(*s3.Options)) (*s3.DeleteObjectOutput, error) {
+	return call(&f.Recorder, "DeleteObject", input, func() *s3.DeleteObjectOutput {
+		f.mutex.Lock()
+		defer f.mutex.Unlock()
+		delete(f.buckets[*input.Bucket], *input.Key)
+		return &s3.DeleteObjectOutput{}
+	})
+}
+
+func (f *S3) DeleteObjects(ctx context.Context, input *s3.DeleteObjectsInput, _ ...func(*s3.Options)) (*s3.DeleteObjectsOutput, error) {
+	return call(&f.Recorder, "DeleteObjects", input, func() *s3.DeleteObjectsOutput {
+		f.mutex.Lock()
+		defer f.mutex.Unlock()
+		out := s3.DeleteObjectsOutput{}
+		for _, object := range input.Delete.Objects {
+			delete(f.buckets[*input.Bucket], *object.Key)
+			out.Deleted = append(out.Deleted, s3T.DeletedObject{Key: object.Key})
+		}
+		return &out
+	})
+}
+
+// ListObjectsV2 returns every matching object in a single page
+func (f *S3) ListObjectsV2(ctx context.Context, input *s3.ListObjectsV2Input, _ ...func(*s3.Options)) (*s3.ListObjectsV2Output, error) {
+	return call(&f.Recorder, "ListObjectsV2", input, func() *s3.ListObjectsV2Output {
+		f.mutex.Lock()
+		defer f.mutex.Unlock()
+		prefix := ""
+		if input.Prefix != nil {
+			prefix = *input.Prefix
+		}
+		out := s3.ListObjectsV2Output{Name: input.Bucket, Prefix: input.Prefix}
+		for _, key := range f.keys(*input.Bucket, prefix) {
+			object := f.buckets[*input.Bucket][key]
+			out.Contents = append(out.Contents, s3T.Object{Key: ptr(key), Size: int64(len(object.data)), ETag: ptr(object.etag)})
+		}
+		out.KeyCount = int32(len(out.Contents))
+		return &out
+	})
+}
+
+func (f *S3) bucket(name string) map[string]s3Object {
+	if f.buckets == nil {
+		f.buckets = map[string]map[string]s3Object{}
+	}
+	if f.buckets[name] == nil {
+		f.buckets[name] = map[string]s3Object{}
+	}
+	return f.buckets[name]
+}
+
+func (f *S3) put(bucket string, key string, data []byte) string {
+	sum := md5.Sum(data)
+	etag := `"` + hex.EncodeToString(sum[:]) + `"`
+	f.bucket(bucket)[key] = s3Object{data: data, etag: etag}
+	return etag
+}
+
+func (f *S3) keys(bucket string, prefix string) []string {
+	keys := []string{}
+	for key := range f.buckets[bucket] {
+		if strings.HasPrefix(key, prefix) {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// requestHeader returns the http headers the api options would add to the request,
+// eg the conditions of a conditional write
+func requestHeader(optFns []func(*s3.Options)) http.Header {
+	options := s3.Options{}
+	for _, fn := range optFns {
+		fn(&options)
+	}
+	stack := middleware.NewStack("elastontest", smithyhttp.NewStackRequest)
+	for _, fn := range options.APIOptions {
+		if err := fn(stack); err != nil {
+			return http.Header{}
+		}
+	}
+	header := http.Header{}
+	handler := middleware.DecorateHandler(middleware.HandlerFunc(func(ctx context.Context, input any) (any, middleware.Metadata, error) {
+		if request, ok := input.(*smithyhttp.Request); ok {
+			header = request.Header
+		}
+		return nil, middleware.Metadata{}, nil
+	}), stack)
+	handler.Handle(context.Background(), struct{}{})
+	return header
+}
+
+// statusError returns an error like the ones the aws sdk returns for client errors
+// with the given http status and error code
+func statusError(status int, code string) error {
+	return &smithyhttp.ResponseError{
+		Response: &smithyhttp.Response{Response: &http.Response{StatusCode: status, Header: http.Header{}}},
+		Err:      &smithy.GenericAPIError{Code: code, Message: "injected by elastontest", Fault: smithy.FaultClient},
+	}
+}
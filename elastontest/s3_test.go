@@ -0,0 +1,81 @@
+package elastontest
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"testing"
+
+	"github.com/bcap/elaston/aws"
+)
+
+func TestS3ConditionalWrites(t *testing.T) {
+	ctx := context.Background()
+	fakes := New()
+	client := fakes.AWS()
+
+	// Creating only succeeds once
+	if err := client.PutS3ObjectIfMatch(ctx, "bucket", "key", []byte("1"), ""); err != nil {
+		t.Fatal(err)
+	}
+	if err := client.PutS3ObjectIfMatch(ctx, "bucket", "key", []byte("2"), ""); !errors.Is(err, aws.ErrPreconditionFailed) {
+		t.Fatalf("expected creating an existing object to fail, got %v", err)
+	}
+
+	data, etag, err := client.GetS3ObjectVersion(ctx, "bucket", "key")
+	if err != nil || string(data) != "1" || etag == "" {
+		t.Fatalf("expected the object with its etag, got %q, %q, %v", data, etag, err)
+	}
+	// Updates need the etag of the current version
+	if err := client.PutS3ObjectIfMatch(ctx, "bucket", "key", []byte("2"), etag); err != nil {
+		t.Fatal(err)
+	}
+	if err := client.PutS3ObjectIfMatch(ctx, "bucket", "key", []byte("3"), etag); !errors.Is(err, aws.ErrPreconditionFailed) {
+		t.Fatalf("expected a stale etag to fail, got %v", err)
+	}
+	if err := client.PutS3ObjectIfMatch(ctx, "bucket", "missing", []byte("1"), etag); !errors.Is(err, aws.ErrPreconditionFailed) {
+		t.Fatalf("expected updating a missing object to fail, got %v", err)
+	}
+	if data, _ := fakes.S3.Object("bucket", "key"); string(data) != "2" {
+		t.Fatalf("expected the object to hold the last successful write, got %q", data)
+	}
+}
+
+func TestS3Objects(t *testing.T) {
+	ctx := context.Background()
+	fakes := New()
+	client := fakes.AWS()
+
+	if data, err := client.GetS3Object(ctx, "bucket", "missing"); data != nil || err != nil {
+		t.Fatalf("expected a missing object to be nil, got %q, %v", data, err)
+	}
+	for _, key := range []string{"a/2", "a/1", "b/1"} {
+		if err := client.PutS3Object(ctx, "bucket", key, []byte(key)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	fakes.S3.SetObject("bucket", "a/3", []byte("a/3"))
+	if keys := fakes.S3.Keys("bucket", "a/"); !reflect.DeepEqual(keys, []string{"a/1", "a/2", "a/3"}) {
+		t.Fatalf("unexpected keys %v", keys)
+	}
+	if data, err := client.GetS3Object(ctx, "bucket", "a/3"); err != nil || string(data) != "a/3" {
+		t.Fatalf("expected the object set directly to be readable, got %q, %v", data, err)
+	}
+	// SetObject does not go through the recorder
+	if puts := len(fakes.S3.CallsTo("PutObject")); puts != 3 {
+		t.Fatalf("expected 3 recorded puts, got %d", puts)
+	}
+
+	if err := client.DeleteS3Object(ctx, "bucket", "a/1"); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := fakes.S3.Object("bucket", "a/1"); ok {
+		t.Fatal("expected the object to be deleted")
+	}
+	if err := client.DeleteBucket(ctx, "bucket"); err != nil {
+		t.Fatal(err)
+	}
+	if keys := fakes.S3.Keys("bucket", ""); len(keys) != 0 {
+		t.Fatalf("expected the bucket to be emptied, got %v", keys)
+	}
+}
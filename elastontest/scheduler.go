@@ -0,0 +1,133 @@
+package elastontest
+
+import (
+	"context"
+	"sort"
+	"sync"
+
+	"github.com/aws/aws-sdk-go-v2/service/scheduler"
+	schedulerT "github.com/aws/aws-sdk-go-v2/service/scheduler/types"
+
+	"github.com/bcap/elaston/aws"
+)
+
+// Scheduler is a fake eventbridge scheduler client keeping schedules in memory. By
+// default creating a schedule that already exists fails with a ConflictException
+// and deleting one that does not exist fails with a ResourceNotFoundException, as
+// the real service does
+type Scheduler struct {
+	Recorder
+	// groups maps group names to their schedules by name
+	groups map[string]map[string]*scheduler.CreateScheduleInput
+	mutex  sync.Mutex
+}
+
+var _ aws.SchedulerAPI = (*Scheduler)(nil)
+
+// Schedule returns the input the schedule was created with, or nil if it does not exist
+func (f *Scheduler) Schedule(group string, name string) *scheduler.CreateScheduleInput {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	return f.groups[group][name]
+}
+
+// Schedules returns the names of the schedules in the group, sorted
+func (f *Scheduler) Schedules(group string) []string {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	names := []string{}
+	for name := range f.groups[group] {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+func (f *Scheduler) CreateScheduleGroup(ctx context.Context, input *scheduler.CreateScheduleGroupInput, _ ...func(*scheduler.Options)) (*scheduler.CreateScheduleGroupOutput, error) {
+	return callOrFail(&f.Recorder, "CreateScheduleGroup", input, func() (*scheduler.CreateScheduleGroupOutput, error) {
+		f.mutex.Lock()
+		defer f.mutex.Unlock()
+		if _, ok := f.groups[*input.Name]; ok {
+			return nil, &schedulerT.ConflictException{Message: ptr("injected by elastontest")}
+		}
+		f.group(*input.Name)
+		return &scheduler.CreateScheduleGroupOutput{}, nil
+	})
+}
+
+func (f *Scheduler) DeleteScheduleGroup(ctx context.Context, input *scheduler.DeleteScheduleGroupInput, _ ...func(*scheduler.Options)) (*scheduler.DeleteScheduleGroupOutput, error) {
+	return callOrFail(&f.Recorder, "DeleteScheduleGroup", input, func() (*scheduler.DeleteScheduleGroupOutput, error) {
+		f.mutex.Lock()
+		defer f.mutex.Unlock()
+		if _, ok := f.groups[*input.Name]; !ok {
+			return nil, &schedulerT.ResourceNotFoundException{Message: ptr("injected by elastontest")}
+		}
+		delete(f.groups, *input.Name)
+		return &scheduler.DeleteScheduleGroupOutput{}, nil
+	})
+}
+
+// CreateSchedule stores the schedule in its group, creating the group if needed.
+// Schedules are never run
+func (f *Scheduler) CreateSchedule(ctx context.Context, input *scheduler.CreateScheduleInput, _ ...func(*scheduler.Options)) (*scheduler.CreateScheduleOutput, error) {
+	return callOrFail(&f.Recorder, "CreateSchedule", input, func() (*scheduler.CreateScheduleOutput, error) {
+		f.mutex.Lock()
+		defer f.mutex.Unlock()
+		group := f.group(groupName(input.GroupName))
+		if _, ok := group[*input.Name]; ok {
+			return nil, &schedulerT.ConflictException{Message: ptr("injected by elastontest")}
+		}
+		group[*input.Name] = input
+		return &scheduler.CreateScheduleOutput{}, nil
+	})
+}
+
+func (f *Scheduler) DeleteSchedule(ctx context.Context, input *scheduler.DeleteScheduleInput, _ ...func(*scheduler.Options)) (*scheduler.DeleteScheduleOutput, error) {
+	return callOrFail(&f.Recorder, "DeleteSchedule", input, func() (*scheduler.DeleteScheduleOutput, error) {
+		f.mutex.Lock()
+		defer f.mutex.Unlock()
+		group := f.groups[groupName(input.GroupName)]
+		if _, ok := group[*input.Name]; !ok {
+			return nil, &schedulerT.ResourceNotFoundException{Message: ptr("injected by elastontest")}
+		}
+		delete(group, *input.Name)
+		return &scheduler.DeleteScheduleOutput{}, nil
+	})
+}
+
+// ListSchedules returns every schedule of the group in a single page
+func (f *Scheduler) ListSchedules(ctx context.Context, input *scheduler.ListSchedulesInput, _ ...func(*scheduler.Options)) (*scheduler.ListSchedulesOutput, error) {
+	return call(&f.Recorder, "ListSchedules", input, func() *scheduler.ListSchedulesOutput {
+		f.mutex.Lock()
+		defer f.mutex.Unlock()
+		name := groupName(input.GroupName)
+		out := scheduler.ListSchedulesOutput{}
+		for _, schedule := range f.groups[name] {
+			summary := schedulerT.ScheduleSummary{Name: schedule.Name, GroupName: ptr(name), State: schedulerT.ScheduleStateEnabled}
+			if schedule.Target != nil {
+				summary.Target = &schedulerT.TargetSummary{Arn: schedule.Target.Arn}
+			}
+			out.Schedules = append(out.Schedules, summary)
+		}
+		sort.Slice(out.Schedules, func(i, j int) bool { return *out.Schedules[i].Name < *out.Schedules[j].Name })
+		return &out
+	})
+}
+
+func (f *Scheduler) group(name string) map[string]*scheduler.CreateScheduleInput {
+	if f.groups == nil {
+		f.groups = map[string]map[string]*scheduler.CreateScheduleInput{}
+	}
+	if f.groups[name] == nil {
+		f.groups[name] = map[string]*scheduler.CreateScheduleInput{}
+	}
+	return f.groups[name]
+}
+
+// groupName returns the group of a request, which defaults to the default group
+func groupName(name *string) string {
+	if name == nil || *name == "" {
+		return "default"
+	}
+	return *name
+}
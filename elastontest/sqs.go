@@ -0,0 +1,86 @@
+package elastontest
+
+import (
+	"context"
+	"fmt"
+	"sync/atomic"
+
+	"github.com/aws/aws-sdk-go-v2/service/sqs"
+	sqsT "github.com/aws/aws-sdk-go-v2/service/sqs/types"
+
+	"github.com/bcap/elaston/aws"
+)
+
+// SQS is a fake sqs client. By default sent messages get sequential message ids,
+// queue urls are derived from the queue names and the other operations return
+// empty outputs
+type SQS struct {
+	Recorder
+	messageCount int64
+}
+
+var _ aws.SQSAPI = (*SQS)(nil)
+
+func (f *SQS) CreateQueue(ctx context.Context, input *sqs.CreateQueueInput, _ ...func(*sqs.Options)) (*sqs.CreateQueueOutput, error) {
+	return call(&f.Recorder, "CreateQueue", input, func() *sqs.CreateQueueOutput { return &sqs.CreateQueueOutput{QueueUrl: fakeQueueURL(input.QueueName)} })
+}
+
+func (f *SQS) DeleteQueue(ctx context.Context, input *sqs.DeleteQueueInput, _ ...func(*sqs.Options)) (*sqs.DeleteQueueOutput, error) {
+	return call(&f.Recorder, "DeleteQueue", input, func() *sqs.DeleteQueueOutput { return &sqs.DeleteQueueOutput{} })
+}
+
+func (f *SQS) GetQueueUrl(ctx context.Context, input *sqs.GetQueueUrlInput, _ ...func(*sqs.Options)) (*sqs.GetQueueUrlOutput, error) {
+	return call(&f.Recorder, "GetQueueUrl", input, func() *sqs.GetQueueUrlOutput { return &sqs.GetQueueUrlOutput{QueueUrl: fakeQueueURL(input.QueueName)} })
+}
+
+func (f *SQS) GetQueueAttributes(ctx context.Context, input *sqs.GetQueueAttributesInput, _ ...func(*sqs.Options)) (*sqs.GetQueueAttributesOutput, error) {
+	return call(&f.Recorder, "GetQueueAttributes", input, func() *sqs.GetQueueAttributesOutput { return &sqs.GetQueueAttributesOutput{} })
+}
+
+func (f *SQS) PurgeQueue(ctx context.Context, input *sqs.PurgeQueueInput, _ ...func(*sqs.Options)) (*sqs.PurgeQueueOutput, error) {
+	return call(&f.Recorder, "PurgeQueue", input, func() *sqs.PurgeQueueOutput { return &sqs.PurgeQueueOutput{} })
+}
+
+func (f *SQS) SendMessage(ctx context.Context, input *sqs.SendMessageInput, _ ...func(*sqs.Options)) (*sqs.SendMessageOutput, error) {
+	return call(&f.Recorder, "SendMessage", input, func() *sqs.SendMessageOutput { return &sqs.SendMessageOutput{MessageId: f.nextMessageID()} })
+}
+
+func (f *SQS) SendMessageBatch(ctx context.Context, input *sqs.SendMessageBatchInput, _ ...func(*sqs.Options)) (*sqs.SendMessageBatchOutput, error) {
+	return call(&f.Recorder, "SendMessageBatch", input, func() *sqs.SendMessageBatchOutput { return f.batchOutput(input) })
+}
+
+func (f *SQS) ReceiveMessage(ctx context.Context, input *sqs.ReceiveMessageInput, _ ...func(*sqs.Options)) (*sqs.ReceiveMessageOutput, error) {
+	return call(&f.Recorder, "ReceiveMessage", input, func() *sqs.ReceiveMessageOutput { return &sqs.ReceiveMessageOutput{} })
+}
+
+func (f *SQS) DeleteMessage(ctx context.Context, input *sqs.DeleteMessageInput, _ ...func(*sqs.Options)) (*sqs.DeleteMessageOutput, error) {
+	return call(&f.Recorder, "DeleteMessage", input, func() *sqs.DeleteMessageOutput { return &sqs.DeleteMessageOutput{} })
+}
+
+func (f *SQS) ChangeMessageVisibility(ctx context.Context, input *sqs.ChangeMessageVisibilityInput, _ ...func(*sqs.Options)) (*sqs.ChangeMessageVisibilityOutput, error) {
+	return call(&f.Recorder, "ChangeMessageVisibility", input, func() *sqs.ChangeMessageVisibilityOutput { return &sqs.ChangeMessageVisibilityOutput{} })
+}
+
+func (f *SQS) nextMessageID() *string {
+	id := fmt.Sprintf("message-%d", atomic.AddInt64(&f.messageCount, 1))
+	return &id
+}
+
+func (f *SQS) batchOutput(input *sqs.SendMessageBatchInput) *sqs.SendMessageBatchOutput {
+	out := sqs.SendMessageBatchOutput{}
+	for _, entry := range input.Entries {
+		out.Successful = append(out.Successful, sqsT.SendMessageBatchResultEntry{
+			Id:        entry.Id,
+			MessageId: f.nextMessageID(),
+		})
+	}
+	return &out
+}
+
+func fakeQueueURL(name *string) *string {
+	url := "https://sqs.us-east-1.amazonaws.com/" + FakeAccount + "/"
+	if name != nil {
+		url += *name
+	}
+	return &url
+}
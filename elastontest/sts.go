@@ -0,0 +1,25 @@
+package elastontest
+
+import (
+	"context"
+
+	"github.com/aws/aws-sdk-go-v2/service/sts"
+
+	"github.com/bcap/elaston/aws"
+)
+
+// STS is a fake sts client. By default the caller identity is in FakeAccount
+type STS struct {
+	Recorder
+}
+
+var _ aws.STSAPI = (*STS)(nil)
+
+func (f *STS) GetCallerIdentity(ctx context.Context, input *sts.GetCallerIdentityInput, _ ...func(*sts.Options)) (*sts.GetCallerIdentityOutput, error) {
+	return call(&f.Recorder, "GetCallerIdentity", input, func() *sts.GetCallerIdentityOutput {
+		return &sts.GetCallerIdentityOutput{
+			Account: ptr(FakeAccount),
+			Arn:     ptr("arn:aws:iam::" + FakeAccount + ":user/elastontest"),
+		}
+	})
+}
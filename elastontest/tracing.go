@@ -0,0 +1,14 @@
+package elastontest
+
+import (
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+)
+
+// NewInMemoryTracerProvider returns a tracer provider that keeps spans in memory,
+// so tests can inspect them through the returned exporter. Pass it to
+// elaston.WithTracerProvider
+func NewInMemoryTracerProvider() (*sdktrace.TracerProvider, *tracetest.InMemoryExporter) {
+	exporter := tracetest.NewInMemoryExporter()
+	return sdktrace.NewTracerProvider(sdktrace.WithSyncer(exporter)), exporter
+}
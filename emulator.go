@@ -0,0 +1,472 @@
+package elaston
+
+import (
+	"context"
+	"crypto/md5"
+	"encoding/hex"
+	"encoding/json"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-lambda-go/lambda/messages"
+
+	"github.com/bcap/elaston/aws"
+)
+
+const runtimeAPIPrefix = "/2018-06-01/runtime/"
+
+// processBackend runs invocations in subprocesses of an executable built with
+// elaston, the same way lambda does: every subprocess is an execution environment
+// polling its own emulation of the lambda runtime api for invocations
+type processBackend struct {
+	executable  string
+	environment []string
+	invocations chan *invocation
+	processes   []*runtimeProcess
+	logf        func(format string, args ...any)
+	// send, when set, receives the messages the subprocesses send to sqs, which is
+	// emulated on the same address as the runtime api
+	send func(body string, attributes map[string]string, options aws.SendOptions) (string, error)
+
+	// initErr is the error of the latest failed initialization, cleared once an
+	// execution environment gets ready. initChanged is closed whenever it changes
+	initErr     error
+	initChanged chan struct{}
+	mutex       sync.Mutex
+
+	cancel context.CancelFunc
+	wg     sync.WaitGroup
+}
+
+type invocation struct {
+	requestID string
+	payload   []byte
+	deadline  time.Time
+	done      chan invocationResult
+	// process is the execution environment handling the invocation
+	process *runtimeProcess
+}
+
+type invocationResult struct {
+	payload []byte
+	err     error
+}
+
+// runtimeProcess is a single execution environment
+type runtimeProcess struct {
+	backend  *processBackend
+	listener net.Listener
+	server   *http.Server
+
+	cmd     *exec.Cmd
+	ready   bool
+	current *invocation
+	mutex   sync.Mutex
+}
+
+func newProcessBackend(executable string, environment map[string]string, processes int, send func(string, map[string]string, aws.SendOptions) (string, error), logf func(string, ...any)) (*processBackend, error) {
+	backend := &processBackend{
+		executable:  executable,
+		invocations: make(chan *invocation),
+		initChanged: make(chan struct{}),
+		send:        send,
+		logf:        logf,
+	}
+
+	// The variables lambda sets, followed by the ones elaston deploys with. The given
+	// environment goes last, overriding them
+	backend.environment = append(os.Environ(),
+		"ELASTON_RUNNING_ON_LAMBDA=",
+		"AWS_LAMBDA_FUNCTION_NAME="+localFunctionName,
+		"AWS_LAMBDA_FUNCTION_VERSION=$LATEST",
+		"AWS_LAMBDA_FUNCTION_MEMORY_SIZE=128",
+		"_HANDLER=main",
+	)
+	if send != nil {
+		// The sdk needs a region and credentials to sign requests, even emulated ones
+		for key, value := range map[string]string{"AWS_REGION": "us-east-1", "AWS_ACCESS_KEY_ID": "local", "AWS_SECRET_ACCESS_KEY": "local"} {
+			if _, ok := os.LookupEnv(key); !ok {
+				backend.environment = append(backend.environment, key+"="+value)
+			}
+		}
+	}
+	for key, value := range environment {
+		backend.environment = append(backend.environment, key+"="+value)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	backend.cancel = cancel
+	for i := 0; i < processes; i++ {
+		listener, err := net.Listen("tcp", "127.0.0.1:0")
+		if err != nil {
+			backend.close()
+			return nil, err
+		}
+		process := &runtimeProcess{backend: backend, listener: listener}
+		process.server = &http.Server{Handler: process}
+		backend.processes = append(backend.processes, process)
+
+		backend.wg.Add(2)
+		go func() {
+			defer backend.wg.Done()
+			if err := process.server.Serve(listener); err != nil && !errors.Is(err, http.ErrServerClosed) {
+				logf("local runtime api stopped: %v", err)
+			}
+		}()
+		go func() {
+			defer backend.wg.Done()
+			process.supervise(ctx)
+		}()
+	}
+	return backend, nil
+}
+
+// invoke hands the payload to the first execution environment asking for an
+// invocation, waiting up to timeout for its response. Errors reported by the
+// function are returned as messages.InvokeResponse_Error
+func (b *processBackend) invoke(ctx context.Context, requestID string, payload []byte, timeout time.Duration) ([]byte, error) {
+	inv := &invocation{
+		requestID: requestID,
+		payload:   payload,
+		deadline:  time.Now().Add(timeout),
+		done:      make(chan invocationResult, 1),
+	}
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+
+	for queued := false; !queued; {
+		b.mutex.Lock()
+		initErr, initChanged := b.initErr, b.initChanged
+		b.mutex.Unlock()
+		if initErr != nil {
+			return nil, initErr
+		}
+		select {
+		case b.invocations <- inv:
+			queued = true
+		case <-initChanged:
+		case <-timer.C:
+			return nil, fmt.Errorf("no execution environment became available within %s", timeout)
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+	}
+
+	select {
+	case result := <-inv.done:
+		return result.payload, result.err
+	case <-timer.C:
+		// Like lambda, the execution environment is not reused after a timeout
+		b.mutex.Lock()
+		process := inv.process
+		b.mutex.Unlock()
+		process.kill()
+		return nil, messages.InvokeResponse_Error{
+			Message: fmt.Sprintf("Task timed out after %.2f seconds", timeout.Seconds()),
+			Type:    "Runtime.Timeout",
+		}
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+func (b *processBackend) setInitErr(err error) {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	if err == nil && b.initErr == nil {
+		return
+	}
+	b.initErr = err
+	close(b.initChanged)
+	b.initChanged = make(chan struct{})
+}
+
+func (b *processBackend) close() {
+	b.cancel()
+	for _, process := range b.processes {
+		process.kill()
+		process.server.Close()
+	}
+	b.wg.Wait()
+}
+
+// supervise keeps the execution environment process running, starting it again
+// whenever it exits
+func (p *runtimeProcess) supervise(ctx context.Context) {
+	backoff := 100 * time.Millisecond
+	for ctx.Err() == nil {
+		p.mutex.Lock()
+		p.ready = false
+		p.cmd = exec.Command(p.backend.executable)
+		p.cmd.Env = append(append([]string{}, p.backend.environment...), "AWS_LAMBDA_RUNTIME_API="+p.listener.Addr().String())
+		if p.backend.send != nil {
+			endpoint := "http://" + p.listener.Addr().String()
+			p.cmd.Env = append(p.cmd.Env,
+				"AWS_ENDPOINT_URL_SQS="+endpoint,
+				"ELASTON_SQS_QUEUE_URL="+endpoint+"/000000000000/"+localFunctionName,
+			)
+		}
+		p.cmd.Stdout = os.Stdout
+		p.cmd.Stderr = os.Stderr
+		err := p.cmd.Start()
+		cmd := p.cmd
+		p.mutex.Unlock()
+		if err == nil {
+			err = cmd.Wait()
+		}
+		if ctx.Err() != nil {
+			return
+		}
+
+		p.mutex.Lock()
+		ready, current := p.ready, p.current
+		p.current = nil
+		p.mutex.Unlock()
+		if current != nil {
+			current.done <- invocationResult{err: messages.InvokeResponse_Error{
+				Message: fmt.Sprintf("Runtime exited with error: %v", err),
+				Type:    "Runtime.ExitError",
+			}}
+		}
+		if !ready {
+			// The process died before asking for its first invocation
+			p.backend.setInitErr(messages.InvokeResponse_Error{
+				Message: fmt.Sprintf("Runtime exited during initialization: %v", err),
+				Type:    "Runtime.ExitError",
+			})
+			backoff *= 2
+			if backoff > 5*time.Second {
+				backoff = 5 * time.Second
+			}
+		} else {
+			backoff = 100 * time.Millisecond
+		}
+		p.backend.logf("local execution environment exited: %v", err)
+
+		select {
+		case <-time.After(backoff):
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+func (p *runtimeProcess) kill() {
+	if p == nil {
+		return
+	}
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	if p.cmd != nil && p.cmd.Process != nil {
+		p.cmd.Process.Kill()
+	}
+}
+
+// ServeHTTP implements the lambda runtime api for the process, along with the sqs
+// api when emulated
+func (p *runtimeProcess) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if !strings.HasPrefix(r.URL.Path, runtimeAPIPrefix) && r.Method == http.MethodPost && p.backend.send != nil {
+		p.backend.serveSQS(w, r)
+		return
+	}
+	path := strings.TrimPrefix(r.URL.Path, runtimeAPIPrefix)
+	switch {
+	case r.Method == http.MethodGet && path == "invocation/next":
+		p.next(w, r)
+	case r.Method == http.MethodPost && path == "init/error":
+		p.backend.setInitErr(readInvokeError(r))
+		w.WriteHeader(http.StatusAccepted)
+	case r.Method == http.MethodPost && strings.HasPrefix(path, "invocation/") && strings.HasSuffix(path, "/response"):
+		payload, err := io.ReadAll(r.Body)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		p.finish(w, strings.TrimSuffix(strings.TrimPrefix(path, "invocation/"), "/response"), invocationResult{payload: payload})
+	case r.Method == http.MethodPost && strings.HasPrefix(path, "invocation/") && strings.HasSuffix(path, "/error"):
+		p.finish(w, strings.TrimSuffix(strings.TrimPrefix(path, "invocation/"), "/error"), invocationResult{err: readInvokeError(r)})
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+// next blocks until there is an invocation for the process
+func (p *runtimeProcess) next(w http.ResponseWriter, r *http.Request) {
+	p.mutex.Lock()
+	p.ready = true
+	p.mutex.Unlock()
+	p.backend.setInitErr(nil)
+
+	var inv *invocation
+	select {
+	case inv = <-p.backend.invocations:
+	case <-r.Context().Done():
+		return
+	}
+
+	p.mutex.Lock()
+	p.current = inv
+	p.mutex.Unlock()
+	p.backend.mutex.Lock()
+	inv.process = p
+	p.backend.mutex.Unlock()
+
+	w.Header().Set("Lambda-Runtime-Aws-Request-Id", inv.requestID)
+	w.Header().Set("Lambda-Runtime-Deadline-Ms", strconv.FormatInt(inv.deadline.UnixMilli(), 10))
+	w.Header().Set("Lambda-Runtime-Invoked-Function-Arn", "arn:aws:lambda:local:000000000000:function:"+localFunctionName)
+	w.Header().Set("Lambda-Runtime-Trace-Id", "Root="+inv.requestID)
+	w.WriteHeader(http.StatusOK)
+	w.Write(inv.payload)
+}
+
+// finish delivers the result of the invocation the process is handling
+func (p *runtimeProcess) finish(w http.ResponseWriter, requestID string, result invocationResult) {
+	p.mutex.Lock()
+	current := p.current
+	if current != nil && current.requestID == requestID {
+		p.current = nil
+	}
+	p.mutex.Unlock()
+
+	if current == nil || current.requestID != requestID {
+		http.Error(w, "unknown request id "+requestID, http.StatusBadRequest)
+		return
+	}
+	current.done <- result
+	w.WriteHeader(http.StatusAccepted)
+}
+
+// readInvokeError reads an error reported by the runtime, which has the same shape
+// lambda returns to invokers
+func readInvokeError(r *http.Request) error {
+	invokeErr := messages.InvokeResponse_Error{Type: r.Header.Get("Lambda-Runtime-Function-Error-Type")}
+	body, err := io.ReadAll(r.Body)
+	if err == nil {
+		err = json.Unmarshal(body, &invokeErr)
+	}
+	if err != nil {
+		invokeErr.Message = string(body)
+	}
+	if invokeErr.Type == "" {
+		invokeErr.Type = "Runtime.Unknown"
+	}
+	return invokeErr
+}
+
+//
+// SQS emulation
+//
+
+// sqsNamespace is the xml namespace of sqs query api responses
+const sqsNamespace = "http://queue.amazonaws.com/doc/2012-11-05/"
+
+type sqsSendMessageResponse struct {
+	XMLName xml.Name             `xml:"SendMessageResponse"`
+	Xmlns   string               `xml:"xmlns,attr"`
+	Result  sqsSendMessageResult `xml:"SendMessageResult"`
+}
+
+type sqsSendMessageResult struct {
+	ID               string `xml:"Id,omitempty"`
+	MessageID        string `xml:"MessageId"`
+	MD5OfMessageBody string `xml:"MD5OfMessageBody"`
+}
+
+type sqsSendMessageBatchResponse struct {
+	XMLName    xml.Name               `xml:"SendMessageBatchResponse"`
+	Xmlns      string                 `xml:"xmlns,attr"`
+	Successful []sqsSendMessageResult `xml:"SendMessageBatchResult>SendMessageBatchResultEntry"`
+}
+
+type sqsErrorResponse struct {
+	XMLName xml.Name `xml:"ErrorResponse"`
+	Type    string   `xml:"Error>Type"`
+	Code    string   `xml:"Error>Code"`
+	Message string   `xml:"Error>Message"`
+}
+
+// serveSQS implements the SendMessage and SendMessageBatch operations of the sqs
+// query api, which is all the runtime uses, enqueueing the messages with send
+func (b *processBackend) serveSQS(w http.ResponseWriter, r *http.Request) {
+	if err := r.ParseForm(); err != nil {
+		writeSQSError(w, http.StatusBadRequest, "MalformedQueryString", err.Error())
+		return
+	}
+	var response any
+	switch action := r.PostForm.Get("Action"); action {
+	case "SendMessage":
+		result, err := b.sendSQS(r.PostForm, "")
+		if err != nil {
+			writeSQSError(w, http.StatusInternalServerError, "InternalError", err.Error())
+			return
+		}
+		response = sqsSendMessageResponse{Xmlns: sqsNamespace, Result: result}
+	case "SendMessageBatch":
+		batch := sqsSendMessageBatchResponse{Xmlns: sqsNamespace}
+		for i := 1; r.PostForm.Has(fmt.Sprintf("SendMessageBatchRequestEntry.%d.Id", i)); i++ {
+			prefix := fmt.Sprintf("SendMessageBatchRequestEntry.%d.", i)
+			result, err := b.sendSQS(r.PostForm, prefix)
+			if err != nil {
+				writeSQSError(w, http.StatusInternalServerError, "InternalError", err.Error())
+				return
+			}
+			result.ID = r.PostForm.Get(prefix + "Id")
+			batch.Successful = append(batch.Successful, result)
+		}
+		response = batch
+	default:
+		writeSQSError(w, http.StatusBadRequest, "InvalidAction", "the local sqs emulation does not support "+action)
+		return
+	}
+	w.Header().Set("Content-Type", "text/xml")
+	xml.NewEncoder(w).Encode(response)
+}
+
+// sendSQS sends the message whose parameters start with prefix in the form
+func (b *processBackend) sendSQS(form url.Values, prefix string) (sqsSendMessageResult, error) {
+	body := form.Get(prefix + "MessageBody")
+	attributes := map[string]string{}
+	for i := 1; ; i++ {
+		attribute := fmt.Sprintf("%sMessageAttribute.%d.", prefix, i)
+		name := form.Get(attribute + "Name")
+		if name == "" {
+			break
+		}
+		attributes[name] = form.Get(attribute + "Value.StringValue")
+	}
+	options := aws.SendOptions{
+		GroupID:         form.Get(prefix + "MessageGroupId"),
+		DeduplicationID: form.Get(prefix + "MessageDeduplicationId"),
+	}
+	if delay := form.Get(prefix + "DelaySeconds"); delay != "" {
+		seconds, err := strconv.Atoi(delay)
+		if err != nil {
+			return sqsSendMessageResult{}, fmt.Errorf("invalid DelaySeconds %q: %w", delay, err)
+		}
+		options.DelaySeconds = int32(seconds)
+	}
+
+	id, err := b.send(body, attributes, options)
+	if err != nil {
+		return sqsSendMessageResult{}, err
+	}
+	sum := md5.Sum([]byte(body))
+	return sqsSendMessageResult{MessageID: id, MD5OfMessageBody: hex.EncodeToString(sum[:])}, nil
+}
+
+func writeSQSError(w http.ResponseWriter, status int, code string, message string) {
+	w.Header().Set("Content-Type", "text/xml")
+	w.WriteHeader(status)
+	xml.NewEncoder(w).Encode(sqsErrorResponse{Type: "Sender", Code: code, Message: message})
+}
@@ -0,0 +1,187 @@
+package elaston
+
+import (
+	"context"
+	"errors"
+	"io"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-lambda-go/events"
+
+	"github.com/bcap/elaston/aws"
+)
+
+func TestEmulatedSQS(t *testing.T) {
+	tests := []struct {
+		name     string
+		queueURL string
+		options  []Option
+		inputs   []any
+		many     bool
+		groupID  string
+	}{
+		{name: "send message", queueURL: "/000000000000/queue", inputs: []any{"one"}},
+		{name: "send message batch", queueURL: "/000000000000/queue", inputs: []any{"one", "two", "three"}, many: true},
+		{
+			name:     "fifo queue",
+			queueURL: "/000000000000/queue.fifo",
+			options:  []Option{WithMessageGroup("group"), WithDeduplicationID("dedup")},
+			inputs:   []any{"one", "two"},
+			many:     true,
+			groupID:  "group",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := context.Background()
+			sent := []events.SQSMessage{}
+			sendOptions := []aws.SendOptions{}
+			mutex := sync.Mutex{}
+			backend := &processBackend{send: func(body string, attributes map[string]string, opts aws.SendOptions) (string, error) {
+				mutex.Lock()
+				defer mutex.Unlock()
+				msg := events.SQSMessage{Body: body, MessageAttributes: map[string]events.SQSMessageAttribute{}}
+				for key, value := range attributes {
+					value := value
+					msg.MessageAttributes[key] = events.SQSMessageAttribute{StringValue: &value, DataType: "String"}
+				}
+				sent = append(sent, msg)
+				sendOptions = append(sendOptions, opts)
+				return "id", nil
+			}}
+			server := httptest.NewServer(http.HandlerFunc(backend.serveSQS))
+			defer server.Close()
+
+			client, err := aws.New("",
+				aws.WithRegion("us-east-1"),
+				aws.WithStaticCredentials("local", "local", ""),
+				aws.WithEndpoint("SQS", server.URL),
+			)
+			if err != nil {
+				t.Fatal(err)
+			}
+			options := append([]Option{
+				WithLogger(log.New(io.Discard, "", 0)),
+				WithMessageAttributes(map[string]string{"team": "local"}),
+			}, tt.options...)
+			elaston := New(client, "function", server.URL+tt.queueURL, options...)
+			if tt.many {
+				_, err = elaston.SubmitMany(ctx, tt.inputs)
+			} else {
+				_, err = elaston.Submit(ctx, tt.inputs[0])
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if len(sent) != len(tt.inputs) {
+				t.Fatalf("expected %d messages, got %d", len(tt.inputs), len(sent))
+			}
+			for i := range sent {
+				msg, err := messageFromSQS(&sent[i])
+				if err != nil {
+					t.Fatal(err)
+				}
+				var got any
+				if err := decode(JSON, msg.body, &got); err != nil {
+					t.Fatal(err)
+				}
+				if got != tt.inputs[i] {
+					t.Fatalf("message %d: expected %v, got %v", i, tt.inputs[i], got)
+				}
+				if msg.headers["team"] != "local" {
+					t.Fatalf("message %d: headers were not carried over: %v", i, msg.headers)
+				}
+				if sendOptions[i].GroupID != tt.groupID {
+					t.Fatalf("message %d: expected group %q, got %q", i, tt.groupID, sendOptions[i].GroupID)
+				}
+			}
+		})
+	}
+}
+
+func TestLocalProcess(t *testing.T) {
+	if testing.Short() {
+		t.Skip("builds and runs a binary")
+	}
+	executable := buildTestProcess(t)
+	tests := []struct {
+		name        string
+		input       string
+		environment map[string]string
+		output      string
+		// errType is the type of the error the call fails with, code the code of the
+		// *Error returned by the handler
+		errType string
+		code    string
+		// recovers is whether the function answers the calls made after the failure
+		recovers bool
+	}{
+		{name: "response", input: "hello", output: "hello", recovers: true},
+		{name: "handler error", input: "fail", errType: errorType, code: "failed", recovers: true},
+		// The execution environment timing out is killed and replaced
+		{name: "timeout", input: "sleep", errType: "Runtime.Timeout", recovers: true},
+		{name: "init error", input: "hello", environment: map[string]string{"ELASTON_TEST_INIT_ERROR": "broken"}, errType: "InitError"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+			defer cancel()
+			local, err := NewLocalProcess(
+				executable,
+				WithVisibilityTimeout(2*time.Second),
+				WithEnvironment(tt.environment),
+				WithRuntimeOptions(WithLogger(log.New(io.Discard, "", 0))),
+			)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer local.Close()
+			client := local.Client(WithLogger(log.New(io.Discard, "", 0)))
+
+			output, err := Call[string, string](ctx, client, tt.input)
+			if tt.errType != "" {
+				var remoteErr *RemoteError
+				if !errors.As(err, &remoteErr) || remoteErr.Type != tt.errType {
+					t.Fatalf("expected a remote error of type %s, got %v", tt.errType, err)
+				}
+				if tt.code != "" && (remoteErr.Err == nil || remoteErr.Err.Code != tt.code) {
+					t.Fatalf("expected error code %s, got %+v", tt.code, remoteErr.Err)
+				}
+			} else if err != nil {
+				t.Fatal(err)
+			} else if output != tt.output {
+				t.Fatalf("expected output %q, got %q", tt.output, output)
+			}
+
+			output, err = Call[string, string](ctx, client, "again")
+			if recovered := err == nil && output == "again"; recovered != tt.recovers {
+				t.Fatalf("expected the function to recover: %v, got %q, %v", tt.recovers, output, err)
+			}
+		})
+	}
+}
+
+// buildTestProcess builds the function in testdata/process, returning its path
+func buildTestProcess(t *testing.T) string {
+	t.Helper()
+	goTool, err := exec.LookPath("go")
+	if err != nil {
+		t.Skip("the go tool is needed to build the function")
+	}
+	executable := filepath.Join(t.TempDir(), "process")
+	build := exec.Command(goTool, "build", "-o", executable, "./testdata/process")
+	build.Env = append(os.Environ(), "CGO_ENABLED=0")
+	if out, err := build.CombinedOutput(); err != nil {
+		t.Fatalf("building the function failed: %v\n%s", err, out)
+	}
+	return executable
+}
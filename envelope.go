@@ -0,0 +1,169 @@
+package elaston
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"unsafe"
+
+	"github.com/aws/aws-lambda-go/events"
+)
+
+// envelopeKey marks a json payload as an elaston envelope
+const envelopeKey = "__elaston"
+
+// headerPrefix is shared by all headers elaston uses internally
+const headerPrefix = "elaston-"
+
+// headersAttribute is the sqs message attribute elaston headers are packed into
+const headersAttribute = "elaston"
+
+// bodyEncodingHeader is set when the body of an sqs message had to be base64 encoded
+// because it is binary, which sqs does not accept
+const bodyEncodingHeader = "elaston-body-encoding"
+
+// message is what elaston moves between clients and the runtime: an encoded body
+// plus the headers needed to make sense of it on the other side.
+//
+// Messages without headers are sent as is, keeping plain json payloads compatible
+// with anything else invoking the function or sending to the queue. Otherwise
+// headers travel as sqs message attributes when submitting and inside an envelope
+// when invoking lambda directly
+type message struct {
+	headers map[string]string
+	body    []byte
+}
+
+type envelope struct {
+	Headers map[string]string `json:"__elaston"`
+	// Payload holds the body when it is valid json, otherwise Data is used
+	Payload json.RawMessage `json:"payload,omitempty"`
+	Data    []byte          `json:"data,omitempty"`
+}
+
+func (m *message) setHeader(key string, value string) {
+	if m.headers == nil {
+		m.headers = map[string]string{}
+	}
+	m.headers[key] = value
+}
+
+// invokePayload encodes the message for a lambda invocation, or for a lambda response
+func (m message) invokePayload() ([]byte, error) {
+	if len(m.headers) == 0 {
+		return m.body, nil
+	}
+	env := envelope{Headers: m.headers}
+	if m.isJSON() {
+		env.Payload = m.body
+	} else {
+		env.Data = m.body
+	}
+	return json.Marshal(env)
+}
+
+// invokeSize is the size lambda accounts for when checking the payload size limit,
+// which includes the envelope and the base64 encoding of binary bodies
+func (m message) invokeSize() int {
+	payload, err := m.invokePayload()
+	if err != nil {
+		// Headers and bodies always marshal, this is never expected to happen
+		return len(m.body)
+	}
+	return len(payload)
+}
+
+func messageFromInvokePayload(payload []byte) message {
+	var env envelope
+	// cheap check before trying to decode the whole payload
+	if len(payload) == 0 || payload[0] != '{' {
+		return message{body: payload}
+	}
+	if err := json.Unmarshal(payload, &env); err != nil || env.Headers == nil {
+		return message{body: payload}
+	}
+	if env.Data != nil {
+		return message{headers: env.Headers, body: env.Data}
+	}
+	return message{headers: env.Headers, body: env.Payload}
+}
+
+// isJSON tells whether the body is plain json, in which case it can be embedded as is
+// in envelopes and sqs messages. Anything else is treated as binary
+func (m message) isJSON() bool {
+	_, hasContentType := m.headers[contentTypeHeader]
+	_, hasContentEncoding := m.headers[contentEncodingHeader]
+	_, hasEncryption := m.headers[encryptionHeader]
+	return !hasContentType && !hasContentEncoding && !hasEncryption && json.Valid(m.body)
+}
+
+// sqsMessage encodes the message as an sqs message body and its attributes. As sqs
+// only allows 10 attributes per message, elaston headers are packed together in a
+// single attribute, while any other header is sent as its own attribute
+func (m message) sqsMessage() (string, map[string]string) {
+	attributes := map[string]string{}
+	elastonHeaders := map[string]string{}
+	for key, value := range m.headers {
+		if strings.HasPrefix(key, headerPrefix) {
+			elastonHeaders[key] = value
+		} else {
+			attributes[key] = value
+		}
+	}
+
+	var body string
+	if m.isJSON() {
+		// Trick used by strings.Builder.String to convert bytes to string without copying data
+		body = unsafe.String(unsafe.SliceData(m.body), len(m.body))
+	} else {
+		body = base64.StdEncoding.EncodeToString(m.body)
+		elastonHeaders[bodyEncodingHeader] = "base64"
+	}
+
+	if len(elastonHeaders) > 0 {
+		// marshalling a map of strings cannot fail
+		packed, _ := json.Marshal(elastonHeaders)
+		attributes[headersAttribute] = string(packed)
+	}
+	return body, attributes
+}
+
+// sqsSize is the size sqs accounts for when checking the message size limit
+func (m message) sqsSize() int {
+	body, attributes := m.sqsMessage()
+	size := len(body)
+	for key, value := range attributes {
+		size += len(key) + len(value) + len("String")
+	}
+	return size
+}
+
+func messageFromSQS(msg *events.SQSMessage) (message, error) {
+	result := message{body: []byte(msg.Body)}
+	for key, attribute := range msg.MessageAttributes {
+		if attribute.StringValue == nil {
+			continue
+		}
+		if key != headersAttribute {
+			result.setHeader(key, *attribute.StringValue)
+			continue
+		}
+		elastonHeaders := map[string]string{}
+		if err := json.Unmarshal([]byte(*attribute.StringValue), &elastonHeaders); err != nil {
+			return result, fmt.Errorf("invalid %s message attribute: %w", headersAttribute, err)
+		}
+		for key, value := range elastonHeaders {
+			result.setHeader(key, value)
+		}
+	}
+	if result.headers[bodyEncodingHeader] == "base64" {
+		body, err := base64.StdEncoding.DecodeString(msg.Body)
+		if err != nil {
+			return result, err
+		}
+		result.body = body
+		delete(result.headers, bodyEncodingHeader)
+	}
+	return result, nil
+}
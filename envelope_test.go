@@ -0,0 +1,125 @@
+package elaston
+
+import (
+	"bytes"
+	"reflect"
+	"testing"
+
+	"github.com/aws/aws-lambda-go/events"
+)
+
+func TestMessageEncoding(t *testing.T) {
+	tests := []struct {
+		name    string
+		msg     message
+		rawSQS  bool
+		rawBody bool
+	}{
+		{
+			name:    "plain json",
+			msg:     message{body: []byte(`{"a":1}`)},
+			rawSQS:  true,
+			rawBody: true,
+		},
+		{
+			name:    "json with headers",
+			msg:     message{headers: map[string]string{"elaston-job-id": "job", "team": "data"}, body: []byte(`[1,2]`)},
+			rawBody: true,
+		},
+		{
+			name: "binary",
+			msg:  message{body: []byte{0xff, 0x00, 0x01}},
+		},
+		{
+			name: "binary with headers",
+			msg:  message{headers: map[string]string{contentEncodingHeader: "gzip"}, body: []byte{0x1f, 0x8b, 0x00}},
+		},
+		{
+			// json bodies are treated as binary once transformed, eg compressed
+			name: "encoded json",
+			msg:  message{headers: map[string]string{contentTypeHeader: "application/json"}, body: []byte(`"text"`)},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			body, attributes := tt.msg.sqsMessage()
+			if raw := body == string(tt.msg.body); raw != tt.rawBody {
+				t.Fatalf("expected the sqs body to be raw %v, got %q", tt.rawBody, body)
+			}
+			if raw := len(attributes) == 0; raw != tt.rawSQS {
+				t.Fatalf("expected no attributes to be %v, got %v", tt.rawSQS, attributes)
+			}
+			event := events.SQSMessage{Body: body, MessageAttributes: map[string]events.SQSMessageAttribute{}}
+			for key, value := range attributes {
+				value := value
+				event.MessageAttributes[key] = events.SQSMessageAttribute{StringValue: &value, DataType: "String"}
+			}
+			fromSQS, err := messageFromSQS(&event)
+			if err != nil {
+				t.Fatal(err)
+			}
+			assertSameMessage(t, tt.msg, fromSQS)
+			if size := tt.msg.sqsSize(); size < len(body) {
+				t.Fatalf("sqs size %d is smaller than the body", size)
+			}
+
+			payload, err := tt.msg.invokePayload()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if raw := bytes.Equal(payload, tt.msg.body); raw != (len(tt.msg.headers) == 0) {
+				t.Fatalf("expected the invoke payload to be raw only without headers, got %q", payload)
+			}
+			assertSameMessage(t, tt.msg, messageFromInvokePayload(payload))
+			if size := tt.msg.invokeSize(); size != len(payload) {
+				t.Fatalf("expected an invoke size of %d, got %d", len(payload), size)
+			}
+		})
+	}
+}
+
+func TestMessageFromInvokePayload(t *testing.T) {
+	tests := []struct {
+		name    string
+		payload string
+		headers map[string]string
+		body    string
+	}{
+		{name: "empty", payload: "", body: ""},
+		{name: "not json", payload: "hello", body: "hello"},
+		{name: "json object", payload: `{"payload":1}`, body: `{"payload":1}`},
+		{name: "invalid json", payload: `{"__elaston":`, body: `{"__elaston":`},
+		{name: "envelope", payload: `{"__elaston":{"k":"v"},"payload":{"a":1}}`, headers: map[string]string{"k": "v"}, body: `{"a":1}`},
+		{name: "binary envelope", payload: `{"__elaston":{"k":"v"},"data":"aGk="}`, headers: map[string]string{"k": "v"}, body: "hi"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			msg := messageFromInvokePayload([]byte(tt.payload))
+			assertSameMessage(t, message{headers: tt.headers, body: []byte(tt.body)}, msg)
+		})
+	}
+}
+
+func TestMessageFromSQSInvalidHeaders(t *testing.T) {
+	invalid := "{"
+	event := events.SQSMessage{
+		Body:              "{}",
+		MessageAttributes: map[string]events.SQSMessageAttribute{headersAttribute: {StringValue: &invalid, DataType: "String"}},
+	}
+	if _, err := messageFromSQS(&event); err == nil {
+		t.Fatal("expected an error for invalid packed headers")
+	}
+}
+
+func assertSameMessage(t *testing.T, expected message, got message) {
+	t.Helper()
+	if !bytes.Equal(expected.body, got.body) {
+		t.Fatalf("expected body %q, got %q", expected.body, got.body)
+	}
+	if len(expected.headers) == 0 && len(got.headers) == 0 {
+		return
+	}
+	if !reflect.DeepEqual(expected.headers, got.headers) {
+		t.Fatalf("expected headers %v, got %v", expected.headers, got.headers)
+	}
+}
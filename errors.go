@@ -0,0 +1,146 @@
+package elaston
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"reflect"
+	"strings"
+
+	"github.com/aws/aws-lambda-go/lambda/messages"
+)
+
+// errorType is the lambda error type used to ship an *Error from the handler to the
+// caller. The error message carries the json encoded *Error
+const errorType = "elaston.Error"
+
+// Error is a structured error that handlers can return. Its code and details
+// survive the trip back to the caller, where it can be retrieved from the
+// returned RemoteError with errors.As
+type Error struct {
+	Code    string `json:"code"`
+	Message string `json:"message"`
+	Details any    `json:"details,omitempty"`
+}
+
+func NewError(code string, message string, details any) *Error {
+	return &Error{Code: code, Message: message, Details: details}
+}
+
+func (e *Error) Error() string {
+	if e.Code == "" {
+		return e.Message
+	}
+	return e.Code + ": " + e.Message
+}
+
+// RemoteError is returned when the lambda function fails to process a call,
+// either because the handler returned an error or because it panicked
+type RemoteError struct {
+	// FunctionError is the error kind reported by lambda, eg "Unhandled"
+	FunctionError string       `json:"functionError,omitempty"`
+	Type          string       `json:"type,omitempty"`
+	Message       string       `json:"message"`
+	StackTrace    []StackFrame `json:"stackTrace,omitempty"`
+	// Err is set when the handler returned an *Error
+	Err *Error `json:"error,omitempty"`
+}
+
+type StackFrame struct {
+	Path  string `json:"path"`
+	Line  int32  `json:"line"`
+	Label string `json:"label"`
+}
+
+func (e *RemoteError) Error() string {
+	if e.Err != nil {
+		return "remote error: " + e.Err.Error()
+	}
+	if e.Type == "" {
+		return "remote error: " + e.Message
+	}
+	return fmt.Sprintf("remote error: %s: %s", e.Type, e.Message)
+}
+
+func (e *RemoteError) Unwrap() error {
+	if e.Err == nil {
+		return nil
+	}
+	return e.Err
+}
+
+func (e *RemoteError) StackTraceString() string {
+	builder := strings.Builder{}
+	for _, frame := range e.StackTrace {
+		fmt.Fprintf(&builder, "%s\n\t%s:%d\n", frame.Label, frame.Path, frame.Line)
+	}
+	return builder.String()
+}
+
+// newRemoteError decodes the error payload returned by lambda when the function fails
+func newRemoteError(functionError string, payload []byte) *RemoteError {
+	remoteErr := RemoteError{FunctionError: functionError}
+	body := struct {
+		Message    string       `json:"errorMessage"`
+		Type       string       `json:"errorType"`
+		StackTrace []StackFrame `json:"stackTrace"`
+	}{}
+	if err := json.Unmarshal(payload, &body); err != nil {
+		remoteErr.Message = string(payload)
+		return &remoteErr
+	}
+	remoteErr.Message = body.Message
+	remoteErr.Type = body.Type
+	remoteErr.StackTrace = body.StackTrace
+
+	if body.Type == errorType {
+		var typed Error
+		if err := json.Unmarshal([]byte(body.Message), &typed); err == nil {
+			remoteErr.Err = &typed
+			remoteErr.Message = typed.Message
+		}
+	}
+	return &remoteErr
+}
+
+// newResultError converts an error returned by a handler into a RemoteError, the
+// same way it would be seen by a caller if it went through lambda
+func newResultError(err error) *RemoteError {
+	remoteErr := RemoteError{
+		FunctionError: "Unhandled",
+		Type:          reflectTypeName(err),
+		Message:       err.Error(),
+	}
+	var typed *Error
+	if errors.As(err, &typed) {
+		remoteErr.Type = errorType
+		remoteErr.Message = typed.Message
+		remoteErr.Err = typed
+	}
+	return &remoteErr
+}
+
+func reflectTypeName(value any) string {
+	t := reflect.TypeOf(value)
+	if t.Kind() == reflect.Pointer {
+		t = t.Elem()
+	}
+	return t.Name()
+}
+
+// lambdaError converts errors returned by handlers into what gets sent back through
+// lambda. *Error values are encoded so the caller can rebuild them
+func lambdaError(err error) error {
+	var typed *Error
+	if err == nil || !errors.As(err, &typed) {
+		return err
+	}
+	data, marshalErr := json.Marshal(typed)
+	if marshalErr != nil {
+		return err
+	}
+	return messages.InvokeResponse_Error{
+		Message: string(data),
+		Type:    errorType,
+	}
+}
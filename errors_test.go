@@ -0,0 +1,85 @@
+package elaston
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"reflect"
+	"testing"
+)
+
+func TestRemoteErrorRoundTrip(t *testing.T) {
+	tests := []struct {
+		name    string
+		err     error
+		typ     string
+		message string
+		typed   *Error
+	}{
+		{
+			name:    "plain error",
+			err:     errors.New("failed"),
+			typ:     "errorString",
+			message: "failed",
+		},
+		{
+			name:    "structured error",
+			err:     NewError("not_found", "missing item", map[string]any{"id": "1"}),
+			typ:     errorType,
+			message: "missing item",
+			typed:   NewError("not_found", "missing item", map[string]any{"id": "1"}),
+		},
+		{
+			name:    "wrapped structured error",
+			err:     fmt.Errorf("loading: %w", NewError("not_found", "missing item", nil)),
+			typ:     errorType,
+			message: "missing item",
+			typed:   NewError("not_found", "missing item", nil),
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// What the lambda runtime sends back for the error returned by the handler
+			payload, err := json.Marshal(invokeError(lambdaError(tt.err)))
+			if err != nil {
+				t.Fatal(err)
+			}
+			for _, remoteErr := range []*RemoteError{newRemoteError("Unhandled", payload), newResultError(tt.err)} {
+				if remoteErr.FunctionError != "Unhandled" || remoteErr.Type != tt.typ || remoteErr.Message != tt.message {
+					t.Fatalf("unexpected remote error %+v", remoteErr)
+				}
+				var typed *Error
+				if found := errors.As(remoteErr, &typed); found != (tt.typed != nil) {
+					t.Fatalf("expected a structured error to be found %v, got %v", tt.typed != nil, remoteErr)
+				}
+				if tt.typed != nil && (typed.Code != tt.typed.Code || !reflect.DeepEqual(typed.Details, tt.typed.Details)) {
+					t.Fatalf("expected %+v, got %+v", tt.typed, typed)
+				}
+			}
+		})
+	}
+}
+
+func TestNewRemoteErrorPayloads(t *testing.T) {
+	tests := []struct {
+		name       string
+		payload    string
+		message    string
+		stackDepth int
+	}{
+		{name: "not json", payload: "Task timed out", message: "Task timed out"},
+		{name: "panic", payload: `{"errorMessage":"boom","errorType":"string","stackTrace":[{"path":"main.go","line":3,"label":"main"}]}`, message: "boom", stackDepth: 1},
+		{name: "invalid structured error", payload: `{"errorMessage":"{","errorType":"elaston.Error"}`, message: "{"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			remoteErr := newRemoteError("Unhandled", []byte(tt.payload))
+			if remoteErr.Message != tt.message || len(remoteErr.StackTrace) != tt.stackDepth || remoteErr.Err != nil {
+				t.Fatalf("unexpected remote error %+v", remoteErr)
+			}
+			if errors.Unwrap(remoteErr) != nil {
+				t.Fatal("expected nothing to unwrap without a structured error")
+			}
+		})
+	}
+}
@@ -0,0 +1,107 @@
+package elaston
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"time"
+)
+
+// resultKeyAttribute is the sqs message attribute that tells the runtime to store
+// the handler outcome in the result store under the given key
+const resultKeyAttribute = "elaston-result-key"
+
+var ErrNoResultStore = errors.New("elaston: no result store configured")
+
+// Future gives access to the result of a job submitted with SubmitFuture
+type Future[Out any] struct {
+	// Key is the key under which the result is stored
+	Key       string
+	MessageID string
+
+	store    ResultStore
+	security *Security
+}
+
+// Poll checks if the result is available without blocking. The returned bool is
+// false while the job did not finish yet
+func (f *Future[Out]) Poll() (Out, bool, error) {
+	return f.poll(context.Background())
+}
+
+// Wait blocks until the result is available or the context is done
+func (f *Future[Out]) Wait(ctx context.Context) (Out, error) {
+	interval := 250 * time.Millisecond
+	maxInterval := 5 * time.Second
+	for {
+		out, done, err := f.poll(ctx)
+		if done || err != nil {
+			return out, err
+		}
+		select {
+		case <-time.After(interval):
+		case <-ctx.Done():
+			return out, ctx.Err()
+		}
+		interval *= 2
+		if interval > maxInterval {
+			interval = maxInterval
+		}
+	}
+}
+
+func (f *Future[Out]) poll(ctx context.Context) (Out, bool, error) {
+	var out Out
+	result, err := f.store.GetResult(ctx, f.Key)
+	if err != nil || result == nil {
+		return out, false, err
+	}
+	if result, err = openResult(ctx, f.security, result); err != nil {
+		return out, true, err
+	}
+	if result.Error != nil {
+		return out, true, result.Error
+	}
+	codec, err := codecFor(map[string]string{contentTypeHeader: result.ContentType})
+	if err != nil {
+		return out, true, err
+	}
+	if err := decode(codec, result.Output, &out); err != nil {
+		return out, true, err
+	}
+	return out, true, nil
+}
+
+// SubmitFuture is like Submit but also returns a Future for the job result. It
+// requires a ResultStore to be configured on both the client and the runtime. When
+// the runtime has Security configured, results are sealed like payloads and the
+// client needs the same Security to read them
+func (e *Elaston) SubmitFuture(ctx context.Context, in any, options ...Option) (*Future[any], error) {
+	return SubmitFuture[any, any](ctx, e, in, options...)
+}
+
+// SubmitFuture is the type-safe version of Elaston.SubmitFuture
+func SubmitFuture[In any, Out any](ctx context.Context, elaston *Elaston, in In, options ...Option) (*Future[Out], error) {
+	elaston = elaston.with(options)
+	if elaston.results == nil {
+		return nil, ErrNoResultStore
+	}
+	key, err := newID()
+	if err != nil {
+		return nil, err
+	}
+	messageID, err := elaston.send(ctx, in, map[string]string{resultKeyAttribute: key}, 0)
+	if err != nil {
+		return nil, err
+	}
+	return &Future[Out]{Key: key, MessageID: messageID, store: elaston.results, security: elaston.security}, nil
+}
+
+func newID() (string, error) {
+	buf := make([]byte, 16)
+	if _, err := rand.Read(buf); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(buf), nil
+}
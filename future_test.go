@@ -0,0 +1,101 @@
+package elaston
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/bcap/elaston/elastontest"
+)
+
+func TestFuture(t *testing.T) {
+	security := &Security{Keys: NewStaticKeyProvider("key", []byte("0123456789abcdef")), Encrypt: true}
+	tests := []struct {
+		name            string
+		s3              bool
+		clientSecurity  *Security
+		runtimeSecurity *Security
+		handlerErr      error
+		code            string
+		err             error
+	}{
+		{name: "output"},
+		{name: "handler error", handlerErr: NewError("failed", "handler failed", nil), code: "failed"},
+		{name: "s3 store", s3: true},
+		{name: "sealed", s3: true, clientSecurity: security, runtimeSecurity: security},
+		{name: "sealed error", clientSecurity: security, runtimeSecurity: security, handlerErr: NewError("failed", "secret output", nil), code: "failed"},
+		{name: "unsealed result", clientSecurity: &Security{Keys: security.Keys}, err: ErrUnsigned},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := context.Background()
+			fakes := elastontest.New()
+			var store ResultStore = NewMemoryResultStore()
+			if tt.s3 {
+				store = NewS3ResultStore(fakes.AWS(), "bucket", "results/")
+			}
+			withSecurity := func(security *Security) []Option {
+				options := []Option{WithResultStore(store)}
+				if security != nil {
+					options = append(options, WithSecurity(*security))
+				}
+				return options
+			}
+
+			client := newTestClient(fakes, withSecurity(tt.clientSecurity)...)
+			future, err := SubmitFuture[string, string](ctx, client, "input")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if _, done, err := future.Poll(); done || err != nil {
+				t.Fatalf("expected the future to be pending, got done %v and error %v", done, err)
+			}
+
+			runtime := newTestClient(fakes, withSecurity(tt.runtimeSecurity)...)
+			handler, _ := recordingHandler("secret output", tt.handlerErr)
+			if err := handleSQSMessage(ctx, runtime, handler, sentMessages(t, fakes)[0]); err != nil {
+				t.Fatal(err)
+			}
+			if tt.s3 {
+				data, _ := fakes.S3.Object("bucket", "results/"+future.Key)
+				var stored Result
+				if err := json.Unmarshal(data, &stored); err != nil {
+					t.Fatal(err)
+				}
+				sealed := stored.Output == nil && !strings.Contains(string(stored.Sealed), "secret output")
+				if sealed != (tt.runtimeSecurity != nil) {
+					t.Fatalf("expected the stored result to be sealed %v, got %s", tt.runtimeSecurity != nil, data)
+				}
+			}
+
+			ctx, cancel := context.WithTimeout(ctx, time.Second)
+			defer cancel()
+			out, err := future.Wait(ctx)
+			var remoteErr *RemoteError
+			switch {
+			case tt.err != nil:
+				if !errors.Is(err, tt.err) {
+					t.Fatalf("expected error %v, got %v", tt.err, err)
+				}
+			case tt.code != "":
+				if !errors.As(err, &remoteErr) || remoteErr.Err == nil || remoteErr.Err.Code != tt.code {
+					t.Fatalf("expected a remote error with code %s, got %v", tt.code, err)
+				}
+			case err != nil:
+				t.Fatal(err)
+			case out != "secret output":
+				t.Fatalf("unexpected output %q", out)
+			}
+		})
+	}
+}
+
+func TestSubmitFutureWithoutStore(t *testing.T) {
+	client := newTestClient(elastontest.New())
+	if _, err := client.SubmitFuture(context.Background(), "input"); !errors.Is(err, ErrNoResultStore) {
+		t.Fatalf("expected ErrNoResultStore, got %v", err)
+	}
+}
@@ -0,0 +1,247 @@
+package elaston
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math/rand"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/bcap/elaston/aws"
+)
+
+const (
+	rootJobIDHeader = "elaston-root-job-id"
+	depthHeader     = "elaston-depth"
+)
+
+// Limits guard against handlers that call or submit jobs recursively without end.
+// They are enforced on the client side, before anything reaches aws, for calls and
+// submissions made with a context carrying job Metadata, ie from inside handlers
+type Limits struct {
+	// MaxDepth is the maximum length of a chain of jobs, the root job having depth
+	// 0. Zero disables the check
+	MaxDepth int
+	// MaxDescendants is the maximum number of jobs a root job can spawn, directly or
+	// indirectly. Zero disables the check. Requires Counter
+	MaxDescendants int64
+	Counter        DescendantCounter
+}
+
+var DefaultLimits = Limits{MaxDepth: 32}
+
+// DescendantCounter keeps track of how many jobs were spawned under each root job
+type DescendantCounter interface {
+	// Add atomically registers n new descendants of the root job and returns the
+	// updated count
+	Add(ctx context.Context, rootJobID string, n int64) (int64, error)
+}
+
+// LimitError is returned when a call or submission would go over the configured Limits
+type LimitError struct {
+	RootJobID      string
+	Depth          int
+	MaxDepth       int
+	Descendants    int64
+	MaxDescendants int64
+}
+
+func (e *LimitError) Error() string {
+	if e.MaxDescendants > 0 && e.Descendants > e.MaxDescendants {
+		return fmt.Sprintf(
+			"elaston: root job %s would go over the limit of %d descendant jobs",
+			e.RootJobID, e.MaxDescendants,
+		)
+	}
+	return fmt.Sprintf(
+		"elaston: job chain under root job %s would reach depth %d, over the limit of %d",
+		e.RootJobID, e.Depth, e.MaxDepth,
+	)
+}
+
+// WithLimits sets the recursion and fan-out limits
+func WithLimits(limits Limits) Option {
+	return func(e *elaston) {
+		e.limits = limits
+	}
+}
+
+// guard checks whether a call or submission made with ctx stays within the depth
+// limit. Descendants are counted separately by countDescendants, once the jobs are
+// about to be sent
+func (e *Elaston) guard(ctx context.Context) error {
+	md, ok := MetadataFromContext(ctx)
+	if !ok {
+		return nil
+	}
+
+	limitErr := LimitError{
+		RootJobID: md.RootJobID,
+		Depth:     md.Depth + 1,
+		MaxDepth:  e.limits.MaxDepth,
+	}
+	if e.limits.MaxDepth > 0 && limitErr.Depth > e.limits.MaxDepth {
+		e.logf("rejecting job from %s: %v", md.JobID, &limitErr)
+		return &limitErr
+	}
+	return nil
+}
+
+// countDescendants registers n new jobs spawned with ctx, checking they keep the
+// root job within its descendant limit. Jobs buffered in an outbox are only counted
+// when it is flushed, so failed attempts of a job do not count. Jobs that end up not
+// being sent are taken back with uncountDescendants
+func (e *Elaston) countDescendants(ctx context.Context, n int) error {
+	md, ok := e.descendantRoot(ctx, n)
+	if !ok {
+		return nil
+	}
+
+	limitErr := LimitError{
+		RootJobID:      md.RootJobID,
+		Depth:          md.Depth + 1,
+		MaxDepth:       e.limits.MaxDepth,
+		MaxDescendants: e.limits.MaxDescendants,
+	}
+	var err error
+	if limitErr.Descendants, err = e.limits.Counter.Add(ctx, md.RootJobID, int64(n)); err != nil {
+		return err
+	}
+	if limitErr.Descendants > e.limits.MaxDescendants {
+		e.logf("rejecting job from %s: %v", md.JobID, &limitErr)
+		e.uncountDescendants(ctx, n)
+		return &limitErr
+	}
+	return nil
+}
+
+// uncountDescendants takes back n jobs registered by countDescendants that were not
+// sent after all
+func (e *Elaston) uncountDescendants(ctx context.Context, n int) {
+	md, ok := e.descendantRoot(ctx, n)
+	if !ok {
+		return
+	}
+	if _, err := e.limits.Counter.Add(ctx, md.RootJobID, -int64(n)); err != nil {
+		e.logf("failed to take back %d descendants of root job %s: %v", n, md.RootJobID, err)
+	}
+}
+
+// descendantRoot returns the metadata of the job spawning n jobs with ctx, if they
+// are to be counted
+func (e *Elaston) descendantRoot(ctx context.Context, n int) (Metadata, bool) {
+	md, ok := MetadataFromContext(ctx)
+	if !ok || n == 0 {
+		return md, false
+	}
+	if e.limits.MaxDescendants <= 0 || e.limits.Counter == nil || md.RootJobID == "" {
+		return md, false
+	}
+	return md, true
+}
+
+// lineageHeaders returns the headers placing a new job under the job in ctx
+func lineageHeaders(ctx context.Context) map[string]string {
+	md, ok := MetadataFromContext(ctx)
+	if !ok || md.RootJobID == "" {
+		return nil
+	}
+	return map[string]string{
+		rootJobIDHeader: md.RootJobID,
+		depthHeader:     strconv.Itoa(md.Depth + 1),
+	}
+}
+
+// restoreLineage fills the lineage of the job being handled. Jobs without lineage
+// headers are roots of a new chain
+func restoreLineage(md *Metadata, headers map[string]string) error {
+	md.RootJobID = headers[rootJobIDHeader]
+	if md.RootJobID == "" {
+		md.RootJobID = md.JobID
+		return nil
+	}
+	depth, err := strconv.Atoi(headers[depthHeader])
+	if err != nil {
+		return fmt.Errorf("invalid %s header: %w", depthHeader, err)
+	}
+	md.Depth = depth
+	return nil
+}
+
+//
+// Counters
+//
+
+// MemoryDescendantCounter counts descendants in memory. It only sees the jobs spawned
+// from the current process, so it is meant for tests and local execution
+type MemoryDescendantCounter struct {
+	counts map[string]int64
+	mutex  sync.Mutex
+}
+
+func NewMemoryDescendantCounter() *MemoryDescendantCounter {
+	return &MemoryDescendantCounter{counts: map[string]int64{}}
+}
+
+func (c *MemoryDescendantCounter) Add(ctx context.Context, rootJobID string, n int64) (int64, error) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	c.counts[rootJobID] += n
+	return c.counts[rootJobID], nil
+}
+
+// S3DescendantCounter keeps the count of each root job in an s3 object, updated
+// with conditional writes so concurrent spawns never lose each other's updates
+type S3DescendantCounter struct {
+	aws    *aws.AWS
+	bucket string
+	prefix string
+	// MaxAttempts bounds how many times an update is retried when it races with
+	// another one. Defaults to 20
+	MaxAttempts int
+}
+
+func NewS3DescendantCounter(aws *aws.AWS, bucket string, prefix string) *S3DescendantCounter {
+	return &S3DescendantCounter{aws: aws, bucket: bucket, prefix: prefix, MaxAttempts: 20}
+}
+
+func (c *S3DescendantCounter) Add(ctx context.Context, rootJobID string, n int64) (int64, error) {
+	key := c.prefix + rootJobID
+	maxAttempts := c.MaxAttempts
+	if maxAttempts <= 0 {
+		maxAttempts = 20
+	}
+	backoff := 10 * time.Millisecond
+	for attempt := 1; ; attempt++ {
+		data, etag, err := c.aws.GetS3ObjectVersion(ctx, c.bucket, key)
+		if err != nil {
+			return 0, err
+		}
+		var count int64
+		if len(data) > 0 {
+			if count, err = strconv.ParseInt(string(data), 10, 64); err != nil {
+				return 0, fmt.Errorf("invalid descendant count in s3://%s/%s: %w", c.bucket, key, err)
+			}
+		}
+		count += n
+		err = c.aws.PutS3ObjectIfMatch(ctx, c.bucket, key, []byte(strconv.FormatInt(count, 10)), etag)
+		if err == nil {
+			return count, nil
+		}
+		if !errors.Is(err, aws.ErrPreconditionFailed) || attempt >= maxAttempts {
+			return 0, err
+		}
+		// Lost the race against another update, read the new count and try again
+		wait := backoff/2 + time.Duration(rand.Int63n(int64(backoff)))
+		select {
+		case <-ctx.Done():
+			return 0, ctx.Err()
+		case <-time.After(wait):
+		}
+		if backoff < time.Second {
+			backoff *= 2
+		}
+	}
+}
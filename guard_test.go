@@ -0,0 +1,162 @@
+package elaston
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/service/lambda"
+	"github.com/aws/smithy-go"
+
+	"github.com/bcap/elaston/elastontest"
+)
+
+func TestS3DescendantCounterConcurrentAdds(t *testing.T) {
+	ctx := context.Background()
+	fakes := elastontest.New()
+	counter := NewS3DescendantCounter(fakes.AWS(), "bucket", "lineage/")
+	counter.MaxAttempts = 1000
+
+	const workers, adds = 8, 5
+	counts := make(chan int64, workers*adds)
+	wg := sync.WaitGroup{}
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < adds; j++ {
+				count, err := counter.Add(ctx, "root", 1)
+				if err != nil {
+					t.Error(err)
+					return
+				}
+				counts <- count
+			}
+		}()
+	}
+	wg.Wait()
+	close(counts)
+
+	// Every add sees a different count, as none of them is lost
+	seen := map[int64]bool{}
+	for count := range counts {
+		if seen[count] {
+			t.Fatalf("count %d returned twice", count)
+		}
+		seen[count] = true
+	}
+	data, _ := fakes.S3.Object("bucket", "lineage/root")
+	if string(data) != fmt.Sprint(workers*adds) {
+		t.Fatalf("expected a final count of %d, got %s", workers*adds, data)
+	}
+}
+
+func TestDescendantLimit(t *testing.T) {
+	tests := []struct {
+		name       string
+		submits    int
+		handlerErr error
+		sendErr    error
+		limited    bool
+		sent       int
+		counted    string
+	}{
+		{name: "within the limit", submits: 3, sent: 3, counted: "3"},
+		// Rejected and unsent submissions are taken back
+		{name: "over the limit", submits: 4, limited: true, counted: "0"},
+		{name: "failed attempt", submits: 2, handlerErr: errors.New("failed"), counted: ""},
+		{name: "failed flush", submits: 2, sendErr: invalidParameter, counted: "0"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := context.Background()
+			fakes := elastontest.New()
+			if _, err := newTestClient(fakes).Submit(ctx, "root"); err != nil {
+				t.Fatal(err)
+			}
+			root := sentMessages(t, fakes)[0]
+			fakes.SQS.Reset()
+			if tt.sendErr != nil {
+				fakes.SQS.Fail("SendMessageBatch", tt.sendErr)
+			}
+
+			limits := Limits{MaxDescendants: 3, Counter: NewS3DescendantCounter(fakes.AWS(), "bucket", "lineage/")}
+			runtime := newTestClient(fakes, WithLimits(limits))
+			handler := HandlerFunc(func(ctx context.Context, e *Elaston, in any) (any, error) {
+				for i := 0; i < tt.submits; i++ {
+					if _, err := e.Submit(ctx, i); err != nil {
+						return nil, err
+					}
+				}
+				return nil, tt.handlerErr
+			})
+
+			err := handleSQSMessage(ctx, runtime, handler, root)
+			if (err != nil) != (tt.limited || tt.handlerErr != nil || tt.sendErr != nil) {
+				t.Fatalf("unexpected error %v", err)
+			}
+			var limitErr *LimitError
+			if tt.limited != errors.As(err, &limitErr) {
+				t.Fatalf("expected limited to be %v, got error %v", tt.limited, err)
+			}
+			if sent := len(sentMessages(t, fakes)); sent != tt.sent {
+				t.Fatalf("expected %d messages sent, got %d", tt.sent, sent)
+			}
+			// Only flushed submissions count
+			data, _ := fakes.S3.Object("bucket", "lineage/"+root.MessageId)
+			if string(data) != tt.counted {
+				t.Fatalf("expected the count to be %q, got %q", tt.counted, data)
+			}
+		})
+	}
+}
+
+// invalidParameter is a client fault, which is never retried
+var invalidParameter = &smithy.GenericAPIError{Code: "InvalidParameterValue", Fault: smithy.FaultClient}
+
+func TestDescendantsCountedOnceSent(t *testing.T) {
+	functionError := func(any) (any, error) {
+		unhandled := "Unhandled"
+		return &lambda.InvokeOutput{StatusCode: 200, FunctionError: &unhandled, Payload: []byte(`{"errorMessage":"failed"}`)}, nil
+	}
+	tests := []struct {
+		name    string
+		call    bool
+		fail    func(*elastontest.Fakes)
+		counted int64
+	}{
+		{name: "submitted", counted: 1},
+		{name: "submit failed", fail: func(f *elastontest.Fakes) { f.SQS.Fail("SendMessage", invalidParameter) }},
+		{name: "called", call: true, counted: 1},
+		// The function ran, or may have, so the job counts
+		{name: "function error", call: true, fail: func(f *elastontest.Fakes) { f.Lambda.Script("Invoke", functionError) }, counted: 1},
+		{name: "ambiguous call", call: true, fail: func(f *elastontest.Fakes) { f.Lambda.Fail("Invoke", elastontest.APIError("ServiceException")) }, counted: 1},
+		{name: "call not invoked", call: true, fail: func(f *elastontest.Fakes) { f.Lambda.Fail("Invoke", invalidParameter) }},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := ContextWithMetadata(context.Background(), Metadata{JobID: "job", RootJobID: "root"})
+			fakes := elastontest.New()
+			if tt.fail != nil {
+				tt.fail(fakes)
+			}
+			counter := NewMemoryDescendantCounter()
+			client := newTestClient(fakes, WithLimits(Limits{MaxDescendants: 10, Counter: counter}))
+
+			var err error
+			if tt.call {
+				_, err = client.Call(ctx, "input")
+			} else {
+				_, err = client.Submit(ctx, "input")
+			}
+			if (err != nil) != (tt.fail != nil) {
+				t.Fatalf("unexpected error %v", err)
+			}
+			if count, _ := counter.Add(ctx, "root", 0); count != tt.counted {
+				t.Fatalf("expected %d descendants counted, got %d", tt.counted, count)
+			}
+		})
+	}
+}
@@ -0,0 +1,83 @@
+package elaston
+
+import (
+	"context"
+	"io"
+	"log"
+	"testing"
+
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/aws/aws-sdk-go-v2/service/sqs"
+	sqsT "github.com/aws/aws-sdk-go-v2/service/sqs/types"
+
+	"github.com/bcap/elaston/elastontest"
+)
+
+const testQueueURL = "https://sqs.us-east-1.amazonaws.com/000000000000/queue"
+
+// newTestClient returns a client backed by the fakes that does not log and retries
+// without waiting
+func newTestClient(fakes *elastontest.Fakes, options ...Option) *Elaston {
+	return newTestQueueClient(fakes, testQueueURL, options...)
+}
+
+// newTestQueueClient is newTestClient for a specific queue, eg a fifo one
+func newTestQueueClient(fakes *elastontest.Fakes, queueURL string, options ...Option) *Elaston {
+	defaults := []Option{
+		WithLogger(log.New(io.Discard, "", 0)),
+		WithRetryPolicy(RetryPolicy{MaxAttempts: 3}),
+	}
+	return New(fakes.AWS(), "function", queueURL, append(defaults, options...)...)
+}
+
+// sentMessages returns every message sent to the fake sqs so far, as the events the
+// function would receive them in
+func sentMessages(t *testing.T, fakes *elastontest.Fakes) []*events.SQSMessage {
+	t.Helper()
+	msgs := []*events.SQSMessage{}
+	for _, call := range fakes.SQS.Calls() {
+		if call.Err != nil {
+			continue
+		}
+		switch input := call.Input.(type) {
+		case *sqs.SendMessageInput:
+			output := call.Output.(*sqs.SendMessageOutput)
+			msgs = append(msgs, sqsEvent(*output.MessageId, *input.MessageBody, input.MessageAttributes))
+		case *sqs.SendMessageBatchInput:
+			output := call.Output.(*sqs.SendMessageBatchOutput)
+			ids := map[string]string{}
+			for _, entry := range output.Successful {
+				ids[*entry.Id] = *entry.MessageId
+			}
+			for _, entry := range input.Entries {
+				if id, ok := ids[*entry.Id]; ok {
+					msgs = append(msgs, sqsEvent(id, *entry.MessageBody, entry.MessageAttributes))
+				}
+			}
+		}
+	}
+	return msgs
+}
+
+func sqsEvent(id string, body string, attributes map[string]sqsT.MessageAttributeValue) *events.SQSMessage {
+	msg := events.SQSMessage{
+		MessageId:         id,
+		Body:              body,
+		Attributes:        map[string]string{"ApproximateReceiveCount": "1"},
+		MessageAttributes: map[string]events.SQSMessageAttribute{},
+	}
+	for key, value := range attributes {
+		msg.MessageAttributes[key] = events.SQSMessageAttribute{StringValue: value.StringValue, DataType: *value.DataType}
+	}
+	return &msg
+}
+
+// recordingHandler returns a handler that sends every input it receives to the
+// returned channel and answers with the output
+func recordingHandler(output any, err error) (Handler, <-chan any) {
+	inputs := make(chan any, 100)
+	return HandlerFunc(func(ctx context.Context, e *Elaston, in any) (any, error) {
+		inputs <- in
+		return output, err
+	}), inputs
+}
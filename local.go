@@ -0,0 +1,514 @@
+package elaston
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/aws/aws-lambda-go/lambda/messages"
+	"github.com/aws/aws-lambda-go/lambdacontext"
+	"github.com/aws/aws-sdk-go-v2/service/lambda"
+	lambdaT "github.com/aws/aws-sdk-go-v2/service/lambda/types"
+
+	"github.com/bcap/elaston/aws"
+)
+
+const (
+	localFunctionName = "elaston-local"
+	localQueueURL     = "local://elaston-local"
+)
+
+var errLocalUnsupported = errors.New("not supported by the local backend")
+
+// Local runs a handler in-process, emulating lambda and sqs, so handlers can be
+// exercised in tests and during development without an aws account. Clients
+// returned by Client work as if they were talking to a deployed function: Call runs
+// the handler synchronously and Submit enqueues to an in-memory queue drained by
+// worker goroutines, with visibility timeouts, retries and a dead-letter queue.
+// Both go through the same runtime code as a deployed function. NewLocalProcess runs
+// a compiled binary instead of an in-process handler
+type Local struct {
+	handler Handler
+	// processes, when set, runs invocations in subprocesses of a binary instead of
+	// calling handler in-process
+	processes *processBackend
+	runtime   *Elaston
+	results   *MemoryResultStore
+	options   localOptions
+
+	// messages holds every message not deleted yet, in the order they were sent,
+	// including the ones being processed
+	messages    []*localMessage
+	deadLetters []events.SQSMessage
+	invocations sync.WaitGroup
+	mutex       sync.Mutex
+	notify      chan struct{}
+
+	cancel  context.CancelFunc
+	workers sync.WaitGroup
+}
+
+type localMessage struct {
+	id           string
+	body         string
+	attributes   map[string]string
+	groupID      string
+	sentAt       time.Time
+	visibleAt    time.Time
+	receiveCount int
+}
+
+type LocalOption func(*localOptions)
+
+type localOptions struct {
+	workers           int
+	batchSize         int
+	visibilityTimeout time.Duration
+	maxReceiveCount   int
+	runtimeOptions    []Option
+	environment       map[string]string
+}
+
+// WithWorkers sets how many worker goroutines process the queue, emulating
+// concurrent lambda invocations. Defaults to 1
+func WithWorkers(workers int) LocalOption {
+	return func(o *localOptions) {
+		o.workers = workers
+	}
+}
+
+// WithLocalBatchSize sets the maximum number of messages delivered to a single
+// invocation. Defaults to 1
+func WithLocalBatchSize(size int) LocalOption {
+	return func(o *localOptions) {
+		o.batchSize = size
+	}
+}
+
+// WithVisibilityTimeout sets for how long received messages are hidden, after which
+// the ones not processed successfully are delivered again. It is also the timeout
+// of every invocation. Defaults to 30 seconds
+func WithVisibilityTimeout(timeout time.Duration) LocalOption {
+	return func(o *localOptions) {
+		o.visibilityTimeout = timeout
+	}
+}
+
+// WithMaxReceiveCount sets how many times a message is received before it is moved
+// to the dead-letter queue. Defaults to 5
+func WithMaxReceiveCount(count int) LocalOption {
+	return func(o *localOptions) {
+		o.maxReceiveCount = count
+	}
+}
+
+// WithRuntimeOptions configures the elaston the handler receives, as the environment
+// variables set by deploy would do for a deployed function
+func WithRuntimeOptions(options ...Option) LocalOption {
+	return func(o *localOptions) {
+		o.runtimeOptions = append(o.runtimeOptions, options...)
+	}
+}
+
+// WithEnvironment sets extra environment variables for the binary run by
+// NewLocalProcess, eg ELASTON_SQS_QUEUE_URL and aws credentials so that submits made
+// by its handler reach a real queue
+func WithEnvironment(environment map[string]string) LocalOption {
+	return func(o *localOptions) {
+		if o.environment == nil {
+			o.environment = map[string]string{}
+		}
+		for key, value := range environment {
+			o.environment[key] = value
+		}
+	}
+}
+
+// NewLocal starts the local backend for the handler. Close stops it. Runtime options
+// needing aws, like offloading payloads or s3 stores, are rejected, as there is no aws
+// account behind the local backend. Their in-memory equivalents are used by default,
+// and long SubmitAfter delays are kept in the local queue instead of being scheduled
+func NewLocal(handler Handler, options ...LocalOption) (*Local, error) {
+	local, err := newLocal(handler, options)
+	if err != nil {
+		return nil, err
+	}
+	local.start()
+	return local, nil
+}
+
+// NewLocalProcess starts a local backend running the given executable, a binary
+// built with elaston, exactly as lambda would: with ELASTON_RUNNING_ON_LAMBDA set and
+// talking to a local emulation of the lambda runtime api. Call and Submit on its
+// clients are delivered to the binary, so packaging and environment problems show up
+// before deploying. There is one subprocess per worker, restarted whenever it exits.
+//
+// Submits made by the handler inside the binary go back to the local queue, through
+// an emulation of the sqs api the binary is pointed at. Setting ELASTON_SQS_QUEUE_URL
+// with WithEnvironment sends them to that queue instead. Runtime options do not
+// apply, the binary configures itself from its environment
+func NewLocalProcess(executable string, options ...LocalOption) (*Local, error) {
+	local, err := newLocal(nil, options)
+	if err != nil {
+		return nil, err
+	}
+	processes := local.options.workers
+	if processes < 1 {
+		processes = 1
+	}
+	environment := map[string]string{
+		"ELASTON_MAX_RECEIVE_COUNT": strconv.Itoa(local.options.maxReceiveCount),
+	}
+	for key, value := range local.options.environment {
+		environment[key] = value
+	}
+	send := local.send
+	if _, ok := environment["ELASTON_SQS_QUEUE_URL"]; ok {
+		send = nil
+	}
+	backend, err := newProcessBackend(executable, environment, processes, send, local.runtime.logf)
+	if err != nil {
+		return nil, err
+	}
+	local.processes = backend
+	local.start()
+	return local, nil
+}
+
+func newLocal(handler Handler, options []LocalOption) (*Local, error) {
+	opts := localOptions{
+		workers:           1,
+		batchSize:         1,
+		visibilityTimeout: 30 * time.Second,
+		maxReceiveCount:   5,
+	}
+	for _, opt := range options {
+		opt(&opts)
+	}
+
+	local := &Local{
+		handler: handler,
+		results: NewMemoryResultStore(),
+		options: opts,
+		notify:  make(chan struct{}, 1),
+	}
+	runtimeOptions := append([]Option{
+		WithResultStore(local.results),
+		WithDedupStore(NewMemoryDedupStore()),
+		WithQueueMaxReceiveCount(opts.maxReceiveCount),
+	}, opts.runtimeOptions...)
+	local.runtime = local.Client(runtimeOptions...)
+	if err := unsupportedLocally(local.runtime); err != nil {
+		return nil, err
+	}
+	return local, nil
+}
+
+// unsupportedLocally returns an error if the client is configured with anything that
+// needs aws, which the local backend does not have
+func unsupportedLocally(e *Elaston) error {
+	if e.offloadBucket != "" {
+		return fmt.Errorf("offloading payloads to s3 is %w", errLocalUnsupported)
+	}
+	if _, ok := e.results.(*S3ResultStore); ok {
+		return fmt.Errorf("S3ResultStore is %w, use a MemoryResultStore", errLocalUnsupported)
+	}
+	if _, ok := e.dedup.(*S3DedupStore); ok {
+		return fmt.Errorf("S3DedupStore is %w, use a MemoryDedupStore", errLocalUnsupported)
+	}
+	if _, ok := e.limits.Counter.(*S3DescendantCounter); ok {
+		return fmt.Errorf("S3DescendantCounter is %w, use a MemoryDescendantCounter", errLocalUnsupported)
+	}
+	return nil
+}
+
+// start launches the workers
+func (l *Local) start() {
+	ctx, cancel := context.WithCancel(context.Background())
+	l.cancel = cancel
+	for i := 0; i < l.options.workers; i++ {
+		l.workers.Add(1)
+		go func() {
+			defer l.workers.Done()
+			l.work(ctx)
+		}()
+	}
+}
+
+// Client returns a client for the local function. Results of SubmitFuture are kept
+// in memory unless a result store is given
+func (l *Local) Client(options ...Option) *Elaston {
+	options = append([]Option{WithResultStore(l.results)}, options...)
+	client := New(nil, localFunctionName, localQueueURL, options...)
+	client.local = l
+	return client
+}
+
+// Close stops the workers, waiting for the invocations in progress. Subprocesses
+// started by NewLocalProcess are killed afterwards
+func (l *Local) Close() {
+	l.cancel()
+	l.workers.Wait()
+	l.invocations.Wait()
+	if l.processes != nil {
+		l.processes.close()
+	}
+}
+
+// Drain waits until every message sent so far, and the ones sent while processing
+// them, is either processed or moved to the dead-letter queue. Delayed messages are
+// waited for as well
+func (l *Local) Drain(ctx context.Context) error {
+	for {
+		l.mutex.Lock()
+		pending := len(l.messages)
+		l.mutex.Unlock()
+		if pending == 0 {
+			l.invocations.Wait()
+			l.mutex.Lock()
+			pending = len(l.messages)
+			l.mutex.Unlock()
+			if pending == 0 {
+				return nil
+			}
+		}
+		select {
+		case <-time.After(5 * time.Millisecond):
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
+
+// DeadLetters returns the messages moved to the dead-letter queue so far
+func (l *Local) DeadLetters() []events.SQSMessage {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	return append([]events.SQSMessage{}, l.deadLetters...)
+}
+
+// invoke emulates lambda.Invoke, running the handler in-process
+func (l *Local) invoke(ctx context.Context, input *lambda.InvokeInput) (*lambda.InvokeOutput, error) {
+	requestID, err := newID()
+	if err != nil {
+		return nil, err
+	}
+	switch input.InvocationType {
+	case lambdaT.InvocationTypeDryRun:
+		return &lambda.InvokeOutput{StatusCode: 204}, nil
+	case lambdaT.InvocationTypeEvent:
+		l.invocations.Add(1)
+		go func() {
+			defer l.invocations.Done()
+			if _, err := l.run(context.Background(), requestID, input.Payload); err != nil {
+				l.runtime.logf("local async invocation %s failed: %v", requestID, err)
+			}
+		}()
+		return &lambda.InvokeOutput{StatusCode: 202}, nil
+	}
+
+	out := lambda.InvokeOutput{StatusCode: 200}
+	payload, err := l.run(ctx, requestID, input.Payload)
+	if err != nil {
+		functionError := "Unhandled"
+		out.FunctionError = &functionError
+		payload, err = json.Marshal(invokeError(err))
+		if err != nil {
+			return nil, err
+		}
+	}
+	out.Payload = payload
+	return &out, nil
+}
+
+// run invokes the lambda handler the same way the lambda go runtime does, returning
+// the json encoded response
+func (l *Local) run(ctx context.Context, requestID string, payload []byte) (_ []byte, err error) {
+	if l.processes != nil {
+		return l.processes.invoke(ctx, requestID, payload, l.options.visibilityTimeout)
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, l.options.visibilityTimeout)
+	defer cancel()
+	ctx = lambdacontext.NewContext(ctx, &lambdacontext.LambdaContext{
+		AwsRequestID:       requestID,
+		InvokedFunctionArn: "arn:aws:lambda:local:000000000000:function:" + localFunctionName,
+	})
+
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("handler panicked: %v", r)
+		}
+	}()
+	out, err := lambdaHandler(l.runtime, l.handler)(ctx, payload)
+	if err != nil {
+		return nil, err
+	}
+	return json.Marshal(out)
+}
+
+// invokeError is how the lambda go runtime reports errors returned by handlers. Like
+// the runtime, it type asserts the error instead of unwrapping it
+func invokeError(err error) messages.InvokeResponse_Error {
+	if invokeErr, ok := err.(messages.InvokeResponse_Error); ok {
+		return invokeErr
+	}
+	return messages.InvokeResponse_Error{Message: err.Error(), Type: reflectTypeName(err)}
+}
+
+// send emulates sqs.SendMessage
+func (l *Local) send(body string, attributes map[string]string, options aws.SendOptions) (string, error) {
+	id, err := newID()
+	if err != nil {
+		return "", err
+	}
+	now := time.Now()
+	l.mutex.Lock()
+	l.messages = append(l.messages, &localMessage{
+		id:         id,
+		body:       body,
+		attributes: attributes,
+		groupID:    options.GroupID,
+		sentAt:     now,
+		visibleAt:  now.Add(time.Duration(options.DelaySeconds) * time.Second),
+	})
+	l.mutex.Unlock()
+
+	select {
+	case l.notify <- struct{}{}:
+	default:
+	}
+	return id, nil
+}
+
+// work delivers batches of visible messages to the handler until the context is done
+func (l *Local) work(ctx context.Context) {
+	for {
+		batch := l.receive()
+		if len(batch) == 0 {
+			select {
+			case <-l.notify:
+			case <-time.After(10 * time.Millisecond):
+			case <-ctx.Done():
+				return
+			}
+			continue
+		}
+		l.process(ctx, batch)
+	}
+}
+
+// receive takes up to batch size visible messages, hiding them for the visibility
+// timeout. Messages received too many times are moved to the dead-letter queue
+// instead. As in fifo queues, a message group is blocked for as long as any of its
+// messages is hidden, either in flight or waiting to be retried, so that messages
+// are never handled before the ones sent earlier in their group
+func (l *Local) receive() []*localMessage {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	now := time.Now()
+	batch := []*localMessage{}
+	blocked := map[string]bool{}
+	kept := l.messages[:0]
+	for _, msg := range l.messages {
+		receivable := len(batch) < l.options.batchSize && !msg.visibleAt.After(now) && !blocked[msg.groupID]
+		if receivable && l.options.maxReceiveCount > 0 && msg.receiveCount >= l.options.maxReceiveCount {
+			l.deadLetters = append(l.deadLetters, msg.event())
+			continue
+		}
+		kept = append(kept, msg)
+		if !receivable {
+			if msg.groupID != "" {
+				blocked[msg.groupID] = true
+			}
+			continue
+		}
+		msg.receiveCount++
+		msg.visibleAt = now.Add(l.options.visibilityTimeout)
+		batch = append(batch, msg)
+	}
+	for i := len(kept); i < len(l.messages); i++ {
+		l.messages[i] = nil
+	}
+	l.messages = kept
+	return batch
+}
+
+// process invokes the handler with the batch, deleting the messages processed
+// successfully. The failed ones are delivered again once their visibility timeout expires
+func (l *Local) process(ctx context.Context, batch []*localMessage) {
+	event := events.SQSEvent{Records: make([]events.SQSMessage, len(batch))}
+	for i, msg := range batch {
+		event.Records[i] = msg.event()
+	}
+
+	failed := map[string]bool{}
+	payload, err := json.Marshal(event)
+	if err == nil {
+		var response []byte
+		if response, err = l.run(ctx, batch[0].id, payload); err == nil {
+			var batchResponse events.SQSEventResponse
+			if err = json.Unmarshal(response, &batchResponse); err == nil {
+				for _, failure := range batchResponse.BatchItemFailures {
+					failed[failure.ItemIdentifier] = true
+				}
+			}
+		}
+	}
+	if err != nil {
+		l.runtime.logf("local invocation failed for a batch of %d messages: %v", len(batch), err)
+		for _, msg := range batch {
+			failed[msg.id] = true
+		}
+	}
+
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	kept := l.messages[:0]
+	for _, msg := range l.messages {
+		processed := false
+		for _, batchMsg := range batch {
+			if msg == batchMsg && !failed[msg.id] {
+				processed = true
+				break
+			}
+		}
+		if !processed {
+			kept = append(kept, msg)
+		}
+	}
+	for i := len(kept); i < len(l.messages); i++ {
+		l.messages[i] = nil
+	}
+	l.messages = kept
+}
+
+// event converts the message into what lambda delivers for sqs messages
+func (m *localMessage) event() events.SQSMessage {
+	event := events.SQSMessage{
+		MessageId:      m.id,
+		ReceiptHandle:  m.id,
+		Body:           m.body,
+		EventSource:    "aws:sqs",
+		EventSourceARN: "arn:aws:sqs:local:000000000000:elaston-local",
+		Attributes: map[string]string{
+			"ApproximateReceiveCount": strconv.Itoa(m.receiveCount),
+			"SentTimestamp":           strconv.FormatInt(m.sentAt.UnixMilli(), 10),
+		},
+		MessageAttributes: map[string]events.SQSMessageAttribute{},
+	}
+	if m.groupID != "" {
+		event.Attributes["MessageGroupId"] = m.groupID
+	}
+	for key, value := range m.attributes {
+		value := value
+		event.MessageAttributes[key] = events.SQSMessageAttribute{StringValue: &value, DataType: "String"}
+	}
+	return event
+}
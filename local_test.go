@@ -0,0 +1,213 @@
+package elaston
+
+import (
+	"context"
+	"errors"
+	"io"
+	"log"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/bcap/elaston/aws"
+)
+
+// attempts counts the times a handler received each input
+type attempts struct {
+	counts map[int]int
+	mutex  sync.Mutex
+}
+
+func (a *attempts) add(in int) {
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+	if a.counts == nil {
+		a.counts = map[int]int{}
+	}
+	a.counts[in]++
+}
+
+func (a *attempts) get(in int) int {
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+	return a.counts[in]
+}
+
+// countdown is a handler that fails for negative inputs and otherwise submits a job
+// for the input minus one, returning the double of its input
+func countdown(received *attempts) Handler {
+	return TypedFunc(func(ctx context.Context, e *Elaston, in int) (int, error) {
+		received.add(in)
+		if in < 0 {
+			return 0, NewError("negative", "negative input", in)
+		}
+		if in > 0 {
+			if _, err := Submit(ctx, e, in-1); err != nil {
+				return 0, err
+			}
+		}
+		return in * 2, nil
+	})
+}
+
+func newTestLocal(t *testing.T, handler Handler, options ...LocalOption) *Local {
+	t.Helper()
+	defaults := []LocalOption{
+		WithWorkers(2),
+		WithVisibilityTimeout(10 * time.Millisecond),
+		WithMaxReceiveCount(2),
+		WithRuntimeOptions(WithLogger(log.New(io.Discard, "", 0))),
+	}
+	local, err := NewLocal(handler, append(defaults, options...)...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(local.Close)
+	return local
+}
+
+func TestLocalCall(t *testing.T) {
+	tests := []struct {
+		name   string
+		input  int
+		output int
+		code   string
+	}{
+		{name: "output", input: 0, output: 0},
+		{name: "output with submits", input: 3, output: 6},
+		{name: "handler error", input: -1, code: "negative"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			local := newTestLocal(t, countdown(&attempts{}))
+			output, err := Call[int, int](context.Background(), local.Client(WithLogger(log.New(io.Discard, "", 0))), tt.input)
+			if tt.code != "" {
+				var remoteErr *RemoteError
+				if !errors.As(err, &remoteErr) || remoteErr.Err == nil || remoteErr.Err.Code != tt.code {
+					t.Fatalf("expected a remote error with code %s, got %v", tt.code, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if output != tt.output {
+				t.Fatalf("expected output %d, got %d", tt.output, output)
+			}
+		})
+	}
+}
+
+func TestLocalSubmit(t *testing.T) {
+	tests := []struct {
+		name        string
+		input       int
+		processed   []int
+		deadLetters int
+	}{
+		{name: "single job", input: 0, processed: []int{0}},
+		{name: "nested submits", input: 3, processed: []int{3, 2, 1, 0}},
+		{name: "dead letter", input: -1, processed: []int{-1}, deadLetters: 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+			defer cancel()
+			received := attempts{}
+			local := newTestLocal(t, countdown(&received))
+			if _, err := Submit(ctx, local.Client(), tt.input); err != nil {
+				t.Fatal(err)
+			}
+			if err := local.Drain(ctx); err != nil {
+				t.Fatal(err)
+			}
+
+			for _, in := range tt.processed {
+				// Failed jobs are attempted up to the max receive count
+				expected := 1
+				if in < 0 {
+					expected = 2
+				}
+				if got := received.get(in); got != expected {
+					t.Fatalf("expected input %d to be attempted %d times, got %d", in, expected, got)
+				}
+			}
+			if deadLetters := local.DeadLetters(); len(deadLetters) != tt.deadLetters {
+				t.Fatalf("expected %d dead letters, got %d", tt.deadLetters, len(deadLetters))
+			}
+		})
+	}
+}
+
+func TestLocalFIFOGroups(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	received := attempts{}
+	order := make(chan int, 10)
+	// Fails the first attempt of the first message of the group
+	local := newTestLocal(t, TypedFunc(func(ctx context.Context, e *Elaston, in int) (int, error) {
+		received.add(in)
+		order <- in
+		if in == 1 && received.get(in) == 1 {
+			return 0, errors.New("failed")
+		}
+		return in, nil
+	}), WithMaxReceiveCount(3))
+
+	// Messages of other groups are not held back by the failure
+	sends := []struct {
+		in    string
+		group string
+	}{{"1", "a"}, {"2", "a"}, {"3", "b"}}
+	for _, send := range sends {
+		if _, err := local.send(send.in, nil, aws.SendOptions{GroupID: send.group}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := local.Drain(ctx); err != nil {
+		t.Fatal(err)
+	}
+	close(order)
+
+	group := []int{}
+	for in := range order {
+		if in != 3 {
+			group = append(group, in)
+		}
+	}
+	if !reflect.DeepEqual(group, []int{1, 1, 2}) {
+		t.Fatalf("expected the group to be retried in order, got %v", group)
+	}
+	if received.get(3) != 1 {
+		t.Fatalf("expected the message of the other group to be processed once, got %d", received.get(3))
+	}
+}
+
+func TestLocalRejectsAWSOptions(t *testing.T) {
+	tests := []struct {
+		name    string
+		options []Option
+	}{
+		{name: "offload bucket", options: []Option{WithOffloadBucket("bucket")}},
+		{name: "s3 result store", options: []Option{WithResultStore(NewS3ResultStore(nil, "bucket", "results/"))}},
+		{name: "s3 dedup store", options: []Option{WithDedupStore(NewS3DedupStore(nil, "bucket", "dedup/"))}},
+		{name: "s3 descendant counter", options: []Option{WithLimits(Limits{MaxDescendants: 1, Counter: NewS3DescendantCounter(nil, "bucket", "lineage/")})}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := NewLocal(countdown(&attempts{}), WithRuntimeOptions(tt.options...))
+			if !errors.Is(err, errLocalUnsupported) {
+				t.Fatalf("expected the option to be rejected, got %v", err)
+			}
+		})
+	}
+
+	// Clients of the local backend fail instead of offloading
+	local := newTestLocal(t, countdown(&attempts{}))
+	client := local.Client(WithOffloadBucket("bucket"))
+	if _, err := client.Call(context.Background(), strings.Repeat("x", maxInvokePayloadSize)); !errors.Is(err, errLocalUnsupported) {
+		t.Fatalf("expected offloading to be unsupported, got %v", err)
+	}
+}
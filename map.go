@@ -0,0 +1,184 @@
+package elaston
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+)
+
+type ErrorMode int
+
+const (
+	// FailFast stops dispatching new items and returns as soon as one item fails
+	FailFast ErrorMode = iota
+	// CollectAll processes every item and reports all failures at the end
+	CollectAll
+)
+
+type MapOptions struct {
+	// Concurrency is the maximum number of in flight calls. Defaults to 10
+	Concurrency int
+	// Retries is how many times a failed item is retried before giving up. Only
+	// failures the retry policy of the client considers retryable are retried, so
+	// handler errors need RetryFunctionErrors and ambiguous ones RetryAmbiguous
+	Retries int
+	// RetryBackoff is the wait before the first retry, doubled on each further retry.
+	// Defaults to 100ms
+	RetryBackoff time.Duration
+	ErrorMode    ErrorMode
+	// CallOptions are passed to every Call
+	CallOptions []Option
+}
+
+// MapResult is the outcome of calling the function with a single input
+type MapResult[Out any] struct {
+	// Index is the position of the input in the slice passed to Map
+	Index    int
+	Output   Out
+	Err      error
+	Attempts int
+	// Throttled is set when the last attempt failed due to lambda throttling rather
+	// than a handler failure
+	Throttled bool
+	// Cancelled is set when the item was cut short because the context was cancelled,
+	// eg by another item failing with FailFast
+	Cancelled bool
+}
+
+// MapError is returned by Map when one or more items failed
+type MapError struct {
+	// Failed counts items that failed for reasons other than throttling, eg handler errors
+	Failed int
+	// Throttled counts items that failed because lambda throttled the invocation
+	Throttled int
+	// Cancelled counts items that did not run to completion, either cut short or never
+	// dispatched, because the context was cancelled. They are not counted as failed
+	Cancelled int
+	// FirstErr is the first failure seen
+	FirstErr error
+}
+
+func (e *MapError) Error() string {
+	return fmt.Sprintf("map failed for %d items (%d throttled, %d not run): %v", e.Failed+e.Throttled, e.Throttled, e.Cancelled, e.FirstErr)
+}
+
+func (e *MapError) Unwrap() error {
+	return e.FirstErr
+}
+
+// Map calls the function once per input with bounded concurrency, returning results
+// in the same order as the inputs. With FailFast, results for items that were not
+// processed have a zero Attempts
+func Map[In any, Out any](ctx context.Context, elaston *Elaston, inputs []In, opts MapOptions) ([]MapResult[Out], error) {
+	results := make([]MapResult[Out], len(inputs))
+	for i := range results {
+		results[i].Index = i
+	}
+
+	mapErr := MapError{}
+	done := 0
+	for result := range MapStream[In, Out](ctx, elaston, inputs, opts) {
+		results[result.Index] = result
+		done++
+		if result.Err == nil {
+			continue
+		}
+		if result.Cancelled {
+			mapErr.Cancelled++
+			continue
+		}
+		if result.Throttled {
+			mapErr.Throttled++
+		} else {
+			mapErr.Failed++
+		}
+		if mapErr.FirstErr == nil {
+			mapErr.FirstErr = result.Err
+		}
+	}
+
+	// Items never dispatched after a FailFast failure or cancellation
+	mapErr.Cancelled += len(inputs) - done
+
+	if mapErr.FirstErr != nil {
+		return results, &mapErr
+	}
+	if err := ctx.Err(); err != nil {
+		return results, err
+	}
+	return results, nil
+}
+
+// MapStream is like Map but results are sent through the returned channel as soon
+// as they are ready, in completion order. The channel is closed once all items are done
+func MapStream[In any, Out any](ctx context.Context, elaston *Elaston, inputs []In, opts MapOptions) <-chan MapResult[Out] {
+	if opts.Concurrency < 1 {
+		opts.Concurrency = 10
+	}
+	if opts.RetryBackoff <= 0 {
+		opts.RetryBackoff = 100 * time.Millisecond
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	results := make(chan MapResult[Out], opts.Concurrency)
+	semaphore := make(chan struct{}, opts.Concurrency)
+	wg := sync.WaitGroup{}
+
+	go func() {
+		defer func() {
+			wg.Wait()
+			cancel()
+			close(results)
+		}()
+		for i := range inputs {
+			select {
+			case semaphore <- struct{}{}:
+			case <-ctx.Done():
+				return
+			}
+			// Both cases may be ready at once, in which case select picks randomly
+			if ctx.Err() != nil {
+				<-semaphore
+				return
+			}
+			wg.Add(1)
+			go func(i int) {
+				defer func() {
+					<-semaphore
+					wg.Done()
+				}()
+				result := mapItem[In, Out](ctx, elaston, inputs[i], opts)
+				result.Index = i
+				result.Cancelled = result.Err != nil && ctx.Err() != nil && errors.Is(result.Err, context.Canceled)
+				results <- result
+				if result.Err != nil && !result.Cancelled && opts.ErrorMode == FailFast {
+					cancel()
+				}
+			}(i)
+		}
+	}()
+
+	return results
+}
+
+func mapItem[In any, Out any](ctx context.Context, elaston *Elaston, in In, opts MapOptions) MapResult[Out] {
+	result := MapResult[Out]{}
+	backoff := opts.RetryBackoff
+	policy := elaston.with(opts.CallOptions).retryPolicy
+	for {
+		result.Attempts++
+		result.Output, result.Err = Call[In, Out](ctx, elaston, in, opts.CallOptions...)
+		result.Throttled = isThrottle(result.Err)
+		if result.Err == nil || result.Attempts > opts.Retries || ctx.Err() != nil || !policy.retryable(classifyCallError(result.Err)) {
+			return result
+		}
+		select {
+		case <-time.After(backoff):
+		case <-ctx.Done():
+			return result
+		}
+		backoff *= 2
+	}
+}
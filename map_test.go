@@ -0,0 +1,72 @@
+package elaston
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestMap(t *testing.T) {
+	retryFunctionErrors := []Option{WithRetryPolicy(RetryPolicy{MaxAttempts: 1, RetryFunctionErrors: true})}
+	tests := []struct {
+		name        string
+		inputs      []int
+		errorMode   ErrorMode
+		retries     int
+		callOptions []Option
+		failed      int
+		attempts    int
+	}{
+		{name: "all succeed", inputs: []int{0, 2, 4}, attempts: 1},
+		{name: "collect all", inputs: []int{0, -1, 2, -3}, errorMode: CollectAll, failed: 2, attempts: 1},
+		{name: "fail fast", inputs: []int{-1}, errorMode: FailFast, failed: 1, attempts: 1},
+		// Handlers may not be idempotent, so their errors are only retried when the
+		// retry policy allows it
+		{name: "handler errors not retried", inputs: []int{1, 3}, errorMode: CollectAll, retries: 1, failed: 2, attempts: 1},
+		{name: "handler errors retried", inputs: []int{1, 3}, retries: 1, callOptions: retryFunctionErrors, attempts: 2},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// Fails for negative inputs, and on the first attempt of odd ones
+			received := attempts{}
+			handler := TypedFunc(func(ctx context.Context, e *Elaston, in int) (int, error) {
+				received.add(in)
+				if in < 0 || (in%2 == 1 && received.get(in) == 1) {
+					return 0, NewError("failed", "failed", in)
+				}
+				return in * 2, nil
+			})
+			local := newTestLocal(t, handler)
+			opts := MapOptions{ErrorMode: tt.errorMode, Retries: tt.retries, RetryBackoff: time.Millisecond, CallOptions: tt.callOptions}
+			results, err := Map[int, int](context.Background(), local.Client(), tt.inputs, opts)
+
+			failed := 0
+			for i, result := range results {
+				if result.Index != i {
+					t.Fatalf("expected result %d to have index %d, got %d", i, i, result.Index)
+				}
+				if result.Attempts != tt.attempts {
+					t.Fatalf("expected %d attempts for input %d, got %d", tt.attempts, tt.inputs[i], result.Attempts)
+				}
+				if result.Err != nil {
+					failed++
+					continue
+				}
+				if result.Output != tt.inputs[i]*2 {
+					t.Fatalf("expected output %d for input %d, got %d", tt.inputs[i]*2, tt.inputs[i], result.Output)
+				}
+			}
+			if failed != tt.failed {
+				t.Fatalf("expected %d failed items, got %d", tt.failed, failed)
+			}
+			var mapErr *MapError
+			if tt.failed > 0 && (!errors.As(err, &mapErr) || mapErr.Failed != tt.failed) {
+				t.Fatalf("expected a *MapError with %d failures, got %v", tt.failed, err)
+			}
+			if tt.failed == 0 && err != nil {
+				t.Fatal(err)
+			}
+		})
+	}
+}
@@ -0,0 +1,110 @@
+package elaston
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+)
+
+const (
+	// maxSQSMessageSize is the sqs limit for a message body plus its attributes
+	maxSQSMessageSize = 256 * 1024
+	// maxInvokePayloadSize is the lambda limit for synchronous invocations, applied
+	// both to requests and responses
+	maxInvokePayloadSize = 6 * 1024 * 1024
+
+	// offloadHeader is set when the actual payload was stored in s3 and the message
+	// body is an s3Pointer to it
+	offloadHeader = "elaston-offload"
+	// offloadPrefix is where offloaded payloads are stored in the bucket
+	offloadPrefix = "payloads/"
+)
+
+// PayloadTooLargeError is returned when a payload goes over the transport limit and
+// there is no bucket configured to offload it to
+type PayloadTooLargeError struct {
+	Size  int
+	Limit int
+}
+
+func (e *PayloadTooLargeError) Error() string {
+	return fmt.Sprintf("payload of %d bytes goes over the %d bytes limit and no offload bucket is configured", e.Size, e.Limit)
+}
+
+type s3Pointer struct {
+	Bucket string `json:"bucket"`
+	Key    string `json:"key"`
+}
+
+// offload stores the message body in s3 when size is over limit, returning a message
+// that points to it instead
+func (e *Elaston) offload(ctx context.Context, msg message, size int, limit int) (message, error) {
+	if size <= limit {
+		return msg, nil
+	}
+	if e.offloadBucket == "" {
+		return msg, &PayloadTooLargeError{Size: size, Limit: limit}
+	}
+	if e.local != nil {
+		return msg, fmt.Errorf("offloading payloads to s3 is %w", errLocalUnsupported)
+	}
+
+	id, err := newID()
+	if err != nil {
+		return msg, err
+	}
+	pointer := s3Pointer{Bucket: e.offloadBucket, Key: offloadPrefix + id}
+	if err := e.aws.PutS3Object(ctx, pointer.Bucket, pointer.Key, msg.body); err != nil {
+		return msg, err
+	}
+	body, err := json.Marshal(pointer)
+	if err != nil {
+		return msg, err
+	}
+
+	result := message{body: body}
+	for key, value := range msg.headers {
+		result.setHeader(key, value)
+	}
+	result.setHeader(offloadHeader, "s3")
+	return result, nil
+}
+
+// resolve fetches the payload of an offloaded message. The returned pointer is nil
+// when the message was not offloaded
+func (e *Elaston) resolve(ctx context.Context, msg message) (message, *s3Pointer, error) {
+	if msg.headers[offloadHeader] != "s3" {
+		return msg, nil, nil
+	}
+
+	var pointer s3Pointer
+	if err := json.Unmarshal(msg.body, &pointer); err != nil {
+		return msg, nil, fmt.Errorf("invalid offloaded payload pointer: %w", err)
+	}
+	body, err := e.aws.GetS3Object(ctx, pointer.Bucket, pointer.Key)
+	if err != nil {
+		return msg, nil, err
+	}
+	if body == nil {
+		return msg, nil, fmt.Errorf("offloaded payload s3://%s/%s not found", pointer.Bucket, pointer.Key)
+	}
+
+	result := message{body: body}
+	for key, value := range msg.headers {
+		if key != offloadHeader {
+			result.setHeader(key, value)
+		}
+	}
+	return result, &pointer, nil
+}
+
+// release deletes an offloaded payload once it is no longer needed. Failures are
+// not critical as the bucket lifecycle eventually expires it
+func (e *Elaston) release(ctx context.Context, pointer *s3Pointer) {
+	if pointer == nil {
+		return
+	}
+	if err := e.aws.DeleteS3Object(ctx, pointer.Bucket, pointer.Key); err != nil {
+		e.logf("failed to delete offloaded payload s3://%s/%s: %v", pointer.Bucket, pointer.Key, err)
+	}
+}
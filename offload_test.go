@@ -0,0 +1,125 @@
+package elaston
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/bcap/elaston/elastontest"
+)
+
+func TestOffload(t *testing.T) {
+	tests := []struct {
+		name      string
+		size      int
+		bucket    string
+		offloaded bool
+		tooLarge  bool
+	}{
+		{name: "small payload", size: 1024, bucket: "bucket"},
+		{name: "large payload", size: 300 * 1024, bucket: "bucket", offloaded: true},
+		{name: "large payload without bucket", size: 300 * 1024, tooLarge: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := context.Background()
+			fakes := elastontest.New()
+			client := newTestClient(fakes, WithOffloadBucket(tt.bucket))
+			input := strings.Repeat("x", tt.size)
+
+			_, err := client.Submit(ctx, input)
+			var tooLarge *PayloadTooLargeError
+			if tt.tooLarge {
+				if !errors.As(err, &tooLarge) {
+					t.Fatalf("expected a PayloadTooLargeError, got %v", err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			keys := fakes.S3.Keys(tt.bucket, offloadPrefix)
+			if tt.offloaded != (len(keys) == 1) {
+				t.Fatalf("expected offloaded to be %v, got objects %v", tt.offloaded, keys)
+			}
+			msgs := sentMessages(t, fakes)
+			if len(msgs) != 1 {
+				t.Fatalf("expected 1 message sent, got %d", len(msgs))
+			}
+			if size := len(msgs[0].Body); size > maxSQSMessageSize {
+				t.Fatalf("message body of %d bytes goes over the sqs limit", size)
+			}
+
+			handler, inputs := recordingHandler(nil, nil)
+			if err := handleSQSMessage(ctx, newTestClient(fakes, WithOffloadBucket(tt.bucket)), handler, msgs[0]); err != nil {
+				t.Fatal(err)
+			}
+			if got := <-inputs; got != input {
+				t.Fatalf("handler received %d bytes, expected %d", len(got.(string)), len(input))
+			}
+			if keys := fakes.S3.Keys(tt.bucket, offloadPrefix); len(keys) != 0 {
+				t.Fatalf("expected the offloaded payload to be deleted once handled, got %v", keys)
+			}
+		})
+	}
+}
+
+// failingResultStore fails the first failures calls to PutResult
+type failingResultStore struct {
+	*MemoryResultStore
+	failures int
+}
+
+func (s *failingResultStore) PutResult(ctx context.Context, key string, result Result) error {
+	if s.failures > 0 {
+		s.failures--
+		return errors.New("store unavailable")
+	}
+	return s.MemoryResultStore.PutResult(ctx, key, result)
+}
+
+func TestOffloadReleasedOnceProcessed(t *testing.T) {
+	tests := []struct {
+		name     string
+		failures int
+	}{
+		{name: "processed", failures: 0},
+		{name: "redelivered after storing the result failed", failures: 2},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := context.Background()
+			fakes := elastontest.New()
+			store := &failingResultStore{MemoryResultStore: NewMemoryResultStore(), failures: tt.failures}
+			options := []Option{WithOffloadBucket("bucket"), WithResultStore(store)}
+			future, err := SubmitFuture[string, string](ctx, newTestClient(fakes, options...), strings.Repeat("x", 300*1024))
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			// Every delivery of the message needs the payload until one is processed
+			runtime := newTestClient(fakes, options...)
+			msg := sentMessages(t, fakes)[0]
+			handler, _ := recordingHandler("done", nil)
+			for i := 0; i < tt.failures; i++ {
+				if err := handleSQSMessage(ctx, runtime, handler, msg); err == nil {
+					t.Fatal("expected storing the result to fail")
+				}
+				if keys := fakes.S3.Keys("bucket", offloadPrefix); len(keys) != 1 {
+					t.Fatalf("expected the offloaded payload to be kept, got %v", keys)
+				}
+			}
+			if err := handleSQSMessage(ctx, runtime, handler, msg); err != nil {
+				t.Fatal(err)
+			}
+			if keys := fakes.S3.Keys("bucket", offloadPrefix); len(keys) != 0 {
+				t.Fatalf("expected the offloaded payload to be deleted once processed, got %v", keys)
+			}
+			if out, done, err := future.Poll(); !done || err != nil || out != "done" {
+				t.Fatalf("expected the result to be stored, got %v, %v and %v", out, done, err)
+			}
+		})
+	}
+}
@@ -0,0 +1,191 @@
+package elaston
+
+import (
+	"log"
+	"time"
+
+	lambdaT "github.com/aws/aws-sdk-go-v2/service/lambda/types"
+)
+
+// Option configures an Elaston client. Options can be passed to New, applying to
+// everything the client does, or to individual Call and Submit calls, applying
+// only to that call
+type Option = func(*elaston)
+
+// Logger is satisfied by *log.Logger
+type Logger interface {
+	Printf(format string, v ...any)
+}
+
+// WithInvocationType sets how Call invokes the function. With
+// lambdaT.InvocationTypeEvent the call returns as soon as lambda queues the
+// invocation and the output is always nil
+func WithInvocationType(invocationType lambdaT.InvocationType) Option {
+	return func(e *elaston) {
+		e.invocationType = invocationType
+	}
+}
+
+// WithQualifier makes Call invoke a specific function version or alias
+func WithQualifier(qualifier string) Option {
+	return func(e *elaston) {
+		e.qualifier = qualifier
+	}
+}
+
+// WithTimeout bounds how long each Call or Submit can take, including retries
+func WithTimeout(timeout time.Duration) Option {
+	return func(e *elaston) {
+		e.timeout = timeout
+	}
+}
+
+// WithRetryPolicy sets how failed requests to aws are retried
+func WithRetryPolicy(policy RetryPolicy) Option {
+	return func(e *elaston) {
+		e.retryPolicy = policy
+	}
+}
+
+// WithCodec sets how payloads and results are encoded. Defaults to JSON
+func WithCodec(codec Codec) Option {
+	return func(e *elaston) {
+		e.codec = codec
+	}
+}
+
+// WithCompression compresses payloads going over the compression threshold. Call
+// also accepts responses compressed with the same algorithm
+func WithCompression(compression Compression) Option {
+	return func(e *elaston) {
+		e.compression = &compression
+	}
+}
+
+// WithSecurity signs, and optionally encrypts, everything sent by the client. It
+// also makes the client and the runtime reject anything received without a valid
+// signature
+func WithSecurity(security Security) Option {
+	return func(e *elaston) {
+		e.security = &security
+	}
+}
+
+// WithLogger sets where the client and the runtime log to. Defaults to log.Default()
+func WithLogger(logger Logger) Option {
+	return func(e *elaston) {
+		e.logger = logger
+	}
+}
+
+// WithMessageAttributes adds sqs message attributes to every submitted message.
+// Calling it multiple times merges the attributes
+func WithMessageAttributes(attributes map[string]string) Option {
+	return func(e *elaston) {
+		merged := make(map[string]string, len(e.attributes)+len(attributes))
+		for key, value := range e.attributes {
+			merged[key] = value
+		}
+		for key, value := range attributes {
+			merged[key] = value
+		}
+		e.attributes = merged
+	}
+}
+
+// WithResultStore sets where job results are stored so they can be retrieved
+// through the futures returned by SubmitFuture
+func WithResultStore(store ResultStore) Option {
+	return func(e *elaston) {
+		e.results = store
+	}
+}
+
+// WithOffloadBucket sets the s3 bucket used to store payloads and results that are
+// too large to be sent directly through sqs or lambda
+func WithOffloadBucket(bucket string) Option {
+	return func(e *elaston) {
+		e.offloadBucket = bucket
+	}
+}
+
+// WithBatchParallelism sets how many messages of a single sqs batch are processed
+// at the same time by the runtime. Defaults to 1, processing them sequentially
+func WithBatchParallelism(parallelism int) Option {
+	return func(e *elaston) {
+		e.batchParallelism = parallelism
+	}
+}
+
+// WithSubmitParallelism sets how many SendMessageBatch requests SubmitMany and the
+// outbox flush have in flight at the same time. Defaults to 1
+func WithSubmitParallelism(parallelism int) Option {
+	return func(e *elaston) {
+		e.submitParallelism = parallelism
+	}
+}
+
+// WithMessageGroup sets the message group of submissions to fifo queues, which is
+// required by them. Jobs in the same group are processed in the order they were
+// submitted, one at a time
+func WithMessageGroup(groupID string) Option {
+	return func(e *elaston) {
+		e.messageGroupID = groupID
+	}
+}
+
+// WithDeduplicationID sets the deduplication id of submissions to fifo queues. Fifo
+// queues drop messages with the id of another one sent in the previous 5 minutes.
+// SubmitMany suffixes it with the position of each input. Queues deployed without
+// content based deduplication require one outside handlers
+func WithDeduplicationID(deduplicationID string) Option {
+	return func(e *elaston) {
+		e.deduplicationID = deduplicationID
+	}
+}
+
+// WithQueueMaxReceiveCount tells the runtime how many times the queue delivers a
+// message before moving it to the dead-letter queue. Failures of jobs backed by a
+// future are then only stored as their result on the last attempt, leaving earlier
+// ones to be retried. When unknown, failures are stored right away. Set by deploy
+func WithQueueMaxReceiveCount(count int) Option {
+	return func(e *elaston) {
+		e.maxReceiveCount = count
+	}
+}
+
+// WithOutbox sets whether submissions made from inside a handler are buffered and
+// only sent once the handler succeeds. Enabled by default
+func WithOutbox(enabled bool) Option {
+	return func(e *elaston) {
+		e.outbox = enabled
+	}
+}
+
+func defaultOptions() elaston {
+	return elaston{
+		invocationType: lambdaT.InvocationTypeRequestResponse,
+		retryPolicy:    DefaultRetryPolicy,
+		codec:          JSON,
+		logger:         log.Default(),
+		limits:         DefaultLimits,
+		outbox:         true,
+	}
+}
+
+// with returns a copy of the client with the given options applied on top of the
+// client ones
+func (e *Elaston) with(options []Option) *Elaston {
+	if len(options) == 0 {
+		return e
+	}
+	copied := *e
+	for _, opt := range options {
+		opt(&copied.elaston)
+	}
+	return &copied
+}
+
+func (e *Elaston) logf(format string, v ...any) {
+	e.logger.Printf(format, v...)
+}
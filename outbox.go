@@ -0,0 +1,139 @@
+package elaston
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"strconv"
+	"sync"
+)
+
+const dedupIDHeader = "elaston-dedup-id"
+
+type outboxKey struct{}
+
+// outbox buffers the messages a handler submits, sending them only once the
+// handler succeeds. A failed handler sends nothing, so a retried job does not
+// leave behind the submissions of its failed attempts.
+//
+// Every buffered message carries a dedup id derived from the parent job id and
+// the position of the submission within the job. Retries of the same job that
+// submit in the same order produce the same ids, which lets consumers discard
+// the messages already processed in case a retry happens after a flush
+type outbox struct {
+	parentJobID string
+
+	entries []outboxEntry
+	count   int
+	mutex   sync.Mutex
+}
+
+type outboxEntry struct {
+	elaston *Elaston
+	entry   sqsEntry
+}
+
+func withOutbox(ctx context.Context, parentJobID string) (context.Context, *outbox) {
+	outbox := &outbox{parentJobID: parentJobID}
+	return context.WithValue(ctx, outboxKey{}, outbox), outbox
+}
+
+func outboxFromContext(ctx context.Context) *outbox {
+	outbox, _ := ctx.Value(outboxKey{}).(*outbox)
+	return outbox
+}
+
+// nextDedupID reserves the next submission position and returns its dedup id
+func (o *outbox) nextDedupID() string {
+	o.mutex.Lock()
+	index := o.count
+	o.count++
+	o.mutex.Unlock()
+
+	sum := sha256.Sum256([]byte(o.parentJobID + ":" + strconv.Itoa(index)))
+	return hex.EncodeToString(sum[:])
+}
+
+func (o *outbox) add(elaston *Elaston, entry sqsEntry) {
+	o.mutex.Lock()
+	defer o.mutex.Unlock()
+	o.entries = append(o.entries, outboxEntry{elaston: elaston, entry: entry})
+}
+
+// flush sends every buffered message, batching the ones going to the same queue.
+// Messages delayed for longer than sqs supports are scheduled instead. This is when
+// the messages count as descendants of the root job, so nothing is sent if they
+// would go over the limit. A failed flush takes them all back, as the job is retried
+// and flushes them again
+func (o *outbox) flush(ctx context.Context) (err error) {
+	o.mutex.Lock()
+	entries := o.entries
+	o.entries = nil
+	o.mutex.Unlock()
+
+	clients := []*Elaston{}
+	counts := map[*Elaston]int{}
+	for _, entry := range entries {
+		if _, ok := counts[entry.elaston]; !ok {
+			clients = append(clients, entry.elaston)
+		}
+		counts[entry.elaston]++
+	}
+	for i, elaston := range clients {
+		if err := elaston.countDescendants(ctx, counts[elaston]); err != nil {
+			for _, counted := range clients[:i] {
+				counted.uncountDescendants(ctx, counts[counted])
+			}
+			return fmt.Errorf("failed to flush submitted messages: %w", err)
+		}
+	}
+	defer func() {
+		if err != nil {
+			for _, elaston := range clients {
+				elaston.uncountDescendants(ctx, counts[elaston])
+			}
+		}
+	}()
+
+	queues := []string{}
+	byQueue := map[string][]outboxEntry{}
+	for _, entry := range entries {
+		if entry.elaston.needsSchedule(entry.entry.delay) {
+			if err := entry.elaston.schedule(ctx, entry.entry.msg, entry.entry.delay); err != nil {
+				return fmt.Errorf("failed to flush scheduled message: %w", err)
+			}
+			continue
+		}
+		queueURL := entry.elaston.sqsQueueURL
+		if _, ok := byQueue[queueURL]; !ok {
+			queues = append(queues, queueURL)
+		}
+		byQueue[queueURL] = append(byQueue[queueURL], entry)
+	}
+
+	for _, queueURL := range queues {
+		entries := byQueue[queueURL]
+		msgs := make([]sqsEntry, len(entries))
+		for i, entry := range entries {
+			msgs[i] = entry.entry
+		}
+		// The client of the first submission decides how the batch is sent
+		elaston := entries[0].elaston
+		_, errs := elaston.sendBatch(ctx, queueURL, msgs, elaston.submitParallelism)
+		failed := 0
+		var firstErr error
+		for _, err := range errs {
+			if err != nil {
+				failed++
+				if firstErr == nil {
+					firstErr = err
+				}
+			}
+		}
+		if failed > 0 {
+			return fmt.Errorf("failed to flush %d of %d submitted messages to %s: %w", failed, len(msgs), queueURL, firstErr)
+		}
+	}
+	return nil
+}
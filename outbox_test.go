@@ -0,0 +1,129 @@
+package elaston
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"testing"
+
+	"github.com/bcap/elaston/elastontest"
+)
+
+func TestOutbox(t *testing.T) {
+	tests := []struct {
+		name       string
+		submits    int
+		batched    int
+		handlerErr error
+		flushErr   error
+		sent       int
+		batches    int
+	}{
+		{name: "flushed on success", submits: 3, sent: 3, batches: 1},
+		{name: "more than a batch", submits: 12, sent: 12, batches: 2},
+		{name: "submitted many", submits: 3, batched: 2, sent: 5, batches: 1},
+		{name: "nothing sent on failure", submits: 3, batched: 2, handlerErr: errors.New("failed")},
+		{name: "flush failure", submits: 1, flushErr: elastontest.APIError("InternalError"), batches: 3},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := context.Background()
+			fakes := elastontest.New()
+			if _, err := newTestClient(fakes).Submit(ctx, "root"); err != nil {
+				t.Fatal(err)
+			}
+			root := sentMessages(t, fakes)[0]
+			fakes.SQS.Reset()
+			if tt.flushErr != nil {
+				// Fails every attempt allowed by the retry policy
+				fakes.SQS.FailTimes("SendMessageBatch", 3, tt.flushErr)
+			}
+
+			handler := HandlerFunc(func(ctx context.Context, e *Elaston, in any) (any, error) {
+				for i := 0; i < tt.submits; i++ {
+					if _, err := e.Submit(ctx, i); err != nil {
+						return nil, err
+					}
+				}
+				if tt.batched > 0 {
+					if _, err := e.SubmitMany(ctx, make([]any, tt.batched)); err != nil {
+						return nil, err
+					}
+				}
+				return nil, tt.handlerErr
+			})
+			err := handleSQSMessage(ctx, newTestClient(fakes), handler, root)
+			switch {
+			case tt.handlerErr != nil || tt.flushErr != nil:
+				if err == nil {
+					t.Fatal("expected the job to fail")
+				}
+			case err != nil:
+				t.Fatal(err)
+			}
+
+			if calls := len(fakes.SQS.CallsTo("SendMessage")); calls != 0 {
+				t.Fatalf("expected submits to be batched, got %d single sends", calls)
+			}
+			if batches := len(fakes.SQS.CallsTo("SendMessageBatch")); batches != tt.batches {
+				t.Fatalf("expected %d batches, got %d", tt.batches, batches)
+			}
+			if sent := len(sentMessages(t, fakes)); sent != tt.sent {
+				t.Fatalf("expected %d messages sent, got %d", tt.sent, sent)
+			}
+		})
+	}
+}
+
+func TestOutboxDedupIDs(t *testing.T) {
+	ctx := context.Background()
+	fakes := elastontest.New()
+	if _, err := newTestClient(fakes).Submit(ctx, "root"); err != nil {
+		t.Fatal(err)
+	}
+	root := sentMessages(t, fakes)[0]
+
+	// Runs the job as a retry would, returning the ids given by Submit and the dedup
+	// ids of the messages sent
+	run := func() ([]string, []string) {
+		fakes.SQS.Reset()
+		returned := []string{}
+		handler := HandlerFunc(func(ctx context.Context, e *Elaston, in any) (any, error) {
+			for i := 0; i < 3; i++ {
+				id, err := e.Submit(ctx, i)
+				if err != nil {
+					return nil, err
+				}
+				returned = append(returned, id)
+			}
+			return nil, nil
+		})
+		if err := handleSQSMessage(ctx, newTestClient(fakes), handler, root); err != nil {
+			t.Fatal(err)
+		}
+		sent := []string{}
+		for _, msg := range sentMessages(t, fakes) {
+			decoded, err := messageFromSQS(msg)
+			if err != nil {
+				t.Fatal(err)
+			}
+			sent = append(sent, decoded.headers[dedupIDHeader])
+		}
+		return returned, sent
+	}
+
+	returned, sent := run()
+	if !reflect.DeepEqual(returned, sent) {
+		t.Fatalf("expected the sent dedup ids %v to be the ones returned, %v", sent, returned)
+	}
+	seen := map[string]bool{}
+	for _, id := range returned {
+		if id == "" || seen[id] {
+			t.Fatalf("expected distinct dedup ids, got %v", returned)
+		}
+		seen[id] = true
+	}
+	if retried, _ := run(); !reflect.DeepEqual(retried, returned) {
+		t.Fatalf("expected a retry to produce the same dedup ids %v, got %v", returned, retried)
+	}
+}
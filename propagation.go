@@ -0,0 +1,195 @@
+package elaston
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	traceParentHeader   = "elaston-traceparent"
+	traceStateHeader    = "elaston-tracestate"
+	correlationIDHeader = "elaston-correlation-id"
+	baggageHeader       = "elaston-baggage"
+	parentJobIDHeader   = "elaston-parent-job-id"
+	// deadlineHeader is the absolute deadline of the job chain in unix milliseconds
+	deadlineHeader = "elaston-deadline"
+)
+
+// ErrJobExpired is returned when a job is received after the deadline propagated by
+// its caller. The handler is not called for it
+var ErrJobExpired = errors.New("elaston: job received after its deadline")
+
+// Metadata is propagated along chains of jobs: whatever a handler calls or submits
+// inherits it. The runtime restores it into the context passed to handlers
+type Metadata struct {
+	// JobID identifies the job being handled: the sqs message id for submitted jobs
+	// and the lambda request id for calls
+	JobID       string
+	ParentJobID string
+	// RootJobID is the job that started the chain and Depth how far from it this
+	// job is, the root having depth 0
+	RootJobID string
+	Depth     int
+	// CorrelationID is shared by every job in the chain
+	CorrelationID string
+	// TraceParent and TraceState follow the W3C trace context format
+	TraceParent string
+	TraceState  string
+	Baggage     map[string]string
+	// Deadline applies to the whole chain. Jobs received after it are not handled
+	Deadline time.Time
+}
+
+type metadataKey struct{}
+
+func MetadataFromContext(ctx context.Context) (Metadata, bool) {
+	md, ok := ctx.Value(metadataKey{}).(Metadata)
+	return md, ok
+}
+
+// ContextWithMetadata sets the metadata that calls and submissions made with the
+// returned context propagate. Use it to start a chain with a deadline or baggage
+func ContextWithMetadata(ctx context.Context, md Metadata) context.Context {
+	return context.WithValue(ctx, metadataKey{}, md)
+}
+
+// WithBaggage returns a context whose metadata includes the given baggage entry
+func WithBaggage(ctx context.Context, key string, value string) context.Context {
+	md, _ := MetadataFromContext(ctx)
+	baggage := make(map[string]string, len(md.Baggage)+1)
+	for k, v := range md.Baggage {
+		baggage[k] = v
+	}
+	baggage[key] = value
+	md.Baggage = baggage
+	return ContextWithMetadata(ctx, md)
+}
+
+// propagationHeaders returns the headers that carry the context metadata to the
+// callee. For synchronous calls the context deadline is propagated as well, as
+// there is no point in continuing after the caller gave up
+func propagationHeaders(ctx context.Context, synchronous bool) map[string]string {
+	headers := map[string]string{}
+	md, ok := MetadataFromContext(ctx)
+	if ok {
+		set := func(key string, value string) {
+			if value != "" {
+				headers[key] = value
+			}
+		}
+		set(parentJobIDHeader, md.JobID)
+		set(correlationIDHeader, md.CorrelationID)
+		set(baggageHeader, encodeBaggage(md.Baggage))
+		// Prefer the trace context of the current span when tracing is enabled
+		if !injectTraceContext(ctx, headers) {
+			set(traceParentHeader, childTraceParent(md.TraceParent))
+			set(traceStateHeader, md.TraceState)
+		}
+	} else {
+		injectTraceContext(ctx, headers)
+	}
+
+	for key, value := range lineageHeaders(ctx) {
+		headers[key] = value
+	}
+
+	deadline := md.Deadline
+	if ctxDeadline, ok := ctx.Deadline(); synchronous && ok && (deadline.IsZero() || ctxDeadline.Before(deadline)) {
+		deadline = ctxDeadline
+	}
+	if !deadline.IsZero() {
+		headers[deadlineHeader] = strconv.FormatInt(deadline.UnixMilli(), 10)
+	}
+	return headers
+}
+
+// restoreMetadata rebuilds the metadata from the headers of a received message,
+// starting a new chain when the message has none
+func restoreMetadata(ctx context.Context, headers map[string]string, jobID string) (context.Context, context.CancelFunc, error) {
+	md := Metadata{
+		JobID:         jobID,
+		ParentJobID:   headers[parentJobIDHeader],
+		CorrelationID: headers[correlationIDHeader],
+		TraceParent:   headers[traceParentHeader],
+		TraceState:    headers[traceStateHeader],
+		Baggage:       decodeBaggage(headers[baggageHeader]),
+	}
+	if md.CorrelationID == "" {
+		md.CorrelationID = jobID
+	}
+	if err := restoreLineage(&md, headers); err != nil {
+		return ctx, func() {}, err
+	}
+	if md.TraceParent == "" {
+		md.TraceParent = newTraceParent()
+	}
+
+	cancel := func() {}
+	if value, ok := headers[deadlineHeader]; ok {
+		millis, err := strconv.ParseInt(value, 10, 64)
+		if err != nil {
+			return ctx, cancel, err
+		}
+		md.Deadline = time.UnixMilli(millis)
+		if time.Now().After(md.Deadline) {
+			return ctx, cancel, ErrJobExpired
+		}
+		ctx, cancel = context.WithDeadline(ctx, md.Deadline)
+	}
+
+	return ContextWithMetadata(ctx, md), cancel, nil
+}
+
+// newTraceParent starts a new W3C trace
+func newTraceParent() string {
+	traceID := make([]byte, 16)
+	spanID := make([]byte, 8)
+	rand.Read(traceID)
+	rand.Read(spanID)
+	return "00-" + hex.EncodeToString(traceID) + "-" + hex.EncodeToString(spanID) + "-01"
+}
+
+// childTraceParent keeps the trace id and flags of the parent with a new span id
+func childTraceParent(parent string) string {
+	parts := strings.Split(parent, "-")
+	if len(parts) != 4 {
+		return ""
+	}
+	spanID := make([]byte, 8)
+	rand.Read(spanID)
+	parts[2] = hex.EncodeToString(spanID)
+	return strings.Join(parts, "-")
+}
+
+// encodeBaggage uses the W3C baggage format
+func encodeBaggage(baggage map[string]string) string {
+	entries := make([]string, 0, len(baggage))
+	for key, value := range baggage {
+		entries = append(entries, url.QueryEscape(key)+"="+url.QueryEscape(value))
+	}
+	sort.Strings(entries)
+	return strings.Join(entries, ",")
+}
+
+func decodeBaggage(encoded string) map[string]string {
+	if encoded == "" {
+		return nil
+	}
+	baggage := map[string]string{}
+	for _, entry := range strings.Split(encoded, ",") {
+		key, value, _ := strings.Cut(entry, "=")
+		key, _ = url.QueryUnescape(strings.TrimSpace(key))
+		value, _ = url.QueryUnescape(strings.TrimSpace(value))
+		if key != "" {
+			baggage[key] = value
+		}
+	}
+	return baggage
+}
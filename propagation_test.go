@@ -0,0 +1,172 @@
+package elaston
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestPropagation(t *testing.T) {
+	traceParent := "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01"
+	deadline := time.Now().Add(time.Hour).Truncate(time.Millisecond)
+	tests := []struct {
+		name        string
+		md          *Metadata
+		timeout     time.Duration
+		synchronous bool
+		deadline    bool
+		err         error
+	}{
+		{name: "new chain"},
+		{
+			name: "metadata",
+			md: &Metadata{
+				JobID:         "job-1",
+				CorrelationID: "correlation",
+				TraceParent:   traceParent,
+				TraceState:    "vendor=value",
+				Baggage:       map[string]string{"tenant": "acme", "odd key": "a=b,c"},
+			},
+		},
+		{name: "chain deadline", md: &Metadata{JobID: "job-1", Deadline: deadline}, deadline: true},
+		// Only synchronous callers wait for the answer, so only they pass their deadline on
+		{name: "call deadline", timeout: time.Hour, synchronous: true, deadline: true},
+		{name: "submit ignores context deadline", timeout: time.Hour},
+		{name: "expired", md: &Metadata{JobID: "job-1", Deadline: time.Now().Add(-time.Second)}, err: ErrJobExpired},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := context.Background()
+			if tt.md != nil {
+				ctx = ContextWithMetadata(ctx, *tt.md)
+			}
+			if tt.timeout > 0 {
+				var cancel context.CancelFunc
+				ctx, cancel = context.WithTimeout(ctx, tt.timeout)
+				defer cancel()
+			}
+
+			headers := propagationHeaders(ctx, tt.synchronous)
+			restored, cancel, err := restoreMetadata(context.Background(), headers, "job-2")
+			defer cancel()
+			if !errors.Is(err, tt.err) {
+				t.Fatalf("expected error %v, got %v", tt.err, err)
+			}
+			if tt.err != nil {
+				return
+			}
+
+			md, ok := MetadataFromContext(restored)
+			if !ok {
+				t.Fatal("expected metadata in the restored context")
+			}
+			if md.JobID != "job-2" {
+				t.Fatalf("expected job id job-2, got %s", md.JobID)
+			}
+			parent := Metadata{}
+			if tt.md != nil {
+				parent = *tt.md
+			}
+			if md.ParentJobID != parent.JobID {
+				t.Fatalf("expected parent job id %q, got %q", parent.JobID, md.ParentJobID)
+			}
+			// Chains without a correlation id start one from their first job
+			correlationID := parent.CorrelationID
+			if correlationID == "" {
+				correlationID = "job-2"
+			}
+			if md.CorrelationID != correlationID {
+				t.Fatalf("expected correlation id %q, got %q", correlationID, md.CorrelationID)
+			}
+			if len(md.Baggage) > 0 || len(parent.Baggage) > 0 {
+				if !reflect.DeepEqual(md.Baggage, parent.Baggage) {
+					t.Fatalf("expected baggage %v, got %v", parent.Baggage, md.Baggage)
+				}
+			}
+
+			// The trace id is kept and the span id is new
+			parts := strings.Split(md.TraceParent, "-")
+			if len(parts) != 4 {
+				t.Fatalf("invalid traceparent %q", md.TraceParent)
+			}
+			if parent.TraceParent != "" {
+				parentParts := strings.Split(parent.TraceParent, "-")
+				if parts[1] != parentParts[1] || parts[2] == parentParts[2] {
+					t.Fatalf("expected a child of %s, got %s", parent.TraceParent, md.TraceParent)
+				}
+				if md.TraceState != parent.TraceState {
+					t.Fatalf("expected trace state %q, got %q", parent.TraceState, md.TraceState)
+				}
+			}
+
+			_, hasDeadline := restored.Deadline()
+			if hasDeadline != tt.deadline || md.Deadline.IsZero() == tt.deadline {
+				t.Fatalf("expected a deadline to be %v, got %v", tt.deadline, md.Deadline)
+			}
+			if !parent.Deadline.IsZero() && !md.Deadline.Equal(parent.Deadline) {
+				t.Fatalf("expected deadline %v, got %v", parent.Deadline, md.Deadline)
+			}
+		})
+	}
+}
+
+func TestBaggageEncoding(t *testing.T) {
+	tests := []struct {
+		name    string
+		baggage map[string]string
+		encoded string
+	}{
+		{name: "empty", encoded: ""},
+		{name: "sorted", baggage: map[string]string{"b": "2", "a": "1"}, encoded: "a=1,b=2"},
+		{name: "escaped", baggage: map[string]string{"odd key": "a=b,c"}, encoded: "odd+key=a%3Db%2Cc"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			encoded := encodeBaggage(tt.baggage)
+			if encoded != tt.encoded {
+				t.Fatalf("expected %q, got %q", tt.encoded, encoded)
+			}
+			if decoded := decodeBaggage(encoded); !reflect.DeepEqual(decoded, tt.baggage) {
+				t.Fatalf("expected %v back, got %v", tt.baggage, decoded)
+			}
+		})
+	}
+	// Whitespace and entries without a key are tolerated
+	decoded := decodeBaggage(" a = 1 ,=2,b")
+	if !reflect.DeepEqual(decoded, map[string]string{"a": "1", "b": ""}) {
+		t.Fatalf("unexpected baggage %v", decoded)
+	}
+}
+
+func TestBaggageReachesNestedJobs(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	received := make(chan Metadata, 2)
+	local := newTestLocal(t, TypedFunc(func(ctx context.Context, e *Elaston, in int) (int, error) {
+		md, _ := MetadataFromContext(ctx)
+		received <- md
+		if in > 0 {
+			return Call[int, int](ctx, e, in-1)
+		}
+		return in, nil
+	}))
+
+	if _, err := local.Client().Call(WithBaggage(ctx, "tenant", "acme"), 1); err != nil {
+		t.Fatal(err)
+	}
+	outer, inner := <-received, <-received
+	for _, md := range []Metadata{outer, inner} {
+		if md.Baggage["tenant"] != "acme" {
+			t.Fatalf("expected the baggage to reach job %s, got %v", md.JobID, md.Baggage)
+		}
+		if md.CorrelationID != outer.CorrelationID {
+			t.Fatalf("expected correlation id %s, got %s", outer.CorrelationID, md.CorrelationID)
+		}
+	}
+	if inner.ParentJobID != outer.JobID {
+		t.Fatalf("expected the nested job to have parent %s, got %s", outer.JobID, inner.ParentJobID)
+	}
+}
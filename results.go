@@ -0,0 +1,128 @@
+package elaston
+
+import (
+	"context"
+	"encoding/json"
+	"sync"
+
+	"github.com/bcap/elaston/aws"
+)
+
+// ResultStore keeps the outcome of jobs submitted with SubmitFuture until their
+// Future retrieves it
+type ResultStore interface {
+	PutResult(ctx context.Context, key string, result Result) error
+	// GetResult returns a nil result without an error if it is not available yet
+	GetResult(ctx context.Context, key string) (*Result, error)
+}
+
+// Result is what the handler produced for a submitted job: either its encoded
+// output or the error it failed with
+type Result struct {
+	Output []byte `json:"output,omitempty"`
+	// ContentType identifies the codec Output is encoded with
+	ContentType string       `json:"contentType,omitempty"`
+	Error       *RemoteError `json:"error,omitempty"`
+	// Sealed holds the whole result, signed and possibly encrypted, when the runtime
+	// has Security configured. Headers carry what is needed to open it
+	Sealed  []byte            `json:"sealed,omitempty"`
+	Headers map[string]string `json:"headers,omitempty"`
+}
+
+// sealResult protects the result the same way payloads are, as it is stored
+// outside of the function. Without Security the result is left as is
+func sealResult(ctx context.Context, security *Security, result Result) (Result, error) {
+	if security == nil {
+		return result, nil
+	}
+	data, err := json.Marshal(result)
+	if err != nil {
+		return result, err
+	}
+	sealed, err := security.seal(ctx, message{body: data})
+	if err != nil {
+		return result, err
+	}
+	return Result{Sealed: sealed.body, Headers: sealed.headers}, nil
+}
+
+// openResult verifies and decrypts a result stored by sealResult. With Security
+// configured, results that were not sealed are rejected
+func openResult(ctx context.Context, security *Security, result *Result) (*Result, error) {
+	if result.Headers == nil && security == nil {
+		return result, nil
+	}
+	opened, err := security.open(ctx, message{headers: result.Headers, body: result.Sealed})
+	if err != nil {
+		return nil, err
+	}
+	var unsealed Result
+	if err := json.Unmarshal(opened.body, &unsealed); err != nil {
+		return nil, err
+	}
+	return &unsealed, nil
+}
+
+//
+// S3
+//
+
+type S3ResultStore struct {
+	aws    *aws.AWS
+	bucket string
+	prefix string
+}
+
+func NewS3ResultStore(aws *aws.AWS, bucket string, prefix string) *S3ResultStore {
+	return &S3ResultStore{aws: aws, bucket: bucket, prefix: prefix}
+}
+
+func (s *S3ResultStore) PutResult(ctx context.Context, key string, result Result) error {
+	data, err := json.Marshal(result)
+	if err != nil {
+		return err
+	}
+	return s.aws.PutS3Object(ctx, s.bucket, s.prefix+key, data)
+}
+
+func (s *S3ResultStore) GetResult(ctx context.Context, key string) (*Result, error) {
+	data, err := s.aws.GetS3Object(ctx, s.bucket, s.prefix+key)
+	if err != nil || data == nil {
+		return nil, err
+	}
+	var result Result
+	if err := json.Unmarshal(data, &result); err != nil {
+		return nil, err
+	}
+	return &result, nil
+}
+
+//
+// Memory
+//
+
+type MemoryResultStore struct {
+	results map[string]Result
+	mutex   sync.Mutex
+}
+
+func NewMemoryResultStore() *MemoryResultStore {
+	return &MemoryResultStore{results: map[string]Result{}}
+}
+
+func (s *MemoryResultStore) PutResult(ctx context.Context, key string, result Result) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.results[key] = result
+	return nil
+}
+
+func (s *MemoryResultStore) GetResult(ctx context.Context, key string) (*Result, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	result, ok := s.results[key]
+	if !ok {
+		return nil, nil
+	}
+	return &result, nil
+}
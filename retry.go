@@ -0,0 +1,276 @@
+package elaston
+
+import (
+	"context"
+	"errors"
+	"math/rand"
+	"net"
+	"time"
+
+	lambdaT "github.com/aws/aws-sdk-go-v2/service/lambda/types"
+	"github.com/aws/smithy-go"
+)
+
+// RetryPolicy defines how failed requests to aws are retried by Call and Submit.
+// Note that the aws sdk has its own retries underneath, so each attempt here may
+// already include a few retries of the actual http request. Call is the exception,
+// as lambda invocations are only retried by the policy
+type RetryPolicy struct {
+	// MaxAttempts includes the first attempt. Values below 2 disable retries
+	MaxAttempts int
+	// InitialBackoff is the wait before the first retry, doubled on each further
+	// retry up to MaxBackoff
+	InitialBackoff time.Duration
+	MaxBackoff     time.Duration
+	// Jitter randomizes each backoff by up to this fraction of it, in the [0, 1] range,
+	// so that clients throttled at the same time do not retry at the same time
+	Jitter float64
+	// Deadline bounds the total time spent across all attempts. Zero means no bound
+	Deadline time.Duration
+	// RetryFunctionErrors also retries calls that failed because the handler failed.
+	// Throttling and retryable service errors are always retried
+	RetryFunctionErrors bool
+	// RetryAmbiguous also retries calls that failed after the invocation may have
+	// reached the function, eg lambda service errors and timeouts, which can make the
+	// handler run more than once. Only enable it for idempotent handlers. Calls
+	// rejected before running, eg throttled ones, are always retried
+	RetryAmbiguous bool
+	// OnAttempt, when set, is called after every failed attempt, eg for logging or metrics
+	OnAttempt func(Attempt)
+}
+
+// Attempt describes a failed attempt
+type Attempt struct {
+	// Number starts at 1
+	Number  int
+	Err     error
+	Class   ErrorClass
+	Elapsed time.Duration
+	// Backoff is the wait before the next attempt, or zero if there won't be one
+	Backoff time.Duration
+}
+
+type ErrorClass int
+
+const (
+	// ErrorClassPermanent are errors that will not go away by retrying, eg invalid requests
+	ErrorClassPermanent ErrorClass = iota
+	// ErrorClassThrottle is when aws rejects the request due to rate or concurrency limits
+	ErrorClassThrottle
+	// ErrorClassRetryable are transient service or network errors
+	ErrorClassRetryable
+	// ErrorClassFunction is when the request reached the handler and it failed
+	ErrorClassFunction
+	// ErrorClassAmbiguous is a transient failure of a call that may have reached the
+	// handler anyway, see RetryPolicy.RetryAmbiguous
+	ErrorClassAmbiguous
+)
+
+func (c ErrorClass) String() string {
+	switch c {
+	case ErrorClassThrottle:
+		return "throttle"
+	case ErrorClassRetryable:
+		return "retryable"
+	case ErrorClassFunction:
+		return "function"
+	case ErrorClassAmbiguous:
+		return "ambiguous"
+	default:
+		return "permanent"
+	}
+}
+
+var DefaultRetryPolicy = RetryPolicy{
+	MaxAttempts:    5,
+	InitialBackoff: 100 * time.Millisecond,
+	MaxBackoff:     5 * time.Second,
+	Jitter:         0.5,
+}
+
+var NoRetries = RetryPolicy{MaxAttempts: 1}
+
+func (p RetryPolicy) do(ctx context.Context, fn func(context.Context) error) error {
+	return p.doClassified(ctx, ClassifyError, fn)
+}
+
+// doCall is do for lambda invocations, where only failures known to have happened
+// before the handler ran are retried unless RetryAmbiguous is set
+func (p RetryPolicy) doCall(ctx context.Context, fn func(context.Context) error) error {
+	return p.doClassified(ctx, classifyCallError, fn)
+}
+
+func (p RetryPolicy) doClassified(ctx context.Context, classify func(error) ErrorClass, fn func(context.Context) error) error {
+	if p.Deadline > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, p.Deadline)
+		defer cancel()
+	}
+
+	start := time.Now()
+	backoff := p.InitialBackoff
+	for attempt := 1; ; attempt++ {
+		err := fn(ctx)
+		if err == nil {
+			return nil
+		}
+
+		class := classify(err)
+		retry := attempt < p.MaxAttempts && ctx.Err() == nil && p.retryable(class)
+		wait := time.Duration(0)
+		if retry {
+			wait = p.jittered(backoff)
+		}
+		if p.OnAttempt != nil {
+			p.OnAttempt(Attempt{
+				Number:  attempt,
+				Err:     err,
+				Class:   class,
+				Elapsed: time.Since(start),
+				Backoff: wait,
+			})
+		}
+		if !retry {
+			return err
+		}
+
+		select {
+		case <-time.After(wait):
+		case <-ctx.Done():
+			return errors.Join(err, ctx.Err())
+		}
+		backoff *= 2
+		if p.MaxBackoff > 0 && backoff > p.MaxBackoff {
+			backoff = p.MaxBackoff
+		}
+	}
+}
+
+func (p RetryPolicy) retryable(class ErrorClass) bool {
+	switch class {
+	case ErrorClassThrottle, ErrorClassRetryable:
+		return true
+	case ErrorClassFunction:
+		return p.RetryFunctionErrors
+	case ErrorClassAmbiguous:
+		return p.RetryAmbiguous
+	default:
+		return false
+	}
+}
+
+func (p RetryPolicy) jittered(backoff time.Duration) time.Duration {
+	if p.Jitter <= 0 || backoff <= 0 {
+		return backoff
+	}
+	delta := float64(backoff) * p.Jitter
+	return backoff - time.Duration(delta) + time.Duration(rand.Float64()*2*delta)
+}
+
+var throttleCodes = map[string]bool{
+	"TooManyRequestsException":                   true,
+	"ThrottlingException":                        true,
+	"Throttling":                                 true,
+	"RequestThrottled":                           true,
+	"RequestLimitExceeded":                       true,
+	"AWS.SimpleQueueService.RequestThrottled":    true,
+	"KMSThrottlingException":                     true,
+	"EC2ThrottledException":                      true,
+	"ProvisionedThroughputExceededException":     true,
+	"AWS.SimpleQueueService.ThrottlingException": true,
+}
+
+var retryableCodes = map[string]bool{
+	"ServiceException":            true,
+	"ServiceUnavailable":          true,
+	"ServiceUnavailableException": true,
+	"InternalError":               true,
+	"InternalFailure":             true,
+	"ResourceNotReadyException":   true,
+	"ResourceConflictException":   true,
+	"RequestTimeout":              true,
+	"RequestTimeoutException":     true,
+}
+
+// ClassifyError tells whether an error returned by Call or Submit is due to
+// throttling, a transient failure, a handler failure or something permanent
+func ClassifyError(err error) ErrorClass {
+	var remoteErr *RemoteError
+	if errors.As(err, &remoteErr) {
+		return ErrorClassFunction
+	}
+
+	var tooMany *lambdaT.TooManyRequestsException
+	if errors.As(err, &tooMany) {
+		return ErrorClassThrottle
+	}
+
+	// Entries refused within a batch are retryable unless the fault was the sender's
+	var entryErr *BatchEntryError
+	if errors.As(err, &entryErr) {
+		switch {
+		case throttleCodes[entryErr.Code]:
+			return ErrorClassThrottle
+		case entryErr.SenderFault:
+			return ErrorClassPermanent
+		default:
+			return ErrorClassRetryable
+		}
+	}
+
+	var apiErr smithy.APIError
+	if errors.As(err, &apiErr) {
+		if throttleCodes[apiErr.ErrorCode()] {
+			return ErrorClassThrottle
+		}
+		if retryableCodes[apiErr.ErrorCode()] || apiErr.ErrorFault() == smithy.FaultServer {
+			return ErrorClassRetryable
+		}
+	}
+
+	var statusErr interface{ HTTPStatusCode() int }
+	if errors.As(err, &statusErr) {
+		switch code := statusErr.HTTPStatusCode(); {
+		case code == 429:
+			return ErrorClassThrottle
+		case code >= 500:
+			return ErrorClassRetryable
+		}
+	}
+
+	var netErr net.Error
+	if errors.As(err, &netErr) && netErr.Timeout() {
+		return ErrorClassRetryable
+	}
+
+	return ErrorClassPermanent
+}
+
+// notInvokedCodes are lambda errors for invocations rejected before the handler ran
+var notInvokedCodes = map[string]bool{
+	"ResourceNotReadyException": true,
+	"ResourceConflictException": true,
+}
+
+// classifyCallError is ClassifyError for lambda invocations, telling apart retryable
+// failures that happened before the request was sent, or that lambda reports as not
+// having invoked the function, from the ones where the handler may have run
+func classifyCallError(err error) ErrorClass {
+	class := ClassifyError(err)
+	if class != ErrorClassRetryable {
+		return class
+	}
+	var apiErr smithy.APIError
+	if errors.As(err, &apiErr) && notInvokedCodes[apiErr.ErrorCode()] {
+		return ErrorClassRetryable
+	}
+	var opErr *net.OpError
+	if errors.As(err, &opErr) && opErr.Op == "dial" {
+		return ErrorClassRetryable
+	}
+	return ErrorClassAmbiguous
+}
+
+func isThrottle(err error) bool {
+	return ClassifyError(err) == ErrorClassThrottle
+}
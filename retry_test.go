@@ -0,0 +1,160 @@
+package elaston
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/service/lambda"
+	lambdaT "github.com/aws/aws-sdk-go-v2/service/lambda/types"
+	"github.com/aws/smithy-go"
+
+	"github.com/bcap/elaston/elastontest"
+)
+
+func TestClassifyError(t *testing.T) {
+	timeout := &net.DNSError{IsTimeout: true}
+	tests := []struct {
+		name  string
+		err   error
+		class ErrorClass
+		// call is the class of the error when returned by a lambda invocation
+		call ErrorClass
+	}{
+		{name: "unknown", err: errors.New("failed"), class: ErrorClassPermanent, call: ErrorClassPermanent},
+		{name: "remote", err: &RemoteError{Message: "failed"}, class: ErrorClassFunction, call: ErrorClassFunction},
+		{name: "wrapped remote", err: fmt.Errorf("calling: %w", &RemoteError{}), class: ErrorClassFunction, call: ErrorClassFunction},
+		{name: "lambda throttle", err: &lambdaT.TooManyRequestsException{}, class: ErrorClassThrottle, call: ErrorClassThrottle},
+		{name: "throttle code", err: elastontest.APIError("ThrottlingException"), class: ErrorClassThrottle, call: ErrorClassThrottle},
+		{name: "service error", err: elastontest.APIError("ServiceException"), class: ErrorClassRetryable, call: ErrorClassAmbiguous},
+		{name: "not invoked", err: elastontest.APIError("ResourceNotReadyException"), class: ErrorClassRetryable, call: ErrorClassRetryable},
+		{name: "client fault", err: &smithy.GenericAPIError{Code: "InvalidParameterValue", Fault: smithy.FaultClient}, class: ErrorClassPermanent, call: ErrorClassPermanent},
+		{name: "dial timeout", err: &net.OpError{Op: "dial", Err: timeout}, class: ErrorClassRetryable, call: ErrorClassRetryable},
+		{name: "read timeout", err: &net.OpError{Op: "read", Err: timeout}, class: ErrorClassRetryable, call: ErrorClassAmbiguous},
+		{name: "batch entry throttle", err: &BatchEntryError{Code: "RequestThrottled"}, class: ErrorClassThrottle, call: ErrorClassThrottle},
+		{name: "batch entry sender fault", err: &BatchEntryError{Code: "InvalidParameterValue", SenderFault: true}, class: ErrorClassPermanent, call: ErrorClassPermanent},
+		{name: "batch entry service fault", err: &BatchEntryError{Code: "InternalError"}, class: ErrorClassRetryable, call: ErrorClassAmbiguous},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if class := ClassifyError(tt.err); class != tt.class {
+				t.Fatalf("expected class %v, got %v", tt.class, class)
+			}
+			if class := classifyCallError(tt.err); class != tt.call {
+				t.Fatalf("expected call class %v, got %v", tt.call, class)
+			}
+		})
+	}
+}
+
+func TestCallRetries(t *testing.T) {
+	functionError := func(any) (any, error) {
+		unhandled := "Unhandled"
+		return &lambda.InvokeOutput{StatusCode: 200, FunctionError: &unhandled, Payload: []byte(`{"errorMessage":"failed"}`)}, nil
+	}
+	tests := []struct {
+		name   string
+		policy RetryPolicy
+		// failures are the responses of the first invocations, the rest succeed
+		failures []elastontest.Responder
+		calls    int
+		err      bool
+	}{
+		{
+			name:     "throttled",
+			failures: repeatResponder(failWith(&lambdaT.TooManyRequestsException{}), 2),
+			calls:    3,
+		},
+		{
+			name:     "retries exhausted",
+			failures: repeatResponder(failWith(&lambdaT.TooManyRequestsException{}), 3),
+			calls:    3,
+			err:      true,
+		},
+		{
+			name:     "not invoked",
+			failures: repeatResponder(failWith(elastontest.APIError("ResourceNotReadyException")), 1),
+			calls:    2,
+		},
+		{
+			name:     "ambiguous",
+			failures: repeatResponder(failWith(elastontest.APIError("ServiceException")), 1),
+			calls:    1,
+			err:      true,
+		},
+		{
+			name:     "ambiguous retried",
+			policy:   RetryPolicy{RetryAmbiguous: true},
+			failures: repeatResponder(failWith(elastontest.APIError("ServiceException")), 1),
+			calls:    2,
+		},
+		{
+			name:     "function error",
+			failures: repeatResponder(functionError, 1),
+			calls:    1,
+			err:      true,
+		},
+		{
+			name:     "function error retried",
+			policy:   RetryPolicy{RetryFunctionErrors: true},
+			failures: repeatResponder(functionError, 1),
+			calls:    2,
+		},
+		{
+			name:     "no retries",
+			policy:   NoRetries,
+			failures: repeatResponder(failWith(&lambdaT.TooManyRequestsException{}), 1),
+			calls:    1,
+			err:      true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fakes := elastontest.New()
+			for _, failure := range tt.failures {
+				fakes.Lambda.Script("Invoke", failure)
+			}
+			policy := tt.policy
+			if policy.MaxAttempts == 0 {
+				policy.MaxAttempts = 3
+			}
+			attempts := []Attempt{}
+			policy.OnAttempt = func(attempt Attempt) { attempts = append(attempts, attempt) }
+
+			_, err := newTestClient(fakes, WithRetryPolicy(policy)).Call(context.Background(), "input")
+			if (err != nil) != tt.err {
+				t.Fatalf("expected an error to be %v, got %v", tt.err, err)
+			}
+			if calls := len(fakes.Lambda.CallsTo("Invoke")); calls != tt.calls {
+				t.Fatalf("expected %d invocations, got %d", tt.calls, calls)
+			}
+			// Every failed attempt is reported
+			failed := tt.calls
+			if !tt.err {
+				failed--
+			}
+			if len(attempts) != failed {
+				t.Fatalf("expected %d failed attempts reported, got %d", failed, len(attempts))
+			}
+			for i, attempt := range attempts {
+				if attempt.Number != i+1 {
+					t.Fatalf("expected attempt %d, got %d", i+1, attempt.Number)
+				}
+			}
+		})
+	}
+}
+
+func failWith(err error) elastontest.Responder {
+	return func(any) (any, error) { return nil, err }
+}
+
+func repeatResponder(responder elastontest.Responder, n int) []elastontest.Responder {
+	responders := make([]elastontest.Responder, n)
+	for i := range responders {
+		responders[i] = responder
+	}
+	return responders
+}
@@ -0,0 +1,94 @@
+package elaston
+
+import (
+	"context"
+	"fmt"
+	"sort"
+)
+
+// routeHeader carries the route name along with the payload
+const routeHeader = "elaston-route"
+
+// ErrCodeUnknownRoute is the Error code returned when a payload targets a route that
+// has no handler registered
+const ErrCodeUnknownRoute = "unknown_route"
+
+// Router is a Handler that dispatches each payload to the handler registered under
+// the route it was sent to. This allows a single deployment to host several
+// operations. Clients pick the route with Elaston.Route
+type Router struct {
+	handlers map[string]Handler
+}
+
+func NewRouter() *Router {
+	return &Router{handlers: map[string]Handler{}}
+}
+
+// Register adds a handler for the given route. The empty route handles payloads sent
+// without a route, eg by clients not using Elaston.Route
+func (r *Router) Register(route string, handler Handler) *Router {
+	r.handlers[route] = handler
+	return r
+}
+
+// RegisterFunc is a shorthand for Register(route, HandlerFunc(f))
+func (r *Router) RegisterFunc(route string, f func(context.Context, *Elaston, any) (any, error)) *Router {
+	return r.Register(route, HandlerFunc(f))
+}
+
+func (r *Router) Routes() []string {
+	routes := make([]string, 0, len(r.handlers))
+	for route := range r.handlers {
+		routes = append(routes, route)
+	}
+	sort.Strings(routes)
+	return routes
+}
+
+func (r *Router) Handle(ctx context.Context, elaston *Elaston, in any) (any, error) {
+	handler, err := r.lookup(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return handler.Handle(ctx, elaston, in)
+}
+
+func (r *Router) handleRaw(ctx context.Context, elaston *Elaston, codec Codec, payload []byte) (any, error) {
+	handler, err := r.lookup(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return callHandler(ctx, elaston, handler, codec, payload)
+}
+
+func (r *Router) lookup(ctx context.Context) (Handler, error) {
+	route := RouteFromContext(ctx)
+	handler, ok := r.handlers[route]
+	if !ok {
+		return nil, NewError(
+			ErrCodeUnknownRoute,
+			fmt.Sprintf("no handler registered for route %q", route),
+			map[string]any{"route": route, "routes": r.Routes()},
+		)
+	}
+	return handler, nil
+}
+
+type routeKey struct{}
+
+// RouteFromContext returns the route the payload being handled was sent to
+func RouteFromContext(ctx context.Context) string {
+	route, _ := ctx.Value(routeKey{}).(string)
+	return route
+}
+
+func withRoute(ctx context.Context, route string) context.Context {
+	return context.WithValue(ctx, routeKey{}, route)
+}
+
+// Route returns a client that sends calls and submissions to the given route
+func (e *Elaston) Route(route string) *Elaston {
+	routed := *e
+	routed.route = route
+	return &routed
+}
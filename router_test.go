@@ -0,0 +1,77 @@
+package elaston
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestRouter(t *testing.T) {
+	tests := []struct {
+		name   string
+		route  string
+		submit bool
+		output any
+		code   string
+	}{
+		{name: "typed route", route: "double", output: float64(4)},
+		{name: "default route", route: "", output: "default"},
+		{name: "submitted", route: "double", submit: true},
+		{name: "unknown route", route: "missing", code: ErrCodeUnknownRoute},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+			defer cancel()
+			routes := make(chan string, 1)
+			router := NewRouter().
+				Register("double", TypedFunc(func(ctx context.Context, e *Elaston, in int) (int, error) {
+					routes <- RouteFromContext(ctx)
+					return in * 2, nil
+				})).
+				RegisterFunc("", func(ctx context.Context, e *Elaston, in any) (any, error) {
+					routes <- RouteFromContext(ctx)
+					return "default", nil
+				})
+			local := newTestLocal(t, router)
+			client := local.Client().Route(tt.route)
+
+			if tt.submit {
+				if _, err := client.Submit(ctx, 2); err != nil {
+					t.Fatal(err)
+				}
+				if err := local.Drain(ctx); err != nil {
+					t.Fatal(err)
+				}
+				if route := <-routes; route != tt.route {
+					t.Fatalf("expected the submission to reach route %q, got %q", tt.route, route)
+				}
+				return
+			}
+
+			out, err := client.Call(ctx, 2)
+			if tt.code != "" {
+				var remoteErr *RemoteError
+				if !errors.As(err, &remoteErr) || remoteErr.Err == nil || remoteErr.Err.Code != tt.code {
+					t.Fatalf("expected a remote error with code %s, got %v", tt.code, err)
+				}
+				details := remoteErr.Err.Details.(map[string]any)
+				if details["route"] != tt.route || !reflect.DeepEqual(details["routes"], []any{"", "double"}) {
+					t.Fatalf("unexpected error details %v", details)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if out != tt.output {
+				t.Fatalf("expected output %v, got %v", tt.output, out)
+			}
+			if route := <-routes; route != tt.route {
+				t.Fatalf("expected the call to reach route %q, got %q", tt.route, route)
+			}
+		})
+	}
+}
@@ -3,18 +3,17 @@ package elaston
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
-	"io"
-	"log"
 	"os"
+	"strconv"
+	"sync"
 
+	"github.com/aws/aws-lambda-go/events"
 	lambdaRunner "github.com/aws/aws-lambda-go/lambda"
-	lambdaT "github.com/aws/aws-sdk-go-v2/service/lambda/types"
-	sqsT "github.com/aws/aws-sdk-go-v2/service/sqs/types"
-	"github.com/davecgh/go-spew/spew"
+	"github.com/aws/aws-lambda-go/lambdacontext"
 
 	"github.com/bcap/elaston/aws"
-	"github.com/bcap/elaston/deploy"
 )
 
 func Run(handler Handler) {
@@ -47,69 +46,395 @@ func mustEnvVar(key string) string {
 }
 
 func runLambda(handler Handler) {
-	elaston := New(aws.New(""), lambdaFnName(), queueURL())
+	aws, err := aws.New("", aws.WithEnvironmentEndpoints())
+	panicOnErr(err)
+	options, err := environmentOptions(aws, os.LookupEnv)
+	panicOnErr(err)
+	elaston := New(aws, lambdaFnName(), queueURL(), options...)
 	lambdaRunner.Start(lambdaHandler(elaston, handler))
 }
 
-func lambdaHandler(elaston *Elaston, handler Handler) func(context.Context, json.RawMessage) (any, error) {
-	isSQSMessage := func(msg *sqsT.Message) bool {
-		return msg != nil && msg.MessageId != nil && msg.MD5OfBody != nil && msg.Attributes != nil && msg.ReceiptHandle != nil
+// environmentOptions returns the options configured by the environment variables
+// deploy sets on the function. Besides the runtime, they configure clients of a
+// deployment, eg in the command line tool, the same way
+func environmentOptions(aws *aws.AWS, lookup func(string) (string, bool)) ([]Option, error) {
+	options := []Option{}
+	if bucket, ok := lookup("ELASTON_S3_BUCKET"); ok {
+		options = append(
+			options,
+			WithResultStore(NewS3ResultStore(aws, bucket, "results/")),
+			WithOffloadBucket(bucket),
+			WithDedupStore(NewS3DedupStore(aws, bucket, "dedup/")),
+		)
+	}
+	if value, _ := lookup("ELASTON_TRACE_EXPORTER"); value == "stdout" {
+		provider, err := NewStdoutTracerProvider(os.Stdout)
+		if err != nil {
+			return nil, err
+		}
+		options = append(options, WithTracerProvider(provider))
+	}
+	limits := DefaultLimits
+	if value, ok := lookup("ELASTON_MAX_DEPTH"); ok {
+		maxDepth, err := strconv.Atoi(value)
+		if err != nil {
+			return nil, fmt.Errorf("invalid ELASTON_MAX_DEPTH %q: %w", value, err)
+		}
+		limits.MaxDepth = maxDepth
+	}
+	if value, ok := lookup("ELASTON_MAX_DESCENDANTS"); ok {
+		maxDescendants, err := strconv.ParseInt(value, 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid ELASTON_MAX_DESCENDANTS %q: %w", value, err)
+		}
+		limits.MaxDescendants = maxDescendants
+		if bucket, ok := lookup("ELASTON_S3_BUCKET"); ok {
+			limits.Counter = NewS3DescendantCounter(aws, bucket, "lineage/")
+		}
+	}
+	options = append(options, WithLimits(limits))
+	if keyID, ok := lookup("ELASTON_KMS_KEY_ID"); ok {
+		options = append(options, WithSecurity(Security{Keys: NewKMSKeyProvider(aws, keyID), Encrypt: true}))
+	}
+	if value, ok := lookup("ELASTON_MAX_RECEIVE_COUNT"); ok {
+		count, err := strconv.Atoi(value)
+		if err != nil {
+			return nil, fmt.Errorf("invalid ELASTON_MAX_RECEIVE_COUNT %q: %w", value, err)
+		}
+		options = append(options, WithQueueMaxReceiveCount(count))
+	}
+	if value, ok := lookup("ELASTON_BATCH_PARALLELISM"); ok {
+		parallelism, err := strconv.Atoi(value)
+		if err != nil {
+			return nil, fmt.Errorf("invalid ELASTON_BATCH_PARALLELISM %q: %w", value, err)
+		}
+		options = append(options, WithBatchParallelism(parallelism))
 	}
+	if group, ok := lookup("ELASTON_SCHEDULE_GROUP"); ok {
+		roleARN, _ := lookup("ELASTON_SCHEDULER_ROLE_ARN")
+		functionARN, _ := lookup("ELASTON_FUNCTION_ARN")
+		if roleARN == "" || functionARN == "" {
+			return nil, errors.New("ELASTON_SCHEDULE_GROUP requires ELASTON_SCHEDULER_ROLE_ARN and ELASTON_FUNCTION_ARN")
+		}
+		options = append(options, WithScheduling(Scheduling{Group: group, RoleARN: roleARN, FunctionARN: functionARN}))
+	}
+	return options, nil
+}
 
+func lambdaHandler(elaston *Elaston, handler Handler) func(context.Context, json.RawMessage) (any, error) {
 	return func(ctx context.Context, rawPayload json.RawMessage) (any, error) {
-		var payload interface{}
+		// In case the function was invoked through SQS, process every message in the batch
+		sqsEvent := events.SQSEvent{}
+		if err := json.Unmarshal(rawPayload, &sqsEvent); err == nil && len(sqsEvent.Records) > 0 && sqsEvent.Records[0].EventSource == "aws:sqs" {
+			defer elaston.flushTraces(ctx)
+			return handleSQSBatch(ctx, elaston, handler, sqsEvent.Records)
+		}
 
-		// In case the function was invoked through SQS, unwrap the message payload
-		sqsMessages := struct {
-			Records []sqsT.Message
-		}{}
-		if err := json.Unmarshal(rawPayload, &sqsMessages); err == nil && len(sqsMessages.Records) > 0 && isSQSMessage(&sqsMessages.Records[0]) {
-			rawPayload = []byte(*sqsMessages.Records[0].Body)
+		var requestID string
+		if lambdaCtx, ok := lambdacontext.FromContext(ctx); ok {
+			requestID = lambdaCtx.AwsRequestID
+		}
+		defer elaston.flushTraces(ctx)
+		out, req, err := handle(ctx, elaston, handler, messageFromInvokePayload(rawPayload), request{jobID: requestID})
+		if err != nil {
+			return nil, lambdaError(err)
 		}
+		elaston.release(ctx, req.pointer)
+		return encodeResponse(ctx, elaston, req, out)
+	}
+}
 
-		if err := json.Unmarshal(rawPayload, &payload); err != nil {
-			return nil, err
+// encodeResponse encodes the handler output for the synchronous invocation response,
+// compressing it if the caller accepts it and offloading it to s3 if it is too large
+// for lambda to return
+func encodeResponse(ctx context.Context, elaston *Elaston, req request, out any) (json.RawMessage, error) {
+	payload, err := req.codec.Marshal(out)
+	if err != nil {
+		return nil, err
+	}
+	msg := message{body: payload}
+	msg.setContentType(req.codec)
+	if msg, err = elaston.responseCompression(req.headers).compress(msg); err != nil {
+		return nil, err
+	}
+	if msg, err = elaston.security.seal(ctx, msg); err != nil {
+		return nil, err
+	}
+	if msg, err = elaston.offload(ctx, msg, msg.invokeSize(), maxInvokePayloadSize); err != nil {
+		return nil, err
+	}
+	return msg.invokePayload()
+}
+
+// handleSQSBatch runs the handler for every message in the batch, with up to
+// batchParallelism messages being processed at the same time. Failed messages are
+// reported back to lambda so only those are retried
+func handleSQSBatch(ctx context.Context, elaston *Elaston, handler Handler, msgs []events.SQSMessage) (any, error) {
+	parallelism := elaston.batchParallelism
+	if parallelism < 1 {
+		parallelism = 1
+	}
+
+	// Messages from the same fifo message group are processed in order, one at a
+	// time. Once one fails the rest of its group is failed as well without being
+	// processed, so sqs redelivers them in order
+	groups := [][]int{}
+	groupIndexes := map[string]int{}
+	for i := range msgs {
+		groupID := msgs[i].Attributes["MessageGroupId"]
+		if groupID == "" {
+			groups = append(groups, []int{i})
+			continue
+		}
+		idx, ok := groupIndexes[groupID]
+		if !ok {
+			idx = len(groups)
+			groupIndexes[groupID] = idx
+			groups = append(groups, nil)
+		}
+		groups[idx] = append(groups[idx], i)
+	}
+
+	errs := make([]error, len(msgs))
+	semaphore := make(chan struct{}, parallelism)
+	wg := sync.WaitGroup{}
+	for _, group := range groups {
+		semaphore <- struct{}{}
+		wg.Add(1)
+		go func(group []int) {
+			defer func() {
+				<-semaphore
+				wg.Done()
+			}()
+			for n, i := range group {
+				errs[i] = handleSQSMessage(ctx, elaston, handler, &msgs[i])
+				if errs[i] == nil {
+					continue
+				}
+				for _, skipped := range group[n+1:] {
+					errs[skipped] = fmt.Errorf("not processed as message %s of the same group failed", msgs[i].MessageId)
+				}
+				return
+			}
+		}(group)
+	}
+	wg.Wait()
+
+	response := events.SQSEventResponse{BatchItemFailures: []events.SQSBatchItemFailure{}}
+	var firstErr error
+	for i, err := range errs {
+		if err == nil {
+			continue
 		}
-		return handler.Handle(ctx, elaston, payload)
+		elaston.logf("failed to process sqs message %s: %v", msgs[i].MessageId, err)
+		if firstErr == nil {
+			firstErr = err
+		}
+		response.BatchItemFailures = append(
+			response.BatchItemFailures,
+			events.SQSBatchItemFailure{ItemIdentifier: msgs[i].MessageId},
+		)
 	}
+
+	// When nothing succeeded, fail the whole invocation so the failure is also
+	// visible in lambda metrics. The error is returned as is, as the lambda runtime
+	// only keeps the type and payload of errors it does not have to unwrap
+	if len(response.BatchItemFailures) == len(msgs) {
+		if len(msgs) > 1 {
+			elaston.logf("all %d messages in the batch failed", len(msgs))
+		}
+		return nil, lambdaError(firstErr)
+	}
+
+	return response, nil
 }
 
-func runTool() {
-	ctx, cancel := context.WithCancel(context.Background())
+func handleSQSMessage(ctx context.Context, elaston *Elaston, handler Handler, msg *events.SQSMessage) error {
+	decoded, err := messageFromSQS(msg)
+	if err != nil {
+		return err
+	}
+	out, req, err := handle(ctx, elaston, handler, decoded, request{jobID: msg.MessageId, fromSQS: true})
+	if req.duplicate {
+		elaston.logf("skipping sqs message %s: duplicate of an already processed message", msg.MessageId)
+		elaston.release(ctx, req.pointer)
+		return nil
+	}
+
+	resultKey, ok := req.headers[resultKeyAttribute]
+	if !ok || elaston.results == nil {
+		if errors.Is(err, ErrJobExpired) {
+			// Retrying would not help, so drop the message
+			elaston.logf("dropping sqs message %s: %v", msg.MessageId, err)
+			elaston.release(ctx, req.pointer)
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		return processed(ctx, elaston, req)
+	}
+
+	// The submitter is waiting on a future, so the outcome is delivered through the
+	// result store. Failures only once retrying is pointless, otherwise the message is
+	// left for sqs to deliver again
+	result := Result{}
+	if err != nil {
+		if !isPermanentJobError(err) && !isLastAttempt(elaston, msg) {
+			return err
+		}
+		result.Error = newResultError(err)
+	} else {
+		if result.Output, err = req.codec.Marshal(out); err != nil {
+			return err
+		}
+		result.ContentType = req.codec.ContentType()
+	}
+	if result, err = sealResult(ctx, elaston.security, result); err != nil {
+		return err
+	}
+	if err := elaston.results.PutResult(ctx, resultKey, result); err != nil {
+		return err
+	}
+	return processed(ctx, elaston, req)
+}
+
+// processed wraps up a message whose outcome is final. The offloaded payload is
+// only released once the message is marked as processed, as until then sqs may
+// deliver it again
+func processed(ctx context.Context, elaston *Elaston, req request) error {
+	if err := markProcessed(ctx, elaston, req); err != nil {
+		return err
+	}
+	elaston.release(ctx, req.pointer)
+	return nil
+}
+
+// isPermanentJobError tells whether a job that failed with err would fail the same
+// way if retried. Structured errors returned by handlers are considered final
+func isPermanentJobError(err error) bool {
+	var typed *Error
+	var limitErr *LimitError
+	var decodeErr *DecodeError
+	return errors.Is(err, ErrJobExpired) ||
+		errors.Is(err, ErrUnsigned) ||
+		errors.Is(err, ErrInvalidSignature) ||
+		errors.As(err, &typed) ||
+		errors.As(err, &limitErr) ||
+		errors.As(err, &decodeErr)
+}
+
+// isLastAttempt tells whether the queue moves the message to the dead-letter queue
+// if this attempt fails
+func isLastAttempt(elaston *Elaston, msg *events.SQSMessage) bool {
+	if elaston.maxReceiveCount <= 0 {
+		return true
+	}
+	receiveCount, _ := strconv.Atoi(msg.Attributes["ApproximateReceiveCount"])
+	return receiveCount >= elaston.maxReceiveCount
+}
+
+// markProcessed records the dedup id of the message, if any, so later duplicates
+// are skipped
+func markProcessed(ctx context.Context, elaston *Elaston, req request) error {
+	dedupID := req.headers[dedupIDHeader]
+	if dedupID == "" || elaston.dedup == nil {
+		return nil
+	}
+	return elaston.dedup.Mark(ctx, dedupID)
+}
+
+// request holds what the runtime learned about a message while decoding it, which
+// is needed again when encoding the output
+type request struct {
+	// jobID identifies the message, becoming the job id in the propagated metadata
+	jobID   string
+	fromSQS bool
+	headers map[string]string
+	// codec is the one the payload was encoded with, also used for the output
+	codec Codec
+	// duplicate is set when the message was already processed and got skipped
+	duplicate bool
+	// pointer is where the payload was offloaded to, released once it is not needed
+	pointer *s3Pointer
+}
+
+// handle decodes the message and calls the handler with its payload
+func handle(ctx context.Context, elaston *Elaston, handler Handler, msg message, req request) (_ any, _ request, err error) {
+	req.headers = msg.headers
+	msg, req.pointer, err = elaston.resolve(ctx, msg)
+	if err != nil {
+		return nil, req, err
+	}
+	if msg, err = elaston.security.open(ctx, msg); err != nil {
+		return nil, req, err
+	}
+	if msg, err = decompress(msg); err != nil {
+		return nil, req, err
+	}
+	req.headers = msg.headers
+	ctx = withRoute(ctx, msg.headers[routeHeader])
+
+	if dedupID := msg.headers[dedupIDHeader]; dedupID != "" && elaston.dedup != nil {
+		if req.duplicate, err = elaston.dedup.Seen(ctx, dedupID); err != nil || req.duplicate {
+			return nil, req, err
+		}
+	}
+
+	ctx, cancel, err := restoreMetadata(ctx, msg.headers, req.jobID)
 	defer cancel()
+	if err != nil {
+		return nil, req, err
+	}
+	// Clients already enforce limits, this catches the ones configured differently
+	if md, _ := MetadataFromContext(ctx); elaston.limits.MaxDepth > 0 && md.Depth > elaston.limits.MaxDepth {
+		err := &LimitError{RootJobID: md.RootJobID, Depth: md.Depth, MaxDepth: elaston.limits.MaxDepth}
+		elaston.logf("rejecting job %s: %v", req.jobID, err)
+		return nil, req, err
+	}
 
-	aws := aws.New("bcap")
+	ctx, span := elaston.startHandlerSpan(ctx, msg.headers, req.jobID, req.fromSQS)
+	defer func() { endSpan(span, err) }()
+	if md, ok := MetadataFromContext(ctx); ok && span.SpanContext().IsValid() {
+		headers := map[string]string{}
+		injectTraceContext(ctx, headers)
+		md.TraceParent = headers[traceParentHeader]
+		md.TraceState = headers[traceStateHeader]
+		ctx = ContextWithMetadata(ctx, md)
+	}
 
-	deployment, err := deploy.Deploy(ctx, aws, "elaston-test", programBytes(), 128)
-	panicOnErr(err)
+	if req.codec, err = codecFor(msg.headers); err != nil {
+		return nil, req, err
+	}
 
-	stream, err := aws.InvokeLambdaFunction(ctx, *deployment.Function.Configuration.FunctionName, map[string]any{"a": 1})
-	panicOnErr(err)
+	var outbox *outbox
+	if elaston.outbox {
+		ctx, outbox = withOutbox(ctx, req.jobID)
+	}
 
-	for ev := range stream.Reader.Events() {
-		switch v := ev.(type) {
-		case *lambdaT.InvokeWithResponseStreamResponseEventMemberInvokeComplete:
-			spew.Printf("lambda finished running: %v\n", v.Value)
-		case *lambdaT.InvokeWithResponseStreamResponseEventMemberPayloadChunk:
-			var stringValue string
-			var jsonValue any
-			if err := json.Unmarshal(v.Value.Payload, &jsonValue); err == nil {
-				data, _ := json.MarshalIndent(jsonValue, "", "  ")
-				stringValue = string(data)
-			} else {
-				stringValue = string(v.Value.Payload)
-			}
-			log.Printf("event from lambda: \n%v", stringValue)
+	out, err := callHandler(ctx, elaston, handler, req.codec, msg.body)
+	if err != nil {
+		return out, req, err
+	}
+	if outbox != nil {
+		if err = outbox.flush(ctx); err != nil {
+			return nil, req, err
 		}
 	}
+	elaston.releaseSchedule(ctx, msg.headers[scheduleHeader])
+	return out, req, nil
 }
 
-func programBytes() []byte {
-	f, err := os.OpenFile(os.Args[0], os.O_RDONLY, 0)
-	panicOnErr(err)
-	data, err := io.ReadAll(f)
-	panicOnErr(err)
-	return data
+func callHandler(ctx context.Context, elaston *Elaston, handler Handler, codec Codec, payload []byte) (any, error) {
+	if raw, ok := handler.(rawHandler); ok {
+		return raw.handleRaw(ctx, elaston, codec, payload)
+	}
+
+	var decoded any
+	if err := decode(codec, payload, &decoded); err != nil {
+		return nil, err
+	}
+	return handler.Handle(ctx, elaston, decoded)
 }
 
 func panicOnErr(err error) {
@@ -117,9 +442,3 @@ func panicOnErr(err error) {
 		panic(err)
 	}
 }
-
-func cleanup(ctx context.Context, deployment deploy.Deployment) {
-	if err := deployment.Clean(ctx, true); err != nil {
-		log.Printf("Failed to cleanup: %d errors: %v", len(err.Errors), err.Errors)
-	}
-}
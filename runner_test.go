@@ -0,0 +1,79 @@
+package elaston
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"testing"
+
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/aws/aws-lambda-go/lambda/messages"
+
+	"github.com/bcap/elaston/elastontest"
+)
+
+func TestHandleSQSBatch(t *testing.T) {
+	tests := []struct {
+		name string
+		// inputs of the messages, negative ones fail
+		inputs []int
+		// groups are the fifo message groups of the messages, if any
+		groups    []string
+		failures  []string
+		processed []int
+		// errType is the type of the lambda error when the whole batch failed
+		errType string
+	}{
+		{name: "all succeed", inputs: []int{1, 2, 3}, failures: []string{}, processed: []int{1, 2, 3}},
+		{name: "partial failure", inputs: []int{1, -2, 3}, failures: []string{"m1"}, processed: []int{1, -2, 3}},
+		{name: "all fail", inputs: []int{-1, -2}, errType: errorType, processed: []int{-1, -2}},
+		{name: "single failure", inputs: []int{-1}, errType: errorType, processed: []int{-1}},
+		// Messages after a failure in the same group are not handled, as that would
+		// break the order of the group
+		{name: "fifo group halted", inputs: []int{-1, 2, 3}, groups: []string{"a", "a", "b"}, failures: []string{"m0", "m1"}, processed: []int{-1, 3}},
+		{name: "fifo groups", inputs: []int{1, 2, 3}, groups: []string{"a", "b", "a"}, failures: []string{}, processed: []int{1, 2, 3}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			msgs := make([]events.SQSMessage, len(tt.inputs))
+			for i, in := range tt.inputs {
+				msgs[i] = *sqsEvent(fmt.Sprintf("m%d", i), fmt.Sprint(in), nil)
+				if tt.groups != nil {
+					msgs[i].Attributes["MessageGroupId"] = tt.groups[i]
+				}
+			}
+			received := attempts{}
+			handler := countdown(&received)
+			client := newTestClient(elastontest.New(), WithBatchParallelism(2))
+
+			response, err := handleSQSBatch(context.Background(), client, handler, msgs)
+
+			for _, in := range tt.processed {
+				if received.get(in) != 1 {
+					t.Fatalf("expected input %d to be processed once, got %d", in, received.get(in))
+				}
+			}
+			if total := len(received.counts); total != len(tt.processed) {
+				t.Fatalf("expected %d inputs processed, got %d", len(tt.processed), total)
+			}
+			if tt.errType != "" {
+				// The lambda runtime type asserts the error, it does not unwrap it
+				invokeErr, ok := err.(messages.InvokeResponse_Error)
+				if !ok || invokeErr.Type != tt.errType {
+					t.Fatalf("expected a lambda error of type %s, got %#v", tt.errType, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			failures := []string{}
+			for _, failure := range response.(events.SQSEventResponse).BatchItemFailures {
+				failures = append(failures, failure.ItemIdentifier)
+			}
+			if !reflect.DeepEqual(failures, tt.failures) {
+				t.Fatalf("expected failures %v, got %v", tt.failures, failures)
+			}
+		})
+	}
+}
@@ -0,0 +1,107 @@
+package elaston
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/bcap/elaston/aws"
+)
+
+// maxSQSDelay is the longest delay sqs supports. Longer ones are implemented with
+// one-time eventbridge schedules
+const maxSQSDelay = 15 * time.Minute
+
+const scheduleHeader = "elaston-schedule"
+
+var ErrNoScheduling = errors.New("delays longer than 15 minutes require scheduling to be configured")
+
+var ErrFIFODelay = errors.New("fifo queues do not support per message delays")
+
+// Scheduling configures where SubmitAfter creates the schedules for delays longer
+// than sqs supports. Deploy provisions all of it and configures the runtime with it
+type Scheduling struct {
+	// Group is the eventbridge scheduler schedule group
+	Group string
+	// RoleARN is the role the scheduler assumes to invoke the function
+	RoleARN string
+	// FunctionARN is the function the schedules invoke
+	FunctionARN string
+}
+
+func WithScheduling(scheduling Scheduling) Option {
+	return func(e *elaston) {
+		e.scheduling = &scheduling
+	}
+}
+
+// SubmitAfter is like Submit but the job is only processed after the given delay.
+// Delays up to 15 minutes are handled by sqs and the message id is returned. Longer
+// ones create a one-time schedule that invokes the function directly, returning the
+// schedule name, and require scheduling to be configured. FIFO queues do not support
+// delays and return ErrFIFODelay
+func (e *Elaston) SubmitAfter(ctx context.Context, in any, delay time.Duration, options ...Option) (string, error) {
+	return e.with(options).send(ctx, in, nil, delay)
+}
+
+// SubmitAfter is the type-safe version of Elaston.SubmitAfter
+func SubmitAfter[In any](ctx context.Context, elaston *Elaston, in In, delay time.Duration, options ...Option) (string, error) {
+	return elaston.SubmitAfter(ctx, in, delay, options...)
+}
+
+// needsSchedule tells if a message delayed for the given duration needs to be
+// scheduled, as sqs cannot delay it for so long. The local backend delays messages
+// for any duration
+func (e *Elaston) needsSchedule(delay time.Duration) bool {
+	return delay > maxSQSDelay && e.local == nil
+}
+
+// scheduleName returns the name of the one-time schedule for a message with the given id
+func scheduleName(id string) string {
+	if len(id) > 32 {
+		id = id[:32]
+	}
+	return "elaston-" + id
+}
+
+// schedule creates a one-time schedule invoking the function with the message once
+// the delay passes. The schedule is named after the message schedule header
+func (e *Elaston) schedule(ctx context.Context, msg message, delay time.Duration) error {
+	if e.scheduling == nil {
+		return ErrNoScheduling
+	}
+	payload, err := msg.invokePayload()
+	if err != nil {
+		return err
+	}
+	at := time.Now().Add(delay).UTC().Format("2006-01-02T15:04:05")
+	schedule := aws.Schedule{
+		Name:       msg.headers[scheduleHeader],
+		Expression: "at(" + at + ")",
+		TargetARN:  e.scheduling.FunctionARN,
+		RoleARN:    e.scheduling.RoleARN,
+		Input:      string(payload),
+	}
+	return e.retryPolicy.do(ctx, func(ctx context.Context) error {
+		return e.aws.CreateSchedule(ctx, e.scheduling.Group, schedule)
+	})
+}
+
+// releaseSchedule deletes the one-time schedule that delivered a message, as they
+// are kept around after running
+func (e *Elaston) releaseSchedule(ctx context.Context, name string) {
+	if name == "" || e.scheduling == nil {
+		return
+	}
+	if err := e.aws.DeleteSchedule(ctx, e.scheduling.Group, name); err != nil {
+		e.logf("failed to delete schedule %s: %v", name, err)
+	}
+}
+
+// delaySeconds rounds the delay up to whole seconds, as sqs expects it
+func delaySeconds(delay time.Duration) int32 {
+	if delay <= 0 {
+		return 0
+	}
+	return int32((delay + time.Second - 1) / time.Second)
+}
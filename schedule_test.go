@@ -0,0 +1,92 @@
+package elaston
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/service/sqs"
+
+	"github.com/bcap/elaston/elastontest"
+)
+
+func TestSubmitAfter(t *testing.T) {
+	scheduling := Scheduling{Group: "group", RoleARN: "role", FunctionARN: "function"}
+	tests := []struct {
+		name      string
+		queueURL  string
+		delay     time.Duration
+		options   []Option
+		err       error
+		delayed   int32
+		scheduled bool
+	}{
+		{name: "sqs delay", delay: 5 * time.Minute, delayed: 300},
+		{name: "scheduled delay", delay: 2 * time.Hour, options: []Option{WithScheduling(scheduling)}, scheduled: true},
+		{name: "long delay without scheduling", delay: 2 * time.Hour, err: ErrNoScheduling},
+		{
+			name:     "fifo queue",
+			queueURL: testQueueURL + ".fifo",
+			delay:    time.Minute,
+			options:  []Option{WithMessageGroup("group")},
+			err:      ErrFIFODelay,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := context.Background()
+			fakes := elastontest.New()
+			client := newTestClient(fakes, tt.options...)
+			if tt.queueURL != "" {
+				client = New(fakes.AWS(), "function", tt.queueURL, append([]Option{WithLogger(client.logger)}, tt.options...)...)
+			}
+
+			id, err := client.SubmitAfter(ctx, "later", tt.delay)
+			if !errors.Is(err, tt.err) {
+				t.Fatalf("expected error %v, got %v", tt.err, err)
+			}
+			if tt.err != nil {
+				if calls := fakes.SQS.Calls(); len(calls) != 0 {
+					t.Fatalf("expected nothing sent, got %d calls", len(calls))
+				}
+				return
+			}
+
+			schedules := fakes.Scheduler.Schedules("group")
+			if tt.scheduled != (len(schedules) == 1) {
+				t.Fatalf("expected scheduled to be %v, got schedules %v", tt.scheduled, schedules)
+			}
+			if !tt.scheduled {
+				input := fakes.SQS.CallsTo("SendMessage")[0].Input.(*sqs.SendMessageInput)
+				if input.DelaySeconds != tt.delayed {
+					t.Fatalf("expected a delay of %d seconds, got %d", tt.delayed, input.DelaySeconds)
+				}
+				return
+			}
+
+			if id != schedules[0] {
+				t.Fatalf("expected the schedule name %s to be returned, got %s", schedules[0], id)
+			}
+			schedule := fakes.Scheduler.Schedule("group", id)
+			if !strings.HasPrefix(*schedule.ScheduleExpression, "at(") || *schedule.Target.Arn != "function" {
+				t.Fatalf("unexpected schedule %s targeting %s", *schedule.ScheduleExpression, *schedule.Target.Arn)
+			}
+
+			// Once the schedule delivers the message, it is deleted
+			handler, inputs := recordingHandler(nil, nil)
+			runtime := newTestClient(fakes, WithScheduling(scheduling))
+			if _, err := lambdaHandler(runtime, handler)(ctx, json.RawMessage(*schedule.Target.Input)); err != nil {
+				t.Fatal(err)
+			}
+			if got := <-inputs; got != "later" {
+				t.Fatalf("handler received %v", got)
+			}
+			if schedules := fakes.Scheduler.Schedules("group"); len(schedules) != 0 {
+				t.Fatalf("expected the schedule to be deleted, got %v", schedules)
+			}
+		})
+	}
+}
@@ -0,0 +1,274 @@
+package elaston
+
+import (
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/bcap/elaston/aws"
+)
+
+const (
+	// keyIDHeader identifies the key used to sign and possibly encrypt the message
+	keyIDHeader = "elaston-key-id"
+	// signatureHeader carries the HMAC-SHA256 of the message headers and body
+	signatureHeader = "elaston-signature"
+	// encryptionHeader is set when the body is encrypted
+	encryptionHeader = "elaston-encryption"
+
+	encryptionAESGCM = "aes-gcm"
+)
+
+var (
+	ErrUnsigned         = errors.New("elaston: message is not signed")
+	ErrInvalidSignature = errors.New("elaston: invalid message signature")
+)
+
+// KeyProvider provides the keys used to encrypt and sign payloads
+type KeyProvider interface {
+	// EncryptionKey returns the key to protect a new message with, along with an
+	// identifier that DecryptionKey can later resolve back to the same key
+	EncryptionKey(ctx context.Context) (key []byte, keyID string, err error)
+	DecryptionKey(ctx context.Context, keyID string) ([]byte, error)
+}
+
+// Security configures payload signing and encryption. When configured, every
+// message is HMAC signed and anything received without a valid signature is
+// rejected before it reaches the handler
+type Security struct {
+	Keys KeyProvider
+	// Encrypt also encrypts payloads with AES-GCM. Otherwise they are only signed
+	Encrypt bool
+}
+
+// seal encrypts the message body if configured and then signs the message. It
+// must be the last transformation before the message is sent
+func (s *Security) seal(ctx context.Context, msg message) (message, error) {
+	if s == nil {
+		return msg, nil
+	}
+	key, keyID, err := s.Keys.EncryptionKey(ctx)
+	if err != nil {
+		return msg, err
+	}
+
+	result := message{body: msg.body}
+	for k, v := range msg.headers {
+		result.setHeader(k, v)
+	}
+	result.setHeader(keyIDHeader, keyID)
+
+	if s.Encrypt {
+		if result.body, err = encrypt(key, msg.body); err != nil {
+			return msg, err
+		}
+		result.setHeader(encryptionHeader, encryptionAESGCM)
+	}
+
+	result.setHeader(signatureHeader, sign(key, result))
+	return result, nil
+}
+
+// open verifies the message signature and decrypts its body. Messages that are not
+// signed are rejected
+func (s *Security) open(ctx context.Context, msg message) (message, error) {
+	if s == nil {
+		if _, ok := msg.headers[encryptionHeader]; ok {
+			return msg, errors.New("elaston: received an encrypted message but no key provider is configured")
+		}
+		return msg, nil
+	}
+
+	signature, ok := msg.headers[signatureHeader]
+	if !ok {
+		return msg, ErrUnsigned
+	}
+	key, err := s.Keys.DecryptionKey(ctx, msg.headers[keyIDHeader])
+	if err != nil {
+		return msg, err
+	}
+	if !hmac.Equal([]byte(signature), []byte(sign(key, msg))) {
+		return msg, ErrInvalidSignature
+	}
+
+	result := message{body: msg.body}
+	for k, v := range msg.headers {
+		if k != signatureHeader && k != keyIDHeader && k != encryptionHeader {
+			result.setHeader(k, v)
+		}
+	}
+
+	switch algorithm := msg.headers[encryptionHeader]; algorithm {
+	case "":
+	case encryptionAESGCM:
+		if result.body, err = decrypt(key, msg.body); err != nil {
+			return msg, err
+		}
+	default:
+		return msg, fmt.Errorf("elaston: unsupported encryption %q", algorithm)
+	}
+	return result, nil
+}
+
+// sign computes the message signature over all headers but the signature itself,
+// in a canonical order, plus the body
+func sign(key []byte, msg message) string {
+	keys := make([]string, 0, len(msg.headers))
+	for k := range msg.headers {
+		if k != signatureHeader {
+			keys = append(keys, k)
+		}
+	}
+	sort.Strings(keys)
+
+	mac := hmac.New(sha256.New, signingKey(key))
+	for _, k := range keys {
+		fmt.Fprintf(mac, "%s=%s\n", k, msg.headers[k])
+	}
+	mac.Write([]byte{'\n'})
+	mac.Write(msg.body)
+	return base64.StdEncoding.EncodeToString(mac.Sum(nil))
+}
+
+// signingKey derives the HMAC key from the encryption key, so the same key is never
+// used for two different purposes
+func signingKey(key []byte) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte("elaston-signing-key"))
+	return mac.Sum(nil)
+}
+
+func encrypt(key []byte, plaintext []byte) ([]byte, error) {
+	gcm, err := newGCM(key)
+	if err != nil {
+		return nil, err
+	}
+	nonce := make([]byte, gcm.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+	return gcm.Seal(nonce, nonce, plaintext, nil), nil
+}
+
+func decrypt(key []byte, ciphertext []byte) ([]byte, error) {
+	gcm, err := newGCM(key)
+	if err != nil {
+		return nil, err
+	}
+	if len(ciphertext) < gcm.NonceSize() {
+		return nil, errors.New("elaston: encrypted payload too short")
+	}
+	nonce, ciphertext := ciphertext[:gcm.NonceSize()], ciphertext[gcm.NonceSize():]
+	return gcm.Open(nil, nonce, ciphertext, nil)
+}
+
+func newGCM(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+//
+// Static keys
+//
+
+// StaticKeyProvider always uses the same key. Meant for tests and local development
+type StaticKeyProvider struct {
+	KeyID string
+	// Key must be 16, 24 or 32 bytes long, selecting AES-128, AES-192 or AES-256
+	Key []byte
+}
+
+func NewStaticKeyProvider(keyID string, key []byte) *StaticKeyProvider {
+	return &StaticKeyProvider{KeyID: keyID, Key: key}
+}
+
+func (p *StaticKeyProvider) EncryptionKey(ctx context.Context) ([]byte, string, error) {
+	return p.Key, p.KeyID, nil
+}
+
+func (p *StaticKeyProvider) DecryptionKey(ctx context.Context, keyID string) ([]byte, error) {
+	if keyID != p.KeyID {
+		return nil, fmt.Errorf("elaston: unknown key id %q", keyID)
+	}
+	return p.Key, nil
+}
+
+//
+// KMS
+//
+
+// KMSKeyProvider uses envelope encryption: payloads are protected with data keys
+// generated by kms, and the encrypted data key travels along as the key id. Data
+// keys are reused for a while to avoid a kms request per message
+type KMSKeyProvider struct {
+	aws      *aws.AWS
+	kmsKeyID string
+	// DataKeyTTL is how long a data key is reused for new messages. Defaults to 5 minutes
+	DataKeyTTL time.Duration
+
+	current   []byte
+	currentID string
+	expires   time.Time
+	decrypted map[string][]byte
+	mutex     sync.Mutex
+}
+
+func NewKMSKeyProvider(aws *aws.AWS, kmsKeyID string) *KMSKeyProvider {
+	return &KMSKeyProvider{
+		aws:        aws,
+		kmsKeyID:   kmsKeyID,
+		DataKeyTTL: 5 * time.Minute,
+		decrypted:  map[string][]byte{},
+	}
+}
+
+func (p *KMSKeyProvider) EncryptionKey(ctx context.Context) ([]byte, string, error) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	if p.current != nil && time.Now().Before(p.expires) {
+		return p.current, p.currentID, nil
+	}
+	key, encryptedKey, err := p.aws.GenerateDataKey(ctx, p.kmsKeyID)
+	if err != nil {
+		return nil, "", err
+	}
+	p.current = key
+	p.currentID = base64.StdEncoding.EncodeToString(encryptedKey)
+	p.expires = time.Now().Add(p.DataKeyTTL)
+	p.decrypted[p.currentID] = key
+	return p.current, p.currentID, nil
+}
+
+func (p *KMSKeyProvider) DecryptionKey(ctx context.Context, keyID string) ([]byte, error) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	if key, ok := p.decrypted[keyID]; ok {
+		return key, nil
+	}
+	encryptedKey, err := base64.StdEncoding.DecodeString(keyID)
+	if err != nil {
+		return nil, fmt.Errorf("elaston: invalid key id: %w", err)
+	}
+	key, err := p.aws.DecryptDataKey(ctx, encryptedKey)
+	if err != nil {
+		return nil, err
+	}
+	// Bound the cache, as long running processes can see many data keys
+	if len(p.decrypted) > 1000 {
+		p.decrypted = map[string][]byte{}
+	}
+	p.decrypted[keyID] = key
+	return key, nil
+}
@@ -0,0 +1,164 @@
+package elaston
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/bcap/elaston/elastontest"
+)
+
+func TestKMSKeyProvider(t *testing.T) {
+	tests := []struct {
+		name    string
+		encrypt bool
+	}{
+		{name: "signed", encrypt: false},
+		{name: "encrypted", encrypt: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := context.Background()
+			fakes := elastontest.New()
+			security := func() Option {
+				return WithSecurity(Security{Keys: NewKMSKeyProvider(fakes.AWS(), "alias/key"), Encrypt: tt.encrypt})
+			}
+			client := newTestClient(fakes, security())
+			for i := 0; i < 3; i++ {
+				if _, err := client.Submit(ctx, "secret input"); err != nil {
+					t.Fatal(err)
+				}
+			}
+			// Data keys are reused across messages
+			if calls := fakes.KMS.CallsTo("GenerateDataKey"); len(calls) != 1 {
+				t.Fatalf("expected 1 data key to be generated, got %d", len(calls))
+			}
+
+			runtime := newTestClient(fakes, security())
+			handler, inputs := recordingHandler(nil, nil)
+			for _, msg := range sentMessages(t, fakes) {
+				if encrypted := !strings.Contains(msg.Body, "secret input"); encrypted != tt.encrypt {
+					t.Fatalf("expected encrypted to be %v, got body %q", tt.encrypt, msg.Body)
+				}
+				if err := handleSQSMessage(ctx, runtime, handler, msg); err != nil {
+					t.Fatal(err)
+				}
+				if got := <-inputs; got != "secret input" {
+					t.Fatalf("handler received %v", got)
+				}
+			}
+			// Decrypted data keys are cached by the receiving side as well
+			if calls := fakes.KMS.CallsTo("Decrypt"); len(calls) != 1 {
+				t.Fatalf("expected 1 data key to be decrypted, got %d", len(calls))
+			}
+		})
+	}
+}
+
+func TestSealAndOpen(t *testing.T) {
+	key := []byte("0123456789abcdef")
+	tests := []struct {
+		name    string
+		encrypt bool
+		// tamper changes the sealed message before opening it
+		tamper func(msg *message)
+		opener *Security
+		err    error
+	}{
+		{name: "signed"},
+		{name: "encrypted", encrypt: true},
+		{
+			name:   "tampered body",
+			tamper: func(msg *message) { msg.body = []byte(`"other"`) },
+			err:    ErrInvalidSignature,
+		},
+		{
+			name:   "tampered header",
+			tamper: func(msg *message) { msg.setHeader("team", "other") },
+			err:    ErrInvalidSignature,
+		},
+		{
+			name:    "tampered ciphertext",
+			encrypt: true,
+			tamper:  func(msg *message) { msg.body[0] ^= 0xff },
+			err:     ErrInvalidSignature,
+		},
+		{
+			name:   "unsigned",
+			tamper: func(msg *message) { delete(msg.headers, signatureHeader) },
+			err:    ErrUnsigned,
+		},
+		{
+			name:   "different key",
+			opener: &Security{Keys: NewStaticKeyProvider("key", []byte("fedcba9876543210"))},
+			err:    ErrInvalidSignature,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := context.Background()
+			security := &Security{Keys: NewStaticKeyProvider("key", key), Encrypt: tt.encrypt}
+			original := message{headers: map[string]string{"team": "data"}, body: []byte(`"secret input"`)}
+
+			sealed, err := security.seal(ctx, original)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if encrypted := !bytes.Equal(sealed.body, original.body); encrypted != tt.encrypt {
+				t.Fatalf("expected encrypted to be %v, got body %q", tt.encrypt, sealed.body)
+			}
+			if tt.tamper != nil {
+				tt.tamper(&sealed)
+			}
+			opener := security
+			if tt.opener != nil {
+				opener = tt.opener
+			}
+
+			opened, err := opener.open(ctx, sealed)
+			if !errors.Is(err, tt.err) {
+				t.Fatalf("expected error %v, got %v", tt.err, err)
+			}
+			if tt.err == nil {
+				assertSameMessage(t, original, opened)
+			}
+		})
+	}
+}
+
+func TestRuntimeRejectsUnverifiedMessages(t *testing.T) {
+	runtimeSecurity := Security{Keys: NewStaticKeyProvider("key", []byte("0123456789abcdef"))}
+	tests := []struct {
+		name   string
+		client []Option
+		err    error
+	}{
+		{name: "verified", client: []Option{WithSecurity(runtimeSecurity)}},
+		{name: "unsigned", err: ErrUnsigned},
+		{
+			name:   "signed with another key",
+			client: []Option{WithSecurity(Security{Keys: NewStaticKeyProvider("key", []byte("fedcba9876543210"))})},
+			err:    ErrInvalidSignature,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := context.Background()
+			fakes := elastontest.New()
+			if _, err := newTestClient(fakes, tt.client...).Submit(ctx, "input"); err != nil {
+				t.Fatal(err)
+			}
+			handler, inputs := recordingHandler(nil, nil)
+			runtime := newTestClient(fakes, WithSecurity(runtimeSecurity))
+			err := handleSQSMessage(ctx, runtime, handler, sentMessages(t, fakes)[0])
+			if !errors.Is(err, tt.err) {
+				t.Fatalf("expected error %v, got %v", tt.err, err)
+			}
+			if handled := len(inputs) == 1; handled != (tt.err == nil) {
+				t.Fatalf("expected the handler to run only for verified messages, got error %v", err)
+			}
+		})
+	}
+}
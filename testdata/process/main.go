@@ -0,0 +1,49 @@
+// Command process is the function run by the tests of NewLocalProcess. It echoes its
+// input, except for "fail", which fails, and "sleep", which outlives any timeout
+package main
+
+import (
+	"bytes"
+	"context"
+	"net/http"
+	"os"
+	"time"
+
+	"github.com/bcap/elaston"
+)
+
+func main() {
+	if message, ok := os.LookupEnv("ELASTON_TEST_INIT_ERROR"); ok {
+		reportInitError(message)
+		// Lambda stops environments failing to initialize, the tests do it on close
+		time.Sleep(time.Hour)
+	}
+	elaston.Run(elaston.TypedFunc(handle))
+}
+
+func handle(ctx context.Context, e *elaston.Elaston, in string) (string, error) {
+	switch in {
+	case "fail":
+		return "", elaston.NewError("failed", "failed as asked", in)
+	case "sleep":
+		time.Sleep(time.Hour)
+	}
+	return in, nil
+}
+
+// reportInitError tells the runtime api the initialization failed, as runtimes do
+// when they cannot start the handler
+func reportInitError(message string) {
+	body := []byte(`{"errorMessage":"` + message + `","errorType":"InitError"}`)
+	url := "http://" + os.Getenv("AWS_LAMBDA_RUNTIME_API") + "/2018-06-01/runtime/init/error"
+	req, err := http.NewRequest(http.MethodPost, url, bytes.NewReader(body))
+	if err != nil {
+		panic(err)
+	}
+	req.Header.Set("Lambda-Runtime-Function-Error-Type", "InitError")
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		panic(err)
+	}
+	resp.Body.Close()
+}
@@ -0,0 +1,166 @@
+package elaston
+
+import (
+	"context"
+	"io"
+	"sync/atomic"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/exporters/stdout/stdouttrace"
+	"go.opentelemetry.io/otel/propagation"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/trace"
+)
+
+const tracerName = "github.com/bcap/elaston"
+
+// coldStart is true until the runtime handles its first invocation
+var coldStart atomic.Bool
+
+func init() {
+	coldStart.Store(true)
+}
+
+// WithTracerProvider sets the OpenTelemetry tracer provider used for client and
+// runtime spans. Defaults to the global one, which does nothing unless configured
+func WithTracerProvider(provider trace.TracerProvider) Option {
+	return func(e *elaston) {
+		e.tracerProvider = provider
+	}
+}
+
+// NewStdoutTracerProvider returns a tracer provider that writes spans as json to
+// the given writer. In lambda, writing to stdout sends them to cloudwatch logs
+func NewStdoutTracerProvider(w io.Writer) (*sdktrace.TracerProvider, error) {
+	exporter, err := stdouttrace.New(stdouttrace.WithWriter(w))
+	if err != nil {
+		return nil, err
+	}
+	return sdktrace.NewTracerProvider(sdktrace.WithSyncer(exporter)), nil
+}
+
+func (e *Elaston) tracer() trace.Tracer {
+	provider := e.tracerProvider
+	if provider == nil {
+		provider = otel.GetTracerProvider()
+	}
+	return provider.Tracer(tracerName)
+}
+
+// startCallSpan starts the client span around Call
+func (e *Elaston) startCallSpan(ctx context.Context) (context.Context, trace.Span) {
+	return e.tracer().Start(
+		ctx, "elaston.Call",
+		trace.WithSpanKind(trace.SpanKindClient),
+		trace.WithAttributes(
+			attribute.String("faas.invoked_name", e.functionName),
+			attribute.String("faas.invoked_provider", "aws"),
+			attribute.String("elaston.route", e.route),
+		),
+	)
+}
+
+// startSubmitSpan starts the producer span around Submit
+func (e *Elaston) startSubmitSpan(ctx context.Context) (context.Context, trace.Span) {
+	return e.tracer().Start(
+		ctx, "elaston.Submit",
+		trace.WithSpanKind(trace.SpanKindProducer),
+		trace.WithAttributes(
+			attribute.String("messaging.system", "aws_sqs"),
+			attribute.String("messaging.destination.name", e.sqsQueueURL),
+			attribute.String("elaston.route", e.route),
+		),
+	)
+}
+
+// startHandlerSpan starts the span around a handler invocation. For calls the
+// caller span becomes the parent, while for sqs messages the producer span is
+// linked instead, as queueing may have happened long before
+func (e *Elaston) startHandlerSpan(ctx context.Context, headers map[string]string, jobID string, sqsMessage bool) (context.Context, trace.Span) {
+	remote := extractTraceContext(context.Background(), headers)
+	remoteSpan := trace.SpanContextFromContext(remote)
+
+	attributes := []attribute.KeyValue{
+		attribute.String("faas.name", e.functionName),
+		attribute.String("faas.invocation_id", jobID),
+		attribute.Bool("faas.coldstart", coldStart.Swap(false)),
+		attribute.String("elaston.route", headers[routeHeader]),
+	}
+	options := []trace.SpanStartOption{}
+	if sqsMessage {
+		attributes = append(
+			attributes,
+			attribute.String("faas.trigger", "pubsub"),
+			attribute.String("messaging.system", "aws_sqs"),
+			attribute.String("messaging.source.name", e.sqsQueueURL),
+			attribute.String("messaging.message.id", jobID),
+		)
+		options = append(options, trace.WithSpanKind(trace.SpanKindConsumer))
+		if remoteSpan.IsValid() {
+			options = append(options, trace.WithLinks(trace.Link{SpanContext: remoteSpan}))
+		}
+	} else {
+		attributes = append(attributes, attribute.String("faas.trigger", "other"))
+		options = append(options, trace.WithSpanKind(trace.SpanKindServer))
+		if remoteSpan.IsValid() {
+			ctx = trace.ContextWithRemoteSpanContext(ctx, remoteSpan)
+		}
+	}
+	options = append(options, trace.WithAttributes(attributes...))
+	return e.tracer().Start(ctx, "elaston.Handle", options...)
+}
+
+func endSpan(span trace.Span, err error) {
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	span.End()
+}
+
+// flushTraces exports pending spans before lambda freezes the execution environment
+func (e *Elaston) flushTraces(ctx context.Context) {
+	if flusher, ok := e.tracerProvider.(interface{ ForceFlush(context.Context) error }); ok {
+		if err := flusher.ForceFlush(ctx); err != nil {
+			e.logf("failed to flush traces: %v", err)
+		}
+	}
+}
+
+// headerCarrier maps the W3C trace context fields to elaston headers
+type headerCarrier map[string]string
+
+var carrierHeaders = map[string]string{
+	"traceparent": traceParentHeader,
+	"tracestate":  traceStateHeader,
+}
+
+func (c headerCarrier) Get(key string) string {
+	return c[carrierHeaders[key]]
+}
+
+func (c headerCarrier) Set(key string, value string) {
+	if header, ok := carrierHeaders[key]; ok {
+		c[header] = value
+	}
+}
+
+func (c headerCarrier) Keys() []string {
+	return []string{"traceparent", "tracestate"}
+}
+
+// injectTraceContext writes the trace context of the span in ctx into the headers.
+// It returns false if ctx has no valid span
+func injectTraceContext(ctx context.Context, headers map[string]string) bool {
+	if !trace.SpanContextFromContext(ctx).IsValid() {
+		return false
+	}
+	propagation.TraceContext{}.Inject(ctx, headerCarrier(headers))
+	return true
+}
+
+func extractTraceContext(ctx context.Context, headers map[string]string) context.Context {
+	return propagation.TraceContext{}.Extract(ctx, headerCarrier(headers))
+}
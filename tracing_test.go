@@ -0,0 +1,90 @@
+package elaston
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"go.opentelemetry.io/otel/trace"
+
+	"github.com/bcap/elaston/elastontest"
+)
+
+func TestTracing(t *testing.T) {
+	tests := []struct {
+		name   string
+		submit bool
+		input  int
+		// kinds are the kinds of the client and handler spans
+		client  trace.SpanKind
+		handler trace.SpanKind
+		err     bool
+	}{
+		{name: "call", client: trace.SpanKindClient, handler: trace.SpanKindServer},
+		{name: "failed call", input: -1, client: trace.SpanKindClient, handler: trace.SpanKindServer, err: true},
+		{name: "submit", submit: true, client: trace.SpanKindProducer, handler: trace.SpanKindConsumer},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+			defer cancel()
+			provider, exporter := elastontest.NewInMemoryTracerProvider()
+			local := newTestLocal(
+				t,
+				TypedFunc(func(ctx context.Context, e *Elaston, in int) (int, error) {
+					if in < 0 {
+						return 0, NewError("negative", "negative input", in)
+					}
+					return in, nil
+				}),
+				WithRuntimeOptions(WithTracerProvider(provider)),
+			)
+			client := local.Client(WithTracerProvider(provider))
+
+			if tt.submit {
+				if _, err := client.Submit(ctx, tt.input); err != nil {
+					t.Fatal(err)
+				}
+				if err := local.Drain(ctx); err != nil {
+					t.Fatal(err)
+				}
+			} else if _, err := client.Call(ctx, tt.input); (err != nil) != tt.err {
+				t.Fatalf("expected an error to be %v, got %v", tt.err, err)
+			}
+
+			clientSpan := findSpan(t, exporter.GetSpans(), tt.client)
+			handlerSpan := findSpan(t, exporter.GetSpans(), tt.handler)
+			traceID := clientSpan.SpanContext.TraceID()
+			if tt.submit {
+				// Queued jobs link to their producer instead of being its child
+				if handlerSpan.Parent.IsValid() {
+					t.Fatalf("expected the consumer span to start a new trace, got parent %v", handlerSpan.Parent)
+				}
+				if len(handlerSpan.Links) != 1 || handlerSpan.Links[0].SpanContext.SpanID() != clientSpan.SpanContext.SpanID() {
+					t.Fatalf("expected the consumer span to link to the producer, got %v", handlerSpan.Links)
+				}
+			} else if handlerSpan.SpanContext.TraceID() != traceID || handlerSpan.Parent.SpanID() != clientSpan.SpanContext.SpanID() {
+				t.Fatalf("expected the handler span to be a child of the client span")
+			}
+			for _, span := range []tracetest.SpanStub{clientSpan, handlerSpan} {
+				failed := span.Status.Code == codes.Error
+				if failed != tt.err {
+					t.Fatalf("expected span %s to have failed: %v, got status %v", span.Name, tt.err, span.Status)
+				}
+			}
+		})
+	}
+}
+
+func findSpan(t *testing.T, spans tracetest.SpanStubs, kind trace.SpanKind) tracetest.SpanStub {
+	t.Helper()
+	for _, span := range spans {
+		if span.SpanKind == kind {
+			return span
+		}
+	}
+	t.Fatalf("no %v span in %v", kind, spans)
+	return tracetest.SpanStub{}
+}
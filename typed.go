@@ -0,0 +1,108 @@
+package elaston
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+)
+
+// TypedHandler is the type-safe counterpart of Handler. The incoming payload is
+// decoded straight into In and the returned Out is encoded back to the caller
+type TypedHandler[In any, Out any] interface {
+	Handle(context.Context, *Elaston, In) (Out, error)
+}
+
+type TypedHandlerFunc[In any, Out any] func(context.Context, *Elaston, In) (Out, error)
+
+func (f TypedHandlerFunc[In, Out]) Handle(ctx context.Context, elaston *Elaston, in In) (Out, error) {
+	return f(ctx, elaston, in)
+}
+
+// Typed adapts a TypedHandler into a Handler so it can be passed to Run
+func Typed[In any, Out any](handler TypedHandler[In, Out]) Handler {
+	return typedHandler[In, Out]{handler: handler}
+}
+
+// TypedFunc is a shorthand for Typed(TypedHandlerFunc[In, Out](f))
+func TypedFunc[In any, Out any](f func(context.Context, *Elaston, In) (Out, error)) Handler {
+	return Typed[In, Out](TypedHandlerFunc[In, Out](f))
+}
+
+type typedHandler[In any, Out any] struct {
+	handler TypedHandler[In, Out]
+}
+
+// rawHandler is implemented by handlers that want to decode the payload
+// themselves instead of receiving it already decoded into an any
+type rawHandler interface {
+	handleRaw(context.Context, *Elaston, Codec, []byte) (any, error)
+}
+
+func (h typedHandler[In, Out]) Handle(ctx context.Context, elaston *Elaston, rawInput any) (any, error) {
+	in, ok := rawInput.(In)
+	if !ok {
+		// Not the expected type, so go through an encoding round trip to convert it
+		data, err := elaston.codec.Marshal(rawInput)
+		if err != nil {
+			return nil, err
+		}
+		if err := decode(elaston.codec, data, &in); err != nil {
+			return nil, err
+		}
+	}
+	return h.handler.Handle(ctx, elaston, in)
+}
+
+func (h typedHandler[In, Out]) handleRaw(ctx context.Context, elaston *Elaston, codec Codec, payload []byte) (any, error) {
+	var in In
+	if err := decode(codec, payload, &in); err != nil {
+		return nil, err
+	}
+	return h.handler.Handle(ctx, elaston, in)
+}
+
+// Call is the type-safe version of Elaston.Call. The lambda response is
+// decoded straight into Out
+func Call[In any, Out any](ctx context.Context, elaston *Elaston, in In, options ...Option) (Out, error) {
+	var out Out
+	elaston = elaston.with(options)
+	payload, codec, err := elaston.invoke(ctx, in)
+	// Asynchronous invocations have no response
+	if err != nil || len(payload) == 0 {
+		return out, err
+	}
+	err = decode(codec, payload, &out)
+	return out, err
+}
+
+// Submit is the type-safe version of Elaston.Submit
+func Submit[In any](ctx context.Context, elaston *Elaston, in In, options ...Option) (string, error) {
+	return elaston.Submit(ctx, in, options...)
+}
+
+// DecodeError is returned when a payload cannot be decoded into the expected type,
+// either when a handler receives its input or when a caller receives a result
+type DecodeError struct {
+	Type    reflect.Type
+	Payload []byte
+	Err     error
+}
+
+func (e *DecodeError) Error() string {
+	return fmt.Sprintf("failed to decode payload into %v: %v", e.Type, e.Err)
+}
+
+func (e *DecodeError) Unwrap() error {
+	return e.Err
+}
+
+func decode(codec Codec, payload []byte, out any) error {
+	if err := codec.Unmarshal(payload, out); err != nil {
+		return &DecodeError{
+			Type:    reflect.TypeOf(out).Elem(),
+			Payload: payload,
+			Err:     err,
+		}
+	}
+	return nil
+}
@@ -0,0 +1,96 @@
+package elaston
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestTypedCall(t *testing.T) {
+	double := TypedFunc(func(ctx context.Context, e *Elaston, in int) (int, error) {
+		return in * 2, nil
+	})
+	text := TypedFunc(func(ctx context.Context, e *Elaston, in int) (string, error) {
+		return "text", nil
+	})
+	tests := []struct {
+		name    string
+		handler Handler
+		call    func(context.Context, *Elaston) (any, error)
+		output  any
+		// decodeType is the type the caller failed to decode into, if any
+		decodeType reflect.Type
+		remote     string
+	}{
+		{
+			name:    "output",
+			handler: double,
+			call:    func(ctx context.Context, e *Elaston) (any, error) { return Call[int, int](ctx, e, 2) },
+			output:  4,
+		},
+		{
+			name:    "untyped caller",
+			handler: double,
+			call:    func(ctx context.Context, e *Elaston) (any, error) { return e.Call(ctx, 2) },
+			output:  float64(4),
+		},
+		{
+			name:       "output of another type",
+			handler:    text,
+			call:       func(ctx context.Context, e *Elaston) (any, error) { return Call[int, int](ctx, e, 2) },
+			decodeType: reflect.TypeOf(0),
+		},
+		{
+			name:    "input of another type",
+			handler: double,
+			call:    func(ctx context.Context, e *Elaston) (any, error) { return Call[string, int](ctx, e, "2") },
+			remote:  "failed to decode payload into int",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			local := newTestLocal(t, tt.handler)
+			out, err := tt.call(context.Background(), local.Client())
+
+			var decodeErr *DecodeError
+			var remoteErr *RemoteError
+			switch {
+			case tt.decodeType != nil:
+				if !errors.As(err, &decodeErr) || decodeErr.Type != tt.decodeType {
+					t.Fatalf("expected a *DecodeError into %v, got %v", tt.decodeType, err)
+				}
+			case tt.remote != "":
+				if !errors.As(err, &remoteErr) || !strings.Contains(remoteErr.Message, tt.remote) {
+					t.Fatalf("expected a remote error containing %q, got %v", tt.remote, err)
+				}
+			case err != nil:
+				t.Fatal(err)
+			case out != tt.output:
+				t.Fatalf("expected output %v, got %v", tt.output, out)
+			}
+		})
+	}
+}
+
+func TestTypedHandlerConvertsInput(t *testing.T) {
+	type point struct{ X, Y int }
+	handler := TypedFunc(func(ctx context.Context, e *Elaston, in point) (int, error) {
+		return in.X + in.Y, nil
+	})
+	client := New(nil, "function", testQueueURL)
+	// Inputs decoded as generic values go through a round trip into the handler type
+	out, err := handler.Handle(context.Background(), client, map[string]any{"X": 1, "Y": 2})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if out != 3 {
+		t.Fatalf("expected 3, got %v", out)
+	}
+
+	var decodeErr *DecodeError
+	if _, err := handler.Handle(context.Background(), client, "text"); !errors.As(err, &decodeErr) {
+		t.Fatalf("expected a *DecodeError, got %v", err)
+	}
+}